@@ -0,0 +1,273 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"craftcom/pkg/attach"
+	"craftcom/pkg/chunk"
+	"craftcom/pkg/types"
+)
+
+// maxSkippedListed limits how many skipped files are named
+const maxSkippedListed = 10
+
+// attachmentBudget returns the tokens attachments may use, half of the
+// model's input tokens to leave room for the prompt, context and
+// response, or 0 when the model is unknown
+func (app *Application) attachmentBudget() int {
+	modelInfo, err := app.assistant.ModelInfo()
+	if err != nil {
+		return 0
+	}
+	return modelInfo.InputTokenLimit / 2
+}
+
+// expandAttachments replaces directories and glob patterns among the
+// attached files with their text files packed into one attachment each,
+// sharing the attachment budget
+func (app *Application) expandAttachments(ctx context.Context, args []string, quiet bool) ([]string, []*types.FileContent, error) {
+	var files []string
+	var contents []*types.FileContent
+
+	budget := app.attachmentBudget()
+	limited := budget > 0
+
+	for _, arg := range args {
+		if !attach.IsPattern(arg) {
+			files = append(files, arg)
+			continue
+		}
+		if limited && budget <= 0 {
+			return nil, nil, fmt.Errorf("no token budget left for %s", arg)
+		}
+
+		bundle, err := attach.Expand(ctx, arg, attach.Options{
+			Reader: app.assistant.FileReader(),
+			Budget: budget,
+			Count:  app.assistant.CountTokens,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if !quiet {
+			reportSkipped(bundle)
+		}
+		if bundle.Content == nil {
+			return nil, nil, fmt.Errorf("no text files to send from %s", arg)
+		}
+
+		budget -= bundle.Tokens
+		contents = append(contents, bundle.Content)
+	}
+	return files, contents, nil
+}
+
+// summarizeOversized replaces text files and content too large for the
+// attachment budget with summaries of their parts, made in a chat of
+// their own so the parts get none of the request context. The spinner
+// shows which part is being summarized.
+func (app *Application) summarizeOversized(ctx context.Context, request string, files []string, contents []*types.FileContent) ([]string, []*types.FileContent, error) {
+	budget := app.attachmentBudget()
+	if budget == 0 {
+		return files, contents, nil
+	}
+
+	opts := chunk.Options{
+		Budget: budget,
+		Count:  app.assistant.CountTokens,
+		Progress: func(name string, part, parts int) {
+			app.spinner.Lock()
+			app.spinner.Prefix = fmt.Sprintf("Summarizing %s, part %d of %d ", name, part, parts)
+			app.spinner.Unlock()
+		},
+	}
+
+	// The chat is only created once something needs summarizing
+	var chat types.Chat
+	defer func() {
+		if chat != nil {
+			chat.Close()
+		}
+	}()
+	summarize := func(content *types.FileContent) (*types.FileContent, error) {
+		if chat == nil {
+			var err error
+			if chat, err = app.assistant.TaskChat(ctx, chunk.SystemPrompt); err != nil {
+				return nil, err
+			}
+		}
+		return chunk.Summarize(ctx, chat, request, content, opts)
+	}
+
+	var kept []string
+	var summarized []*types.FileContent
+	reader := app.assistant.FileReader()
+	for _, file := range files {
+		// A file smaller in bytes than the budget in tokens always fits
+		path, pages := types.SplitPageRange(file)
+		if stat, err := os.Stat(path); pages != "" || err != nil || stat.Size() <= int64(budget) {
+			kept = append(kept, file)
+			continue
+		}
+		if fileType, err := types.DetectFileTypeOf(path); err != nil || fileType != types.FileTypeText {
+			kept = append(kept, file)
+			continue
+		}
+
+		content, err := reader.ReadFile(ctx, file)
+		if err != nil || !chunk.Oversized(ctx, content, opts) {
+			kept = append(kept, file)
+			continue
+		}
+		summary, err := summarize(content)
+		if err != nil {
+			return nil, nil, err
+		}
+		summarized = append(summarized, summary)
+	}
+
+	for _, content := range contents {
+		if chunk.Oversized(ctx, content, opts) {
+			summary, err := summarize(content)
+			if err != nil {
+				return nil, nil, err
+			}
+			content = summary
+		}
+		summarized = append(summarized, content)
+	}
+	return kept, summarized, nil
+}
+
+// reportSkipped lists the files of a directory or pattern left out
+func reportSkipped(bundle *attach.Bundle) {
+	if len(bundle.Skipped) == 0 {
+		return
+	}
+
+	skipped := make([]string, 0, len(bundle.Skipped))
+	for file := range bundle.Skipped {
+		skipped = append(skipped, file)
+	}
+	sort.Strings(skipped)
+
+	warning.Fprintf(os.Stderr, "Skipped %d of the files in %s:\n", len(skipped), bundle.Pattern)
+	for i, file := range skipped {
+		if i == maxSkippedListed {
+			fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(skipped)-i)
+			break
+		}
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", file, bundle.Skipped[file])
+	}
+}
+
+// reportTrimmed notes what was left out of a request to fit the model's
+// input token limit
+func reportTrimmed(resp types.Response) {
+	if dropped, _ := resp.Metadata["history_dropped"].(int); dropped > 0 {
+		warning.Fprintf(os.Stderr, "Left out the oldest %d messages of the conversation to fit the model's input limit\n", dropped)
+	}
+	if reduced, _ := resp.Metadata["context_reduced"].(bool); reduced {
+		warning.Fprintln(os.Stderr, "Left out project and environment context to fit the model's input limit")
+	}
+}
+
+// confirmAttachments checks files against the file access policy, then
+// lists exactly what will be sent and asks before sending it. A denied
+// file is an error, so nothing is sent.
+func (app *Application) confirmAttachments(files []string, contents []*types.FileContent, quiet bool) (bool, error) {
+	// Piped input is typed by the user, only files need confirming
+	packed := 0
+	for _, content := range contents {
+		if _, ok := content.Metadata["files"]; ok {
+			packed++
+		}
+	}
+	if len(files) == 0 && packed == 0 {
+		return true, nil
+	}
+
+	reader := app.assistant.FileReader()
+	lines := make([]string, 0, len(files)+len(contents))
+	for _, path := range files {
+		file, pages := types.SplitPageRange(path)
+		resolved, err := reader.Policy.Check(file)
+		if err != nil {
+			return false, err
+		}
+		stat, err := os.Stat(resolved)
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+
+		line := file
+		if absolute, err := filepath.Abs(file); err == nil && absolute != resolved {
+			line += " -> " + resolved
+		}
+		if pages != "" {
+			line += ", pages " + pages
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s)", line, formatSize(stat.Size())))
+	}
+	for _, content := range contents {
+		packed, _ := content.Metadata["files"].([]string)
+		if len(packed) == 0 {
+			lines = append(lines, fmt.Sprintf("%s (%s)", content.Name, formatSize(content.Size)))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s, %d files (%s)", content.Name, len(packed), formatSize(content.Size)))
+		for _, file := range packed {
+			lines = append(lines, "  "+file)
+		}
+	}
+
+	if quiet || !app.config.ConfirmAttachments() {
+		return true, nil
+	}
+
+	info.Fprintf(os.Stderr, "Files to send to %s:\n", app.config.DefaultProviderName())
+	for _, line := range lines {
+		fmt.Fprintf(os.Stderr, "  %s\n", line)
+	}
+	return app.confirm("Send these files"), nil
+}
+
+// formatSize formats a byte count for people
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, suffix := float64(size)/unit, "KB"
+	for _, next := range []string{"MB", "GB"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/atotto/clipboard"
+
+	"craftcom/pkg/types"
+)
+
+// clipboardImageReader is a command printing the image on the clipboard,
+// with how to decode its output into image data
+type clipboardImageReader struct {
+	args   []string
+	decode func([]byte) ([]byte, error)
+}
+
+// clipboardImageReaders are tried in order for each platform; they fail
+// when there is no image on the clipboard
+var clipboardImageReaders = map[string][]clipboardImageReader{
+	"linux": {
+		{args: []string{"wl-paste", "--no-newline", "--type", "image/png"}},
+		{args: []string{"xclip", "-selection", "clipboard", "-target", "image/png", "-out"}},
+	},
+	"darwin": {
+		{args: []string{"pngpaste", "-"}},
+		{args: []string{"osascript", "-e", "the clipboard as «class PNGf»"}, decode: decodeAppleScriptData},
+	},
+	"windows": {
+		{args: []string{"powershell", "-NoProfile", "-STA", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms; $image = [Windows.Forms.Clipboard]::GetImage(); " +
+				"if ($image) { $stream = New-Object IO.MemoryStream; $image.Save($stream, [Drawing.Imaging.ImageFormat]::Png); " +
+				"[Convert]::ToBase64String($stream.ToArray()) } else { exit 1 }"},
+			decode: decodeBase64Output},
+	},
+}
+
+// readClipboard returns the clipboard as an attachment: an image when
+// there is one, its text otherwise
+func readClipboard(ctx context.Context) (*types.FileContent, error) {
+	if data, ok := readClipboardImage(ctx); ok {
+		fileType, mimeType, err := types.DetectFileType(data)
+		if err == nil && fileType == types.FileTypeImage {
+			if len(data) > maxPipedInput {
+				return nil, fmt.Errorf("clipboard image too large (max %d bytes)", maxPipedInput)
+			}
+			return clipboardContent(fileType, mimeType, "clipboard."+strings.TrimPrefix(mimeType, "image/"), data), nil
+		}
+	}
+
+	text, err := clipboard.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the clipboard: %v", err)
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("the clipboard is empty")
+	}
+	if len(text) > maxPipedInput {
+		return nil, fmt.Errorf("clipboard text too large (max %d bytes)", maxPipedInput)
+	}
+	return clipboardContent(types.FileTypeText, "text/plain", "clipboard", []byte(text)), nil
+}
+
+// readClipboardImage runs the image readers of the platform until one
+// prints an image
+func readClipboardImage(ctx context.Context) ([]byte, bool) {
+	for _, reader := range clipboardImageReaders[runtime.GOOS] {
+		if _, err := exec.LookPath(reader.args[0]); err != nil {
+			continue
+		}
+		output, err := exec.CommandContext(ctx, reader.args[0], reader.args[1:]...).Output()
+		if err != nil || len(output) == 0 {
+			continue
+		}
+		if reader.decode != nil {
+			if output, err = reader.decode(output); err != nil {
+				continue
+			}
+		}
+		return output, true
+	}
+	return nil, false
+}
+
+// clipboardContent wraps clipboard data as an attachment
+func clipboardContent(fileType types.FileType, mimeType, name string, data []byte) *types.FileContent {
+	return &types.FileContent{
+		Type:     fileType,
+		Data:     data,
+		MimeType: mimeType,
+		Name:     name,
+		Size:     int64(len(data)),
+		Metadata: map[string]interface{}{
+			"source": "clipboard",
+		},
+	}
+}
+
+// decodeAppleScriptData decodes data printed by AppleScript as
+// «data PNGf89504E47...»
+func decodeAppleScriptData(output []byte) ([]byte, error) {
+	output = bytes.TrimSpace(output)
+	start := bytes.Index(output, []byte("«data "))
+	end := bytes.LastIndex(output, []byte("»"))
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("unexpected output")
+	}
+	// Skip the four letter type after "«data "
+	encoded := output[start+len("«data ") : end]
+	if len(encoded) < 4 {
+		return nil, fmt.Errorf("unexpected output")
+	}
+	return hex.DecodeString(string(encoded[4:]))
+}
+
+// decodeBase64Output decodes base64 printed by a command
+func decodeBase64Output(output []byte) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(string(bytes.TrimSpace(output)))
+}
+
+// describeAttachment names an attachment and its size for people
+func describeAttachment(content *types.FileContent) string {
+	kind := "text"
+	if content.Type != types.FileTypeText {
+		kind = string(content.Type)
+	}
+	return fmt.Sprintf("%s %s (%s)", content.Name, kind, formatSize(content.Size))
+}
@@ -0,0 +1,406 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kong"
+
+	"craftcom/pkg/craftcom"
+)
+
+// completeFiles tells the completion script to fall back to path completion
+const completeFiles = ":files"
+
+// completionScripts hold the completion functions printed by
+// `craftcom completion`. All of them delegate to the hidden `__complete`
+// command, which walks the kong model and prints one candidate per line,
+// optionally followed by a tab and a description.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for craftcom
+# Add to ~/.bashrc:  eval "$(craftcom completion bash)"
+
+_craftcom_completion() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local IFS=$'\n'
+    local -a candidates
+    candidates=($({{quote .Binary}} __complete -- "${COMP_WORDS[@]:1:COMP_CWORD-1}" "$cur" 2>/dev/null))
+    if [[ "${candidates[0]}" == "` + completeFiles + `" ]]; then
+        compopt -o filenames 2>/dev/null
+        COMPREPLY=($(compgen -f -- "$cur"))
+        return
+    fi
+    COMPREPLY=("${candidates[@]%%$'\t'*}")
+}
+complete -F _craftcom_completion craftcom
+`,
+
+	"zsh": `#compdef craftcom
+# zsh completion for craftcom
+# Add to ~/.zshrc:  eval "$(craftcom completion zsh)"
+
+_craftcom() {
+    local output line
+    local -a values descriptions
+    output="$({{quote .Binary}} __complete -- "${(@)words[2,CURRENT-1]}" "${words[CURRENT]}" 2>/dev/null)"
+    [[ -z "$output" ]] && return 1
+    if [[ "$output" == "` + completeFiles + `" ]]; then
+        _files
+        return
+    fi
+    for line in "${(@f)output}"; do
+        values+=("${line%%$'\t'*}")
+        descriptions+=("${line/$'\t'/  -- }")
+    done
+    compadd -l -d descriptions -a values
+}
+
+(( $+functions[compdef] )) || { autoload -Uz compinit && compinit }
+compdef _craftcom craftcom
+`,
+
+	"fish": `# fish completion for craftcom
+# Add to ~/.config/fish/config.fish:  craftcom completion fish | source
+
+function __craftcom_complete
+    set -l tokens (commandline -opc)
+    set -l current (commandline -ct)
+    set -l candidates ({{quote .Binary}} __complete -- $tokens[2..-1] "$current" 2>/dev/null)
+    if test "$candidates[1]" = "` + completeFiles + `"
+        __fish_complete_path "$current"
+        return
+    end
+    printf '%s\n' $candidates
+end
+complete -c craftcom -f -a '(__craftcom_complete)'
+`,
+}
+
+// handleCompletion prints the completion script for the requested shell
+func handleCompletion(shell string) error {
+	return printShellScript(completionScripts, shell)
+}
+
+// handleComplete prints completion candidates for a partial command line.
+// The last word is the one being completed and may be empty.
+func handleComplete(kongCtx *kong.Context, words []string) error {
+	current := ""
+	if len(words) > 0 {
+		current = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	c := &completer{}
+	for _, candidate := range c.complete(kongCtx.Model.Node, words, current) {
+		fmt.Println(candidate)
+	}
+	return nil
+}
+
+// completer resolves candidates against the kong model
+type completer struct {
+	configPath string
+}
+
+// complete walks the already typed words to find what the current word is
+func (c *completer) complete(root *kong.Node, words []string, current string) []string {
+	node := root
+	position := 0
+	var pending *kong.Flag
+
+	for _, word := range words {
+		switch {
+		case pending != nil:
+			// bash splits "--flag=value" at the equals sign
+			if word == "=" {
+				continue
+			}
+			c.observe(pending, word)
+			pending = nil
+		case word == "--":
+			continue
+		case strings.HasPrefix(word, "-") && word != "-":
+			flag, value, hasValue := findFlag(node, word)
+			if flag == nil {
+				continue
+			}
+			if hasValue {
+				c.observe(flag, value)
+			} else if !flag.IsBool() && !flag.IsCounter() {
+				pending = flag
+			}
+		default:
+			if child := findCommand(node, word); child != nil {
+				node = child
+				position = 0
+				continue
+			}
+			position++
+		}
+	}
+
+	if pending != nil {
+		return c.values(pending.Value, current, "")
+	}
+
+	if strings.HasPrefix(current, "--") && strings.Contains(current, "=") {
+		flag, value, _ := findFlag(node, current)
+		if flag == nil {
+			return nil
+		}
+		return c.values(flag.Value, value, current[:len(current)-len(value)])
+	}
+
+	if strings.HasPrefix(current, "-") {
+		return filterCandidates(flagCandidates(node), current)
+	}
+
+	if position == 0 && node == root && strings.HasPrefix(current, "@") {
+		return filterCandidates(c.templates(), current)
+	}
+
+	if position == 0 && len(node.Children) > 0 {
+		return filterCandidates(commandCandidates(node), current)
+	}
+
+	if position < len(node.Positional) {
+		return c.values(node.Positional[position], current, "")
+	}
+	if n := len(node.Positional); n > 0 && node.Positional[n-1].IsSlice() {
+		return c.values(node.Positional[n-1], current, "")
+	}
+
+	return nil
+}
+
+// observe remembers flag values that influence later completions
+func (c *completer) observe(flag *kong.Flag, value string) {
+	if flag.Name == "config" {
+		c.configPath = value
+	}
+}
+
+// values returns candidates for a flag or positional argument value
+func (c *completer) values(value *kong.Value, current, prefix string) []string {
+	kind := value.Tag.Get("completion")
+	if kind == "" && value.Tag.Type == "path" {
+		kind = "files"
+	}
+
+	var candidates []string
+	switch kind {
+	case "files":
+		return []string{completeFiles}
+	case "models":
+		candidates = c.models()
+	case "history":
+		candidates = c.history()
+	case "providers":
+		candidates = c.providers()
+	case "profiles":
+		candidates = c.profiles()
+	case "shells":
+		candidates = sortedKeys(shellScripts)
+	default:
+		for _, option := range value.EnumSlice() {
+			if option != "" {
+				candidates = append(candidates, option)
+			}
+		}
+	}
+
+	candidates = filterCandidates(candidates, current)
+	for i, candidate := range candidates {
+		candidates[i] = prefix + candidate
+	}
+	return candidates
+}
+
+// loadConfig loads the configuration selected on the command line
+func (c *completer) loadConfig() (*libterma.Config, error) {
+	return loadLibConfig(c.configPath)
+}
+
+// models lists the models of the configured providers and those declared
+// in the config. Providers are not created, which could run API key
+// commands or ask for a passphrase.
+func (c *completer) models() []string {
+	config, err := c.loadConfig()
+	if err != nil {
+		return nil
+	}
+
+	var models []string
+	seen := make(map[string]bool)
+	for _, provider := range sortedKeys(config.Providers) {
+		for _, model := range append(libterma.KnownModels(provider), config.Providers[provider].Models...) {
+			if !seen[model] {
+				seen[model] = true
+				models = append(models, model+"\t"+provider)
+			}
+		}
+	}
+	return models
+}
+
+// history lists history entry numbers with the command as description
+func (c *completer) history() []string {
+	config, err := c.loadConfig()
+	if err != nil {
+		return nil
+	}
+
+	entries, err := libterma.NewHistoryStore(config.HistoryFile, config.HistorySize).Load()
+	if err != nil {
+		return nil
+	}
+
+	candidates := make([]string, 0, len(entries))
+	for i, entry := range entries {
+		command := strings.Join(strings.Fields(entry.Command), " ")
+		if len(command) > 60 {
+			command = command[:57] + "..."
+		}
+		candidates = append(candidates, strconv.Itoa(i+1)+"\t"+command)
+	}
+	return candidates
+}
+
+// providers lists the configured provider names
+func (c *completer) providers() []string {
+	config, err := c.loadConfig()
+	if err != nil {
+		return nil
+	}
+	return sortedKeys(config.Providers)
+}
+
+// profiles lists the defined profile names
+func (c *completer) profiles() []string {
+	config, err := c.loadConfig()
+	if err != nil {
+		return nil
+	}
+	return config.ProfileNames()
+}
+
+// templates lists "@name" for each template with its description
+func (c *completer) templates() []string {
+	config, err := c.loadConfig()
+	if err != nil {
+		return nil
+	}
+	templates, err := config.Templates()
+	if err != nil {
+		return nil
+	}
+
+	candidates := make([]string, 0, len(templates))
+	for _, t := range templates {
+		candidate := "@" + t.Name
+		if t.Description != "" {
+			candidate += "\t" + t.Description
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// findFlag looks up a flag by its long or short form in the node and its
+// ancestors, splitting off an inline value
+func findFlag(node *kong.Node, word string) (*kong.Flag, string, bool) {
+	name, value, hasValue := strings.Cut(word, "=")
+	for _, group := range node.AllFlags(false) {
+		for _, flag := range group {
+			if strings.HasPrefix(name, "--") && strings.TrimPrefix(name, "--") == flag.Name {
+				return flag, value, hasValue
+			}
+			if flag.Short != 0 && name == "-"+string(flag.Short) {
+				return flag, value, hasValue
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// findCommand returns the child command with the given name or alias
+func findCommand(node *kong.Node, name string) *kong.Node {
+	for _, child := range node.Children {
+		if child.Name == name {
+			return child
+		}
+		for _, alias := range child.Aliases {
+			if alias == name {
+				return child
+			}
+		}
+	}
+	return nil
+}
+
+// commandCandidates lists visible subcommands with their help text
+func commandCandidates(node *kong.Node) []string {
+	var candidates []string
+	for _, child := range node.Children {
+		if child.Hidden {
+			continue
+		}
+		candidates = append(candidates, child.Name+"\t"+child.Help)
+	}
+	return candidates
+}
+
+// flagCandidates lists visible flags of the node and its ancestors
+func flagCandidates(node *kong.Node) []string {
+	var candidates []string
+	for _, group := range node.AllFlags(true) {
+		for _, flag := range group {
+			candidates = append(candidates, "--"+flag.Name+"\t"+flag.Help)
+		}
+	}
+	return candidates
+}
+
+// filterCandidates keeps candidates whose value starts with prefix
+func filterCandidates(candidates []string, prefix string) []string {
+	filtered := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		value, _, _ := strings.Cut(candidate, "\t")
+		if strings.HasPrefix(value, prefix) {
+			filtered = append(filtered, candidate)
+		}
+	}
+	return filtered
+}
+
+// sortedKeys returns the keys of a string-keyed map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
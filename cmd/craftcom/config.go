@@ -0,0 +1,162 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/fatih/color"
+
+	"craftcom/pkg/craftcom"
+)
+
+// maskedSecret replaces secret values in config output
+const maskedSecret = "********"
+
+// handleConfig runs the config and templates commands, which work without
+// a provider
+func handleConfig(kongCtx *kong.Context, cli *CLI) error {
+	switch kongCtx.Command() {
+	case "configure":
+		return handleConfigure(cli)
+	case "config show":
+		return handleConfigShow(cli)
+	case "config schema":
+		_, err := os.Stdout.Write(libterma.ConfigSchema())
+		return err
+	case "templates":
+		return handleTemplates(cli)
+	default:
+		return fmt.Errorf("unknown config command: %s", kongCtx.Command())
+	}
+}
+
+// handleConfigShow prints the effective configuration, optionally with the
+// layer each value comes from
+func handleConfigShow(cli *CLI) error {
+	config, err := loadConfigWithFlags(cli)
+	if err != nil {
+		return err
+	}
+
+	format, err := resolveOutputFormat(cli.OutputFormat, config.OutputFormat)
+	if err != nil {
+		return err
+	}
+
+	values, err := config.Values()
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %v", err)
+	}
+	for i := range values {
+		values[i].Value = maskSecret(values[i].Key, values[i].Value)
+	}
+
+	if isStructuredFormat(format) {
+		if !cli.Settings.Show.Origin {
+			settings := make(map[string]interface{}, len(values))
+			for _, value := range values {
+				settings[value.Key] = value.Value
+			}
+			return printStructured(format, settings)
+		}
+		return printStructured(format, values)
+	}
+
+	if format == formatPlain {
+		color.NoColor = true
+	}
+
+	for _, value := range values {
+		bold.Print(value.Key)
+		fmt.Printf(" = %s", formatConfigValue(value.Value))
+		if cli.Settings.Show.Origin {
+			info.Printf("  (%s)", value.Origin)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+// formatConfigValue formats a setting as it would appear in a JSON file
+func formatConfigValue(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// printConfigDiff prints the settings that differ between two snapshots
+// and reports whether there were any
+func printConfigDiff(before, after []libterma.ConfigValue) bool {
+	// Compare the real values so a replaced API key still shows up
+	old := make(map[string]interface{}, len(before))
+	for _, value := range before {
+		old[value.Key] = value.Value
+	}
+	current := make(map[string]interface{}, len(after))
+	for _, value := range after {
+		current[value.Key] = value.Value
+	}
+
+	keys := make(map[string]bool, len(old)+len(current))
+	for key := range old {
+		keys[key] = true
+	}
+	for key := range current {
+		keys[key] = true
+	}
+
+	changed := false
+	for _, key := range sortedKeys(keys) {
+		previous, hadPrevious := old[key]
+		value, hasValue := current[key]
+		if hadPrevious && hasValue && formatConfigValue(previous) == formatConfigValue(value) {
+			continue
+		}
+
+		changed = true
+		if hadPrevious {
+			errLog.Printf("- %s = %s\n", key, formatConfigValue(maskSecret(key, previous)))
+		}
+		if hasValue {
+			success.Printf("+ %s = %s\n", key, formatConfigValue(maskSecret(key, value)))
+		}
+	}
+	return changed
+}
+
+// maskSecret hides API keys so the output can be shared
+func maskSecret(key string, value interface{}) interface{} {
+	if !strings.HasSuffix(key, "api_key") {
+		return value
+	}
+	if s, ok := value.(string); ok && s != "" {
+		return maskedSecret
+	}
+	return value
+}
@@ -22,15 +22,14 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/atotto/clipboard"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -39,8 +38,9 @@ import (
 	"github.com/fatih/color"
 	"github.com/manifoldco/promptui"
 
+	"craftcom/pkg/attach"
 	"craftcom/pkg/craftcom"
-	"craftcom/pkg/gemini"
+	"craftcom/pkg/secrets"
 	"craftcom/pkg/types"
 )
 
@@ -53,48 +53,45 @@ var (
 	errLog  = color.New(color.FgRed)
 )
 
-// Config Configuration structure
-type Config struct {
-	APIKey          string            `json:"api_key"`
-	DefaultModel    string            `json:"default_model"`
-	DefaultProvider string            `json:"default_provider"`
-	HistorySize     int               `json:"history_size"`
-	MaxTokens       int               `json:"max_tokens"`
-	Temperature     float32           `json:"temperature"`
-	SafetyLevel     string            `json:"safety_level"`
-	Aliases         map[string]string `json:"aliases"`
-	OutputFormat    string            `json:"output_format"`
-	Debug           bool              `json:"debug"` // Add this field
-}
-
-// CLI flags and commands
 // CLI flags and commands
 type CLI struct {
-	Config     string `help:"Configure file path" type:"path" short:"c"`
-	Provider   string `help:"AI provider to use (default: gemini)" default:"gemini" short:"p"`
-	Model      string `help:"Model to use" short:"m"`
-	OutputFile string `help:"Output file for commands" type:"path" short:"o"`
-	ReadmeFile string `help:"File for full markdown output" type:"path" short:"w"`
-	Quiet      bool   `help:"Non-interactive mode" default:"false" short:"q"`
-	Debug      bool   `help:"Enable debug mode" default:"false" short:"d"`
-	Version    bool   `help:"Show version information" short:"v"`
+	Config        string `help:"Configure file path" type:"path" short:"c"`
+	Provider      string `help:"AI provider to use (default from config)" short:"p" completion:"providers"`
+	Model         string `help:"Model to use" short:"m" completion:"models"`
+	Profile       string `help:"Configuration profile to use" short:"P" completion:"profiles"`
+	OutputFile    string `help:"Shell script file for generated commands" type:"path" short:"o"`
+	ReadmeFile    string `help:"File for a markdown runbook of the session" type:"path" short:"w"`
+	NotebookFile  string `help:"File for a Jupyter notebook of the session" type:"path"`
+	OutputFormat  string `help:"Output format (markdown, plain, json, yaml)" enum:",markdown,plain,json,yaml" default:""`
+	Quiet         bool   `help:"Non-interactive mode" default:"false" short:"q"`
+	FromClipboard bool   `help:"Attach the clipboard, an image or text, to the request"`
+	Debug         bool   `help:"Enable debug mode" default:"false" short:"d"`
+	Version       bool   `help:"Show version information" short:"v"`
 
 	// Commands
-	Execute   ExecuteCmd   `cmd:"" help:"Execute a specific natural language command" hidden:""`
-	List      ListCmd      `cmd:"" help:"List available models"`
-	History   HistoryCmd   `cmd:"" help:"Show command history"`
-	Clear     ClearCmd     `cmd:"" help:"Clear history"`
-	Configure ConfigureCmd `cmd:"" help:"Configure settings"`
+	Execute    ExecuteCmd    `cmd:"" help:"Execute a specific natural language command" default:"withargs" hidden:""`
+	List       ListCmd       `cmd:"" help:"List available models"`
+	History    HistoryCmd    `cmd:"" help:"Show command history"`
+	Clear      ClearCmd      `cmd:"" help:"Clear history"`
+	Configure  ConfigureCmd  `cmd:"" help:"Configure settings"`
+	Settings   ConfigCmd     `cmd:"" name:"config" help:"Inspect the effective configuration"`
+	Templates  TemplatesCmd  `cmd:"" help:"List prompt and command templates, run as @name"`
+	ShellInit  ShellInitCmd  `cmd:"" name:"shell-init" help:"Print shell integration code (bash, zsh, fish)"`
+	Completion CompletionCmd `cmd:"" help:"Print shell completion script (bash, zsh, fish)"`
+	Complete   CompleteCmd   `cmd:"" name:"__complete" help:"Print completion candidates" hidden:""`
+	Suggest    SuggestCmd    `cmd:"" help:"Print only the generated command" hidden:""`
+	Record     RecordCmd     `cmd:"" help:"Record a command run outside CraftCom" hidden:""`
 }
 
 type ExecuteCmd struct {
 	Command string   `arg:"" optional:"" help:"Natural language command to execute"`
-	Files   []string `arg:"" optional:"" help:"Files to process (images, PDFs, etc.)"`
+	Files   []string `arg:"" optional:"" help:"Files, directories or quoted glob patterns such as 'src/**/*.go' to attach" completion:"files"`
 }
 
 type ListCmd struct{}
 
 type HistoryCmd struct {
+	ID    int  `arg:"" optional:"" help:"Show a single history entry by number" completion:"history"`
 	Limit int  `help:"Number of entries to show" default:"10"`
 	Full  bool `help:"Show full command details" default:"false" short:"l"`
 }
@@ -104,16 +101,55 @@ type ClearCmd struct {
 }
 
 type ConfigureCmd struct {
-	Reset bool `help:"Reset configuration to defaults" short:"x"`
+	Reset bool `help:"Reset configuration to defaults, keeping API keys" short:"x"`
+}
+
+type ConfigCmd struct {
+	Show   ConfigShowCmd   `cmd:"" help:"Show the effective configuration"`
+	Schema ConfigSchemaCmd `cmd:"" help:"Print the JSON Schema for config files"`
+}
+
+type ConfigShowCmd struct {
+	Origin bool `help:"Show which file, variable or flag each value comes from"`
+}
+
+type ConfigSchemaCmd struct{}
+
+type TemplatesCmd struct{}
+
+type ShellInitCmd struct {
+	Shell string `arg:"" optional:"" help:"Shell to generate integration for (default: current shell)" completion:"shells"`
+}
+
+type CompletionCmd struct {
+	Shell string `arg:"" optional:"" help:"Shell to generate completion for (default: current shell)" completion:"shells"`
+}
+
+type CompleteCmd struct {
+	Words []string `arg:"" optional:"" help:"Command line words, the last one being completed"`
+}
+
+type SuggestCmd struct {
+	Prompt string `arg:"" help:"Natural language description of the command"`
+}
+
+type RecordCmd struct {
+	Command  string        `arg:"" help:"Command line that was run"`
+	ExitCode int           `help:"Exit code of the command" default:"0"`
+	Duration time.Duration `help:"How long the command ran"`
 }
 
 // Application represents the main application state
 type Application struct {
-	config    Config
-	assistant *libterma.Terma
-	provider  types.Provider
-	spinner   *spinner.Spinner
-	kongCtx   *kong.Context // Add this field
+	config     *libterma.Config
+	assistant  *libterma.Terma
+	provider   types.Provider
+	spinner    *spinner.Spinner
+	kongCtx    *kong.Context        // Add this field
+	pipedInput []byte               // Data piped on stdin, sent as context
+	pasted     []*types.FileContent // Clipboard pasted for the next interactive request
+	format     string               // Resolved output format
+	session    *session             // Requests made during this run, for export
 }
 
 func main() {
@@ -144,6 +180,46 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Shell integration commands must stay fast and work without a provider
+	switch commandName(kongCtx) {
+	case "shell-init", "record", "completion", "__complete":
+		if err := runShellCommand(kongCtx, &cli); err != nil {
+			errLog.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Configuration commands must work before a provider is set up
+	switch commandName(kongCtx) {
+	case "config", "configure", "templates":
+		if err := handleConfig(kongCtx, &cli); err != nil {
+			errLog.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Piped stdin is either the prompt itself or context for the prompt
+	isExecute := commandName(kongCtx) == "" || commandName(kongCtx) == "execute"
+	var piped []byte
+	if isExecute && stdinIsPipe() {
+		data, err := readPipedInput()
+		if err != nil {
+			errLog.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if cli.Execute.Command == "" {
+			cli.Execute.Command = strings.TrimSpace(string(data))
+		} else {
+			piped = data
+		}
+		if cli.Execute.Command == "" {
+			errLog.Fprintln(os.Stderr, "Error: no prompt given on stdin or command line")
+			os.Exit(1)
+		}
+	}
+
 	// Initialize application
 	app, err := initializeApplication(&cli)
 	if err != nil {
@@ -151,11 +227,11 @@ func main() {
 		os.Exit(1)
 	}
 	defer app.cleanup()
+	app.pipedInput = piped
 
 	// If no command is specified, or if it's an execute command with no arguments,
 	// start interactive mode
-	if kongCtx.Command() == "" ||
-		(kongCtx.Command() == "execute" && cli.Execute.Command == "") {
+	if isExecute && cli.Execute.Command == "" {
 		if err := app.runInteractiveMode(ctx, &cli); err != nil {
 			errLog.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
@@ -170,65 +246,62 @@ func main() {
 	}
 }
 
-func loadConfig(configPath string) (Config, error) {
-	var config Config
-
-	// Set defaults
-	config = Config{
-		DefaultProvider: "gemini",
-		DefaultModel:    "gemini-1.5-pro",
-		HistorySize:     1000,
-		MaxTokens:       2048,
-		Temperature:     0.7,
-		SafetyLevel:     "medium",
-		OutputFormat:    "markdown",
-		Aliases:         make(map[string]string),
-	}
-
-	// If no config path specified, use default location
+// loadConfigWithFlags loads the configuration and applies the command line
+// flags as overrides for this run
+func loadConfigWithFlags(cli *CLI) (*libterma.Config, error) {
+	// Determine config path
+	configPath := cli.Config
 	if configPath == "" {
-		home, err := os.UserHomeDir()
-
+		path, err := libterma.DefaultConfigPath()
 		if err != nil {
-			return Config{}, fmt.Errorf("failed to get home directory: %v", err)
+			return nil, fmt.Errorf("failed to get config path: %v", err)
 		}
-		configPath = filepath.Join(home, ".craftcom.json")
-	}
-
-	// Create config directory if it doesn't exist
-	configDir := filepath.Dir(configPath)
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return Config{}, fmt.Errorf("failed to create config directory: %v", err)
+		configPath = path
 	}
+	_, statErr := os.Stat(configPath)
 
-	// Try to read existing config
-	data, err := os.ReadFile(configPath)
+	// Load configuration, migrating files written by older versions
+	config, err := libterma.LoadConfigFromPath(configPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Create default config file
-			data, err := json.MarshalIndent(config, "", "  ")
-			if err != nil {
-				return Config{}, fmt.Errorf("failed to marshal default config: %v", err)
-			}
-
-			if err := os.WriteFile(configPath, data, 0644); err != nil {
-				return Config{}, fmt.Errorf("failed to write default config: %v", err)
-			}
-
-			info.Printf("Created default config at: %s\n", configPath)
-			return config, nil
-		}
-		return Config{}, fmt.Errorf("failed to read config: %v", err)
+		return nil, fmt.Errorf("failed to load configuration: %v", err)
+	}
+	if os.IsNotExist(statErr) {
+		info.Fprintf(os.Stderr, "Created default config at: %s\n", configPath)
 	}
 
-	// Parse existing config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return Config{}, fmt.Errorf("failed to parse config: %v", err)
+	overrides := []struct {
+		set   bool
+		key   string
+		value interface{}
+		flag  string
+	}{
+		{cli.Profile != "", "profile", cli.Profile, "--profile"},
+		{cli.Provider != "", "default_provider", cli.Provider, "--provider"},
+		{cli.Model != "", "default_model", cli.Model, "--model"},
+		{cli.OutputFormat != "", "output_format", cli.OutputFormat, "--output-format"},
+		{cli.Debug, "debug", true, "--debug"},
+	}
+	for _, override := range overrides {
+		if !override.set {
+			continue
+		}
+		if err := config.Override(override.key, override.value, "flag "+override.flag); err != nil {
+			return nil, fmt.Errorf("failed to apply %s: %v", override.flag, err)
+		}
 	}
 
 	return config, nil
 }
 
+// loadLibConfig loads the library configuration from the given path, or
+// from the default location when the path is empty
+func loadLibConfig(configPath string) (*libterma.Config, error) {
+	if configPath == "" {
+		return libterma.LoadConfig()
+	}
+	return libterma.LoadConfigFromPath(configPath)
+}
+
 func initializeApplication(cli *CLI) (*Application, error) {
 	// Create spinner
 	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
@@ -236,41 +309,43 @@ func initializeApplication(cli *CLI) (*Application, error) {
 	s.Start()
 	defer s.Stop()
 
-	// Determine config path
-	configPath := cli.Config
-	if configPath == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get home directory: %v", err)
-		}
-		configPath = filepath.Join(home, ".craftcom.json")
+	config, err := loadConfigWithFlags(cli)
+	if err != nil {
+		return nil, err
 	}
 
-	// Load configuration
-	config, err := loadConfig(configPath)
+	format, err := resolveOutputFormat(cli.OutputFormat, config.OutputFormat)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load configuration: %v", err)
+		return nil, err
 	}
 
-	// Check for API key in environment if not in config
-	if config.APIKey == "" {
-		config.APIKey = os.Getenv("GEMINI_API_KEY")
+	// Encrypted API key files ask for their passphrase
+	if !stdinIsPipe() {
+		secrets.PassphrasePrompt = func(path string) (string, error) {
+			s.Stop()
+			return promptPassphrase(path)
+		}
 	}
 
-	if config.APIKey == "" {
-		return nil, fmt.Errorf("API key not found in config or environment. Please set GEMINI_API_KEY or configure the API key")
+	// Initialize assistant
+	assistant, err := libterma.NewWithConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize assistant: %v", err)
 	}
 
-	// Initialize provider
-	provider, err := initializeProvider(config, cli)
+	provider, err := assistant.Provider(config.DefaultProvider)
 	if err != nil {
+		assistant.Close()
 		return nil, fmt.Errorf("failed to initialize provider: %v", err)
 	}
 
-	// Initialize assistant with the correct config path
-	assistant, err := libterma.New(configPath) // Pass the actual config path here
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize assistant: %v", err)
+	// Keep stdout clean for programs consuming the output
+	switch {
+	case format == formatPlain:
+		color.NoColor = true
+	case isStructuredFormat(format):
+		s.Writer = os.Stderr
+		s.WriterFile = os.Stderr
 	}
 
 	return &Application{
@@ -278,73 +353,62 @@ func initializeApplication(cli *CLI) (*Application, error) {
 		assistant: assistant,
 		provider:  provider,
 		spinner:   s,
+		format:    format,
+		session:   newSession(),
 	}, nil
 }
 
-func initializeProvider(config Config, cli *CLI) (types.Provider, error) {
-	// Get API key from config or environment
-	apiKey := config.APIKey
-	if apiKey == "" {
-		apiKey = os.Getenv("GEMINI_API_KEY")
-	}
-	if apiKey == "" {
-		return nil, fmt.Errorf("API key not found in config or environment")
-	}
-
-	// Create provider with system prompt
-	provider, err := gemini.NewProvider(
-		context.Background(),
-		apiKey,
-		createSystemPrompt(),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create provider: %v", err)
-	}
-
-	return provider, nil
-}
-
-func createSystemPrompt() string {
-	sysInfo, err := types.GetSystemInfo()
-	if err != nil {
-		return ""
-	}
-
-	return fmt.Sprintf(`You are a terminal command assistant for %s using %s shell.
-Your goal is to help users by converting their natural language requests into appropriate
-terminal commands. Always prioritize safety and provide clear explanations.`,
-		sysInfo.OS,
-		sysInfo.Shell,
-	)
-}
-
 func (app *Application) run(ctx context.Context, kongCtx *kong.Context, cli *CLI) error {
 	if cli.Debug {
-		info.Println("Debug mode enabled")
+		info.Fprintln(os.Stderr, "Debug mode enabled")
 	}
 
-	switch kongCtx.Command() {
+	switch commandName(kongCtx) {
 	case "list":
 		return app.handleList(ctx)
 	case "execute":
+		if strings.HasPrefix(cli.Execute.Command, "@") {
+			return app.handleTemplate(ctx, cli)
+		}
 		return app.handleExecute(ctx, cli)
+	case "suggest":
+		return app.handleSuggest(ctx, cli.Suggest.Prompt)
 	case "history":
-		return app.handleHistory(cli.History.Limit, cli.History.Full)
+		return app.handleHistory(cli.History.ID, cli.History.Limit, cli.History.Full)
 	case "clear":
 		return app.handleClear(cli.Clear.Force)
-	case "configure":
-		return app.handleConfigure(cli.Configure.Reset)
 	default:
 		return app.runInteractiveMode(ctx, cli)
 	}
 }
 
+// commandName returns the selected command without its argument placeholders
+func commandName(kongCtx *kong.Context) string {
+	fields := strings.Fields(kongCtx.Command())
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
 func (app *Application) handleList(ctx context.Context) error {
 	models, err := app.provider.ListModels(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to list models: %v", err)
 	}
 
+	if isStructuredFormat(app.format) {
+		infos := make([]types.ModelInfo, 0, len(models))
+		for _, model := range models {
+			modelInfo, err := app.provider.GetModelInfo(model)
+			if err != nil {
+				continue
+			}
+			infos = append(infos, modelInfo)
+		}
+		return printStructured(app.format, infos)
+	}
+
 	info.Println("Available models:")
 	for _, model := range models {
 		modelInfo, err := app.provider.GetModelInfo(model)
@@ -368,12 +432,54 @@ func (app *Application) handleExecute(ctx context.Context, cli *CLI) error {
 	}
 	defer chat.Close()
 
+	var contents []*types.FileContent
+	if len(app.pipedInput) > 0 {
+		contents = append(contents, pipedContent(app.pipedInput))
+	}
+
+	if cli.FromClipboard {
+		content, err := readClipboard(ctx)
+		if err != nil {
+			return err
+		}
+		if !cli.Quiet {
+			info.Fprintf(os.Stderr, "Attached %s\n", describeAttachment(content))
+		}
+		contents = append(contents, content)
+	}
+
+	files, packed, err := app.expandAttachments(ctx, cli.Execute.Files, cli.Quiet)
+	if err != nil {
+		return err
+	}
+	contents = append(contents, packed...)
+
+	ok, err := app.confirmAttachments(files, contents, cli.Quiet)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		warning.Println("Nothing was sent")
+		return nil
+	}
+
 	app.spinner.Prefix = "Processing "
 	app.spinner.Start()
 
+	files, contents, err = app.summarizeOversized(ctx, cli.Execute.Command, files, contents)
+	if err != nil {
+		app.spinner.Stop()
+		return fmt.Errorf("failed to process command: %v", err)
+	}
+	app.spinner.Lock()
+	app.spinner.Prefix = "Processing "
+	app.spinner.Unlock()
+
 	var resp types.Response
-	if len(cli.Execute.Files) > 0 {
-		resp, err = chat.SendWithFiles(ctx, cli.Execute.Command, cli.Execute.Files)
+	if len(contents) > 0 {
+		resp, err = chat.SendWithAttachments(ctx, cli.Execute.Command, files, contents)
+	} else if len(files) > 0 {
+		resp, err = chat.SendWithFiles(ctx, cli.Execute.Command, files)
 	} else {
 		resp, err = chat.Send(ctx, cli.Execute.Command)
 	}
@@ -382,6 +488,25 @@ func (app *Application) handleExecute(ctx context.Context, cli *CLI) error {
 	if err != nil {
 		return fmt.Errorf("failed to process command: %v", err)
 	}
+	reportTrimmed(resp)
+	app.session.add(cli.Execute.Command, resp)
+
+	if isStructuredFormat(app.format) {
+		result := newExecuteResult(cli.Execute.Command, resp)
+		var execErr error
+		if !cli.Quiet && resp.Code != "" && app.confirm("Execute this command") {
+			var history types.CommandHistory
+			history, execErr = app.runCommand(ctx, resp.Code)
+			result.setExecution(history)
+		}
+		if err := printStructured(app.format, result); err != nil {
+			return err
+		}
+		if execErr != nil {
+			return fmt.Errorf("failed to execute command: %v", execErr)
+		}
+		return app.saveOutput(cli)
+	}
 
 	// Display results
 	bold.Println("\nGenerated Command:")
@@ -396,43 +521,57 @@ func (app *Application) handleExecute(ctx context.Context, cli *CLI) error {
 		}
 	}
 
-	return nil
+	return app.saveOutput(cli)
 }
 
-func (app *Application) confirmAndExecute(ctx context.Context, command string) error {
-	confirm := promptui.Prompt{
-		Label:     "Execute this command",
-		IsConfirm: true,
+// confirm asks a yes/no question, reading the answer from the terminal
+// when stdin is a pipe or stdout carries structured output
+func (app *Application) confirm(label string) bool {
+	if stdinIsPipe() || isStructuredFormat(app.format) {
+		return confirmFromTerminal(label)
 	}
+	return confirmPrompt(label)
+}
 
-	result, err := confirm.Run()
-	if err != nil || strings.ToLower(result) != "y" {
+func (app *Application) confirmAndExecute(ctx context.Context, command string) error {
+	if !app.confirm("Execute this command") {
 		return nil
 	}
 
+	cmdResult, err := app.runCommand(ctx, command)
+	if err != nil {
+		return fmt.Errorf("failed to execute command: %v", err)
+	}
+
+	success.Println("\nOutput:")
+	fmt.Println(cmdResult.Output)
+
+	return nil
+}
+
+// runCommand executes a command and records it in history
+func (app *Application) runCommand(ctx context.Context, command string) (types.CommandHistory, error) {
 	app.spinner.Start()
 	defer app.spinner.Stop()
 
 	executor, err := types.NewCommandExecutor()
 	if err != nil {
-		return fmt.Errorf("failed to create command executor: %v", err)
+		return types.CommandHistory{}, fmt.Errorf("failed to create command executor: %v", err)
 	}
 
 	cmdResult, err := executor.Execute(ctx, command)
-	if err != nil {
-		return fmt.Errorf("failed to execute command: %v", err)
+	app.session.recordExecution(cmdResult)
+	if recordErr := app.assistant.RecordHistory(cmdResult); recordErr != nil {
+		warning.Fprintf(os.Stderr, "Failed to record history: %v\n", recordErr)
 	}
 
-	success.Println("\nOutput:")
-	fmt.Println(cmdResult.Output)
-
-	return nil
+	return cmdResult, err
 }
 
-func (app *Application) handleHistory(limit int, full bool) error {
+func (app *Application) handleHistory(id, limit int, full bool) error {
 	history := app.assistant.GetHistory()
 
-	if len(history) == 0 {
+	if len(history) == 0 && !isStructuredFormat(app.format) {
 		info.Println("No command history available")
 		return nil
 	}
@@ -441,14 +580,34 @@ func (app *Application) handleHistory(limit int, full bool) error {
 	if start < 0 {
 		start = 0
 	}
+	end := len(history)
+
+	// A specific entry is always shown in full
+	if id != 0 {
+		if id < 1 || id > len(history) {
+			return fmt.Errorf("history index out of range: %d", id)
+		}
+		start, end, full = id-1, id, true
+	}
+
+	if isStructuredFormat(app.format) {
+		entries := make([]historyEntry, 0, end-start)
+		for i, cmd := range history[start:end] {
+			entries = append(entries, historyEntry{ID: start + i + 1, CommandHistory: cmd})
+		}
+		return printStructured(app.format, entries)
+	}
 
 	info.Println("Command History:")
-	for i, cmd := range history[start:] {
-		bold.Printf("\n%d. Command:\n", i+1)
+	for i, cmd := range history[start:end] {
+		bold.Printf("\n%d. Command:\n", start+i+1)
 		fmt.Printf("$ %s\n", cmd.Command)
 		if full {
 			fmt.Printf("Time: %s\n", cmd.StartTime.Format(time.RFC3339))
 			fmt.Printf("Exit Code: %d\n", cmd.ExitCode)
+			if cmd.Source != "" {
+				fmt.Printf("Source: %s\n", cmd.Source)
+			}
 			if cmd.Error != "" {
 				fmt.Printf("Error: %s\n", cmd.Error)
 			}
@@ -461,65 +620,68 @@ func (app *Application) handleHistory(limit int, full bool) error {
 }
 
 func (app *Application) handleClear(force bool) error {
-	if !force {
-		confirm := promptui.Prompt{
-			Label:     "Clear command history",
-			IsConfirm: true,
-		}
-
-		result, err := confirm.Run()
-		if err != nil || strings.ToLower(result) != "y" {
-			return nil
-		}
-	}
-
-	app.assistant.ClearHistory()
-	success.Println("Command history cleared")
-	return nil
-}
-
-func (app *Application) handleConfigure(reset bool) error {
-	if reset {
-		// Reset configuration to defaults
-		config, err := loadConfig("")
-		if err != nil {
-			return fmt.Errorf("failed to load default configuration: %v", err)
-		}
-		app.config = config
-		success.Println("Configuration reset to defaults")
+	if !force && !app.confirm("Clear command history") {
 		return nil
 	}
 
-	// Interactive configuration
-	return app.runConfigurationWizard()
-}
-
-func (app *Application) runConfigurationWizard() error {
-	// Implementation of interactive configuration wizard
-	// This would allow users to set various configuration options
+	if err := app.assistant.ClearHistory(); err != nil {
+		return fmt.Errorf("failed to clear history: %v", err)
+	}
+	success.Println("Command history cleared")
 	return nil
 }
 
 func (app *Application) runInteractiveMode(ctx context.Context, cli *CLI) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	chat, err := app.assistant.Chat(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to create chat: %v", err)
 	}
-	defer chat.Close()
+	defer func() { chat.Close() }()
+
+	// Apply config file changes while the session runs; the chat is
+	// recreated before the next prompt when providers changed
+	var providersChanged atomic.Bool
+	if err := app.assistant.WatchConfig(ctx, func(reload libterma.ConfigReload) {
+		if reload.Err != nil {
+			warning.Fprintf(os.Stderr, "\nConfig change rejected, keeping the current settings:\n%v\n", reload.Err)
+			return
+		}
+		info.Fprintln(os.Stderr, "\nConfiguration reloaded")
+		if reload.ProvidersChanged {
+			providersChanged.Store(true)
+		}
+	}); err != nil {
+		app.debug("Not watching config files: %v", err)
+	}
+
+	// Export the session however the loop ends
+	defer func() {
+		if err := app.saveOutput(cli); err != nil {
+			errLog.Printf("Error: %v\n", err)
+		}
+	}()
 
 	app.displayWelcomeMessage()
 
 	for {
-		prompt := promptui.Prompt{
-			Label: ">",
-			Templates: &promptui.PromptTemplates{
-				Prompt:  "{{ . | cyan }}▶ ",
-				Valid:   "{{ . | green }}▶ ",
-				Invalid: "{{ . | red }}▶ ",
-			},
+		if providersChanged.Swap(false) {
+			newChat, err := app.assistant.Chat(ctx)
+			if err != nil {
+				errLog.Printf("Error: failed to apply new provider settings, keeping the current chat: %v\n", err)
+			} else {
+				chat.Close()
+				chat = newChat
+				if provider, err := app.assistant.Provider(app.config.DefaultProviderName()); err == nil {
+					app.provider = provider
+				}
+				info.Println("New provider settings apply from here on; earlier messages are not carried over")
+			}
 		}
 
-		input, err := prompt.Run()
+		input, err := readInteractive()
 		if err != nil {
 			return fmt.Errorf("prompt error: %v", err)
 		}
@@ -537,14 +699,59 @@ func (app *Application) runInteractiveMode(ctx context.Context, cli *CLI) error
 }
 
 func (app *Application) handleInteractiveCommand(ctx context.Context, chat types.Chat, input string) error {
-	// Check if the input mentions a file
-	fileRegex := regexp.MustCompile(`(?i)(analyze|read|describe|show|check|look at|view|process)\s+.*?(file|image|photo|picture|document|pdf)\s+([^\s]+)`)
-	if match := fileRegex.FindStringSubmatch(input); len(match) > 3 {
-		filePath := match[3]
-		// Clean up the path (remove quotes if present)
-		filePath = strings.Trim(filePath, `"'`)
+	// "/paste [request]" attaches the clipboard to the request, or to the
+	// next one
+	if input == "/paste" || strings.HasPrefix(input, "/paste ") {
+		content, err := readClipboard(ctx)
+		if err != nil {
+			return err
+		}
+		input = strings.TrimSpace(strings.TrimPrefix(input, "/paste"))
+		app.pasted = append(app.pasted, content)
+		if input == "" {
+			info.Printf("Attached %s to the next request\n", describeAttachment(content))
+			return nil
+		}
+	}
+
+	text, files := parseMentions(input)
 
-		return app.handleFileAnalysis(ctx, chat, filePath, input)
+	// "@name args" runs a template, unless there is none of that name and
+	// @name is a file
+	isTemplate := strings.HasPrefix(input, "@")
+	if isTemplate && len(files) > 0 && fileExists(files[0]) {
+		_, err := app.config.Template(strings.TrimPrefix(strings.Fields(input)[0], "@"))
+		isTemplate = err == nil
+	}
+	if isTemplate {
+		fields := strings.Fields(input)
+		t, expanded, err := app.expandTemplate(fields[0], fields[1:])
+		if err != nil {
+			return err
+		}
+		if t.IsCommand() {
+			if err := app.config.ValidateCommand(expanded); err != nil {
+				return err
+			}
+			app.session.add(input, types.Response{Code: expanded, FullOutput: t.Description})
+			fmt.Printf("$ %s\n", expanded)
+			return app.confirmAndExecute(ctx, expanded)
+		}
+		// @name named the template, files are mentioned in its prompt
+		input = expanded
+		text, files = parseMentions(input)
+	}
+
+	// Attach the files mentioned with @path, or named as in "analyze the
+	// file notes.txt"
+	pasted := app.pasted
+	app.pasted = nil
+	if len(files) > 0 || len(pasted) > 0 {
+		return app.handleFileAnalysis(ctx, chat, files, pasted, text)
+	}
+	if match := fileRequestPattern.FindStringSubmatch(input); match != nil {
+		filePath := strings.Trim(match[3], `"'`)
+		return app.handleFileAnalysis(ctx, chat, []string{types.ExpandHome(filePath)}, nil, input)
 	}
 
 	app.spinner.Prefix = "Thinking "
@@ -556,6 +763,8 @@ func (app *Application) handleInteractiveCommand(ctx context.Context, chat types
 	if err != nil {
 		return err
 	}
+	reportTrimmed(resp)
+	app.session.add(input, resp)
 
 	// Extract all possible commands from the response
 	commands := extractCommands(resp.FullOutput)
@@ -570,24 +779,60 @@ func (app *Application) handleInteractiveCommand(ctx context.Context, chat types
 	return app.handleCommandSuggestions(ctx, commands, resp)
 }
 
-func (app *Application) handleFileAnalysis(ctx context.Context, chat types.Chat, filePath string, originalInput string) error {
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		errLog.Printf("File not found: %s\n", filePath)
+func (app *Application) handleFileAnalysis(ctx context.Context, chat types.Chat, paths []string, pasted []*types.FileContent, originalInput string) error {
+	// Check the files exist
+	for _, filePath := range paths {
+		file, _ := types.SplitPageRange(filePath)
+		if _, err := os.Stat(file); os.IsNotExist(err) && !attach.IsPattern(filePath) {
+			errLog.Printf("File not found: %s\n", filePath)
+			return nil
+		}
+	}
+
+	files, contents, err := app.expandAttachments(ctx, paths, false)
+	if err != nil {
+		errLog.Printf("Cannot send files: %v\n", err)
 		return nil
 	}
+	contents = append(pasted, contents...)
+
+	ok, err := app.confirmAttachments(files, contents, false)
+	if err != nil {
+		errLog.Printf("Cannot send files: %v\n", err)
+		return nil
+	}
+	if !ok {
+		warning.Println("Nothing was sent")
+		return nil
+	}
+	// Confirming lists the files, pasted content is only named here
+	if len(paths) == 0 || !app.config.ConfirmAttachments() {
+		info.Printf("Attached: %s\n", strings.Join(attachmentNames(files, contents), ", "))
+	}
 
 	app.spinner.Prefix = "Analyzing file "
 	app.spinner.Start()
 
+	files, contents, err = app.summarizeOversized(ctx, originalInput, files, contents)
+	if err != nil {
+		app.spinner.Stop()
+		errLog.Printf("Error analyzing file: %v\n", err)
+		return nil
+	}
+	app.spinner.Lock()
+	app.spinner.Prefix = "Analyzing file "
+	app.spinner.Unlock()
+
 	// Send the request with the file
-	resp, err := chat.SendWithFiles(ctx, originalInput, []string{filePath})
+	resp, err := chat.SendWithAttachments(ctx, originalInput, files, contents)
 	app.spinner.Stop()
 
 	if err != nil {
 		errLog.Printf("Error analyzing file: %v\n", err)
 		return nil
 	}
+	reportTrimmed(resp)
+	app.session.add(originalInput, resp)
 
 	// Display the analysis results
 	info.Println("\nFile Analysis Results:")
@@ -742,7 +987,14 @@ func (app *Application) handleCommandExecution(ctx context.Context, command stri
 func (app *Application) displayCommandDetails(resp types.Response) {
 	info.Println("\nCommand Details:")
 	fmt.Printf("Model: %s\n", resp.Metadata["model"])
-	fmt.Printf("Tokens Used: %d\n", resp.Metadata["tokens_used"])
+	tokens := fmt.Sprintf("%d", resp.Metadata["tokens_used"])
+	if prompt, ok := resp.Metadata["prompt_tokens"]; ok {
+		tokens += fmt.Sprintf(" (%d prompt, %d response)", prompt, resp.Metadata["response_tokens"])
+	}
+	if estimated, _ := resp.Metadata["tokens_estimated"].(bool); estimated {
+		tokens += ", estimated"
+	}
+	fmt.Printf("Tokens Used: %s\n", tokens)
 	fmt.Printf("Command Count: %d\n", resp.Metadata["command_count"])
 	fmt.Printf("Error Count: %d\n", resp.Metadata["error_count"])
 	fmt.Printf("Session Length: %.2f minutes\n", resp.Metadata["session_length"])
@@ -769,30 +1021,46 @@ func (app *Application) displayWelcomeMessage() {
 
 func (app *Application) cleanup() {
 	if app.assistant != nil {
+		// Also closes the provider, which belongs to the assistant
 		app.assistant.Close()
 	}
-	if app.provider != nil {
-		app.provider.Close()
-	}
 }
 
-func (app *Application) saveOutput(resp types.Response, cli *CLI) error {
-	// Save command output if requested
+// saveOutput exports the session to the files requested with -o, -w and --notebook-file
+func (app *Application) saveOutput(cli *CLI) error {
+	if app.session.empty() {
+		return nil
+	}
+
+	// Save commands as an executable script if requested
 	if cli.OutputFile != "" {
-		if err := os.WriteFile(cli.OutputFile, []byte(resp.Code), 0644); err != nil {
+		if err := os.WriteFile(cli.OutputFile, []byte(app.session.script()), 0755); err != nil {
 			return fmt.Errorf("failed to save command output: %v", err)
 		}
-		success.Printf("Command saved to: %s\n", cli.OutputFile)
+		if err := os.Chmod(cli.OutputFile, 0755); err != nil {
+			return fmt.Errorf("failed to make script executable: %v", err)
+		}
+		success.Fprintf(os.Stderr, "Commands saved to: %s\n", cli.OutputFile)
 	}
 
-	// Save full markdown output if requested
+	// Save markdown runbook if requested
 	if cli.ReadmeFile != "" {
-		markdown := fmt.Sprintf("# Command Documentation\n\n## Command\n```bash\n%s\n```\n\n## Explanation\n%s\n",
-			resp.Code, resp.FullOutput)
-		if err := os.WriteFile(cli.ReadmeFile, []byte(markdown), 0644); err != nil {
+		if err := os.WriteFile(cli.ReadmeFile, []byte(app.session.markdown()), 0644); err != nil {
 			return fmt.Errorf("failed to save markdown output: %v", err)
 		}
-		success.Printf("Documentation saved to: %s\n", cli.ReadmeFile)
+		success.Fprintf(os.Stderr, "Runbook saved to: %s\n", cli.ReadmeFile)
+	}
+
+	// Save notebook if requested
+	if cli.NotebookFile != "" {
+		data, err := app.session.notebook()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(cli.NotebookFile, data, 0644); err != nil {
+			return fmt.Errorf("failed to save notebook: %v", err)
+		}
+		success.Fprintf(os.Stderr, "Notebook saved to: %s\n", cli.NotebookFile)
 	}
 
 	return nil
@@ -807,6 +1075,7 @@ Available Commands:
     clear             Clear command history
     list              List available models
     configure         Configure settings
+    templates         List templates
     help              Show this help message
     exit/quit         Exit the application
 
@@ -815,12 +1084,16 @@ Special Commands:
     !!                Re-run last command
     !$                Use last command's arguments
     !*                Use all arguments from last command
+    @<name> [args]    Run a template
+    @<path>           Attach a file, directory or pattern (Tab completes)
+    /paste [request]  Attach the clipboard, an image or text
 
 Options:
     -q, --quiet       Non-interactive mode
     -d, --debug       Enable debug mode
-    -o, --output      Save command to file
-    -r, --readme      Save full documentation to file
+    -o, --output-file Save commands as a shell script
+    -w, --readme-file Save session as a markdown runbook
+    --notebook-file   Save session as a Jupyter notebook
     `
 	fmt.Println(help)
 }
@@ -866,7 +1139,7 @@ func (app *Application) handleSpecialCommand(input string, history []types.Comma
 
 // Debug logging helper
 func (app *Application) debug(format string, args ...interface{}) {
-	if app.config.Debug {
-		info.Printf("[DEBUG] "+format+"\n", args...)
+	if app.config.DebugEnabled() {
+		info.Fprintf(os.Stderr, "[DEBUG] "+format+"\n", args...)
 	}
 }
@@ -0,0 +1,220 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
+
+	"craftcom/pkg/types"
+)
+
+// mentionPunctuation may follow a mention without being part of the path
+const mentionPunctuation = `.,;:!?)]}'"`
+
+// fileRequestPattern matches requests naming a file without @, as in
+// "analyze the file notes.txt" or "describe the image 'my photo.png'"
+var fileRequestPattern = regexp.MustCompile(`(?i)(analyze|read|describe|show|check|look at|view|process)\s+.*?(file|image|photo|picture|document|pdf)\s+("[^"]+"|'[^']+'|[^\s]+)`)
+
+// readInteractive reads a request at the interactive prompt, completing
+// the paths of @mentions with Tab
+func readInteractive() (string, error) {
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:       color.CyanString(">") + "▶ ",
+		AutoComplete: mentionCompleter{},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer rl.Close()
+
+	return rl.Readline()
+}
+
+// fileExists reports whether a file or directory exists
+func fileExists(path string) bool {
+	file, _ := types.SplitPageRange(path)
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// attachmentNames names the files and contents attached to a request
+func attachmentNames(files []string, contents []*types.FileContent) []string {
+	names := make([]string, 0, len(files)+len(contents))
+	names = append(names, files...)
+	for _, content := range contents {
+		if packed, ok := content.Metadata["files"].([]string); ok {
+			names = append(names, fmt.Sprintf("%s (%d files)", content.Name, len(packed)))
+			continue
+		}
+		names = append(names, content.Name)
+	}
+	return names
+}
+
+// parseMentions finds the files mentioned in interactive input as @path,
+// @"path with spaces" or @path\ with\ escapes. A mention starts a word, so
+// email addresses are left alone, and punctuation ending a sentence is not
+// part of the path unless a file has that name. It returns the input with
+// each mention replaced by its path, and the paths with ~ expanded.
+func parseMentions(input string) (string, []string) {
+	runes := []rune(input)
+	var text strings.Builder
+	var files []string
+
+	for i := 0; i < len(runes); {
+		if runes[i] != '@' || (i > 0 && !unicode.IsSpace(runes[i-1]) && !strings.ContainsRune(`([{"'`, runes[i-1])) {
+			text.WriteRune(runes[i])
+			i++
+			continue
+		}
+
+		path, next, quoted := readMention(runes, i+1)
+		if path == "" {
+			text.WriteRune(runes[i])
+			i++
+			continue
+		}
+
+		trailing := ""
+		if !quoted {
+			path, trailing = trimMention(path)
+		}
+		files = append(files, types.ExpandHome(path))
+		text.WriteString(path + trailing)
+		i = next
+	}
+	return text.String(), files
+}
+
+// readMention reads the path of a mention starting at runes[start],
+// returning it, the index after it and whether it was quoted
+func readMention(runes []rune, start int) (string, int, bool) {
+	if start >= len(runes) {
+		return "", start, false
+	}
+
+	if quote := runes[start]; quote == '"' || quote == '\'' {
+		for end := start + 1; end < len(runes); end++ {
+			if runes[end] == quote {
+				return string(runes[start+1 : end]), end + 1, true
+			}
+		}
+		// An unterminated quote is not a mention
+		return "", start, false
+	}
+
+	var path strings.Builder
+	i := start
+	for ; i < len(runes) && !unicode.IsSpace(runes[i]); i++ {
+		if runes[i] == '\\' && i+1 < len(runes) {
+			i++
+		}
+		path.WriteRune(runes[i])
+	}
+	return path.String(), i, false
+}
+
+// trimMention splits punctuation ending a sentence off an unquoted path,
+// unless a file has the full name
+func trimMention(path string) (string, string) {
+	trimmed := path
+	for trimmed != "" && strings.ContainsAny(trimmed[len(trimmed)-1:], mentionPunctuation) {
+		if _, err := os.Stat(types.ExpandHome(trimmed)); err == nil {
+			break
+		}
+		trimmed = trimmed[:len(trimmed)-1]
+	}
+	if trimmed == "" {
+		return path, ""
+	}
+	return trimmed, path[len(trimmed):]
+}
+
+// mentionCompleter completes the path of the @mention at the cursor when
+// Tab is pressed in the interactive prompt
+type mentionCompleter struct{}
+
+// Do returns the ways the mention before pos can continue and how many
+// characters of it they share
+func (mentionCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	start := pos
+	for start > 0 && (!unicode.IsSpace(line[start-1]) || (start > 1 && line[start-2] == '\\')) {
+		start--
+	}
+	word := string(line[start:pos])
+	if !strings.HasPrefix(word, "@") {
+		return nil, 0
+	}
+
+	quoted := strings.HasPrefix(word, `@"`)
+	typed := strings.TrimPrefix(strings.TrimPrefix(word, "@"), `"`)
+	prefix := typed
+	if !quoted {
+		prefix = strings.ReplaceAll(prefix, `\ `, " ")
+	}
+
+	dir, partial := filepath.Split(prefix)
+	entries, err := os.ReadDir(types.ExpandHome(filepathOrDot(dir)))
+	if err != nil {
+		return nil, 0
+	}
+
+	var candidates [][]rune
+	for _, entry := range entries {
+		name := entry.Name()
+		// Hidden files only when asked for
+		if !strings.HasPrefix(name, partial) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(partial, ".")) {
+			continue
+		}
+
+		rest, suffix := name[len(partial):], ""
+		if entry.IsDir() {
+			suffix = string(filepath.Separator)
+		} else if quoted {
+			suffix = `"`
+		} else {
+			suffix = " "
+		}
+		if !quoted {
+			rest = strings.ReplaceAll(rest, " ", `\ `)
+		}
+		candidates = append(candidates, []rune(rest+suffix))
+	}
+
+	_, typedPartial := filepath.Split(typed)
+	return candidates, len([]rune(typedPartial))
+}
+
+// filepathOrDot returns dir, or the current directory when it is empty
+func filepathOrDot(dir string) string {
+	if dir == "" {
+		return "."
+	}
+	return dir
+}
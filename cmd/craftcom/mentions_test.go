@@ -0,0 +1,99 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+
+	tests := []struct {
+		name      string
+		input     string
+		wantText  string
+		wantFiles []string
+	}{
+		{"none", "list files", "list files", nil},
+		{"plain", "explain @main.go please", "explain main.go please", []string{"main.go"}},
+		{"at start", "@notes.txt summarize", "notes.txt summarize", []string{"notes.txt"}},
+		{"several", "diff @a.txt and @b.txt", "diff a.txt and b.txt", []string{"a.txt", "b.txt"}},
+		{"trailing period", "look at @main.go.", "look at main.go.", []string{"main.go"}},
+		{"trailing punctuation", "is @a.txt, or @b.txt?", "is a.txt, or b.txt?", []string{"a.txt", "b.txt"}},
+		{"in parentheses", "see (@docs/x.md)", "see (docs/x.md)", []string{"docs/x.md"}},
+		{"double quoted", `read @"my notes.txt" now`, "read my notes.txt now", []string{"my notes.txt"}},
+		{"single quoted", `read @'my notes.txt'`, "read my notes.txt", []string{"my notes.txt"}},
+		{"quoted keeps punctuation", `read @"odd name."`, "read odd name.", []string{"odd name."}},
+		{"unterminated quote", `read @"my notes.txt`, `read @"my notes.txt`, nil},
+		{"escaped space", `read @my\ notes.txt now`, "read my notes.txt now", []string{"my notes.txt"}},
+		{"email", "mail me@example.com", "mail me@example.com", nil},
+		{"lone at", "meet @ noon", "meet @ noon", nil},
+		{"at end", "trailing @", "trailing @", nil},
+		{"home", "read @~/notes.txt", "read ~/notes.txt", []string{filepath.Join(home, "notes.txt")}},
+	}
+
+	for _, tt := range tests {
+		text, files := parseMentions(tt.input)
+		if text != tt.wantText {
+			t.Errorf("%s: parseMentions(%q) text = %q, want %q", tt.name, tt.input, text, tt.wantText)
+		}
+		if !reflect.DeepEqual(files, tt.wantFiles) {
+			t.Errorf("%s: parseMentions(%q) files = %q, want %q", tt.name, tt.input, files, tt.wantFiles)
+		}
+	}
+}
+
+func TestTrimMention(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "release.")
+	if err := os.WriteFile(existing, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path         string
+		wantPath     string
+		wantTrailing string
+	}{
+		{"main.go", "main.go", ""},
+		{"main.go.", "main.go", "."},
+		{"main.go?!", "main.go", "?!"},
+		{"x.md)", "x.md", ")"},
+		{"a.txt\"", "a.txt", "\""},
+		{existing, existing, ""},
+		{existing + ",", existing, ","},
+		{"?!", "?!", ""},
+	}
+
+	for _, tt := range tests {
+		path, trailing := trimMention(tt.path)
+		if path != tt.wantPath || trailing != tt.wantTrailing {
+			t.Errorf("trimMention(%q) = %q, %q, want %q, %q", tt.path, path, trailing, tt.wantPath, tt.wantTrailing)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"craftcom/pkg/types"
+)
+
+// Output formats selectable with --output-format
+const (
+	formatMarkdown = "markdown" // Rich terminal output (default)
+	formatPlain    = "plain"    // Terminal output without colors
+	formatJSON     = "json"
+	formatYAML     = "yaml"
+)
+
+// resolveOutputFormat picks the format from the flag, falling back to the
+// configured default
+func resolveOutputFormat(flag, configured string) (string, error) {
+	format := flag
+	if format == "" {
+		format = configured
+	}
+	if format == "" {
+		format = formatMarkdown
+	}
+
+	switch format {
+	case formatMarkdown, formatPlain, formatJSON, formatYAML:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown output format: %s (supported: markdown, plain, json, yaml)", format)
+	}
+}
+
+// isStructuredFormat reports whether the format is meant for other programs
+func isStructuredFormat(format string) bool {
+	return format == formatJSON || format == formatYAML
+}
+
+// executeResult is the structured result of a natural language request
+type executeResult struct {
+	Prompt      string                 `json:"prompt" yaml:"prompt"`
+	Command     string                 `json:"command" yaml:"command"`
+	Explanation string                 `json:"explanation" yaml:"explanation"`
+	Risk        types.RiskLevel        `json:"risk" yaml:"risk"`
+	Metadata    map[string]interface{} `json:"metadata" yaml:"metadata"`
+	Executed    bool                   `json:"executed" yaml:"executed"`
+	ExitCode    *int                   `json:"exit_code,omitempty" yaml:"exit_code,omitempty"`
+	Output      string                 `json:"output,omitempty" yaml:"output,omitempty"`
+	Error       string                 `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// newExecuteResult builds a result from a model response
+func newExecuteResult(prompt string, resp types.Response) *executeResult {
+	// The chat context holds the full environment and is internal state
+	metadata := make(map[string]interface{}, len(resp.Metadata))
+	for key, value := range resp.Metadata {
+		if key == "context" {
+			continue
+		}
+		metadata[key] = value
+	}
+
+	return &executeResult{
+		Prompt:      prompt,
+		Command:     resp.Code,
+		Explanation: resp.FullOutput,
+		Risk:        types.AssessRisk(resp.Code),
+		Metadata:    metadata,
+	}
+}
+
+// setExecution records the outcome of running the command
+func (r *executeResult) setExecution(history types.CommandHistory) {
+	exitCode := history.ExitCode
+	r.Executed = true
+	r.ExitCode = &exitCode
+	r.Output = history.Output
+	r.Error = history.Error
+}
+
+// historyEntry is a history record together with its number
+type historyEntry struct {
+	ID                   int `json:"id" yaml:"id"`
+	types.CommandHistory `yaml:",inline"`
+}
+
+// printStructured writes a value to stdout in the given structured format
+func printStructured(format string, value interface{}) error {
+	switch format {
+	case formatJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			return fmt.Errorf("failed to encode JSON output: %v", err)
+		}
+	case formatYAML:
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(value); err != nil {
+			return fmt.Errorf("failed to encode YAML output: %v", err)
+		}
+		return encoder.Close()
+	default:
+		return fmt.Errorf("not a structured output format: %s", format)
+	}
+	return nil
+}
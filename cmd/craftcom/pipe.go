@@ -0,0 +1,95 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"craftcom/pkg/types"
+)
+
+// maxPipedInput limits how much data is read from stdin
+const maxPipedInput = 10 * 1024 * 1024 // 10MB
+
+// stdinIsPipe reports whether stdin is redirected from a pipe or file
+func stdinIsPipe() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice == 0
+}
+
+// readPipedInput reads all data piped on stdin
+func readPipedInput() ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(os.Stdin, maxPipedInput+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stdin: %v", err)
+	}
+	if len(data) > maxPipedInput {
+		return nil, fmt.Errorf("piped input too large (max %d bytes)", maxPipedInput)
+	}
+	return data, nil
+}
+
+// pipedContent wraps piped data as a text attachment
+func pipedContent(data []byte) *types.FileContent {
+	return &types.FileContent{
+		Type:     types.FileTypeText,
+		Data:     data,
+		MimeType: "text/plain",
+		Name:     "stdin",
+		Size:     int64(len(data)),
+		Metadata: map[string]interface{}{
+			"source": "pipe",
+		},
+	}
+}
+
+// openTerminal opens the controlling terminal so prompts keep working when
+// stdin is a pipe
+func openTerminal() (*os.File, error) {
+	if runtime.GOOS == "windows" {
+		return os.Open("CONIN$")
+	}
+	return os.Open("/dev/tty")
+}
+
+// confirmFromTerminal asks a yes/no question on the controlling terminal
+func confirmFromTerminal(label string) bool {
+	tty, err := openTerminal()
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", label)
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.ToLower(strings.TrimSpace(answer)) == "y"
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"craftcom/pkg/types"
+)
+
+// sessionEntry is one request made during a session and what came of it
+type sessionEntry struct {
+	Prompt      string
+	Command     string
+	Explanation string
+	Executed    bool
+	ExitCode    int
+	Output      string
+	Time        time.Time
+}
+
+// session collects requests so they can be exported with -o, -w and
+// --notebook-file once the run is over
+type session struct {
+	entries []*sessionEntry
+	started time.Time
+	shell   string
+}
+
+// newSession starts an empty session for the current shell
+func newSession() *session {
+	shell := "bash"
+	if sysInfo, err := types.GetSystemInfo(); err == nil && sysInfo.Shell != "" {
+		shell = sysInfo.Shell
+	}
+
+	return &session{
+		started: time.Now(),
+		shell:   shell,
+	}
+}
+
+// add records a model response for a prompt
+func (s *session) add(prompt string, resp types.Response) {
+	s.entries = append(s.entries, &sessionEntry{
+		Prompt:      prompt,
+		Command:     resp.Code,
+		Explanation: resp.FullOutput,
+		Time:        time.Now(),
+	})
+}
+
+// recordExecution attaches the result of running a command to the latest
+// entry, replacing its command when another suggestion was picked
+func (s *session) recordExecution(history types.CommandHistory) {
+	if len(s.entries) == 0 {
+		return
+	}
+
+	entry := s.entries[len(s.entries)-1]
+	entry.Command = history.Command
+	entry.Executed = true
+	entry.ExitCode = history.ExitCode
+	entry.Output = history.Output
+}
+
+// empty reports whether nothing was recorded
+func (s *session) empty() bool {
+	return len(s.entries) == 0
+}
+
+// markdown renders the session as a runbook
+func (s *session) markdown() string {
+	var b strings.Builder
+
+	b.WriteString("# Command Runbook\n\n")
+	fmt.Fprintf(&b, "_Generated by CraftCom on %s_\n", s.started.Format("2006-01-02 15:04"))
+
+	for i, entry := range s.entries {
+		fmt.Fprintf(&b, "\n## %d. %s\n\n", i+1, entry.Prompt)
+
+		if entry.Command != "" {
+			fmt.Fprintf(&b, "```%s\n%s\n```\n\n", s.shell, entry.Command)
+		}
+
+		if entry.Explanation != "" {
+			fmt.Fprintf(&b, "### Explanation\n\n%s\n", strings.TrimSpace(entry.Explanation))
+		}
+
+		if entry.Executed {
+			fmt.Fprintf(&b, "\n### Output (exit code %d)\n\n```\n%s\n```\n", entry.ExitCode, strings.TrimRight(entry.Output, "\n"))
+		}
+	}
+
+	return b.String()
+}
+
+// script renders the session's commands as a shell script with the
+// prompts and explanations as comments. Commands that were not run are
+// commented out, so running the script only repeats what was run.
+func (s *session) script() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "#!/usr/bin/env %s\n", s.shell)
+	fmt.Fprintf(&b, "# Generated by CraftCom on %s\n", s.started.Format("2006-01-02 15:04"))
+
+	for i, entry := range s.entries {
+		if entry.Command == "" {
+			continue
+		}
+
+		b.WriteString("\n")
+		writeComment(&b, fmt.Sprintf("%d. %s", i+1, entry.Prompt))
+		if entry.Explanation != "" {
+			b.WriteString("#\n")
+			writeComment(&b, strings.TrimSpace(entry.Explanation))
+		}
+		if !entry.Executed {
+			b.WriteString("# Not run:\n")
+			writeComment(&b, entry.Command)
+			continue
+		}
+		b.WriteString(entry.Command + "\n")
+	}
+
+	return b.String()
+}
+
+// writeComment writes text as shell comment lines
+func writeComment(b *strings.Builder, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(strings.TrimRight("# "+line, " ") + "\n")
+	}
+}
+
+// markdownCell is a markdown cell in the Jupyter notebook format (nbformat 4)
+type markdownCell struct {
+	CellType string                 `json:"cell_type"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Source   []string               `json:"source"`
+}
+
+// codeCell is a code cell; unexecuted cells have a null execution count
+type codeCell struct {
+	CellType       string                 `json:"cell_type"`
+	ExecutionCount *int                   `json:"execution_count"`
+	Metadata       map[string]interface{} `json:"metadata"`
+	Outputs        []notebookOutput       `json:"outputs"`
+	Source         []string               `json:"source"`
+}
+
+// notebookOutput is a stream output of a code cell
+type notebookOutput struct {
+	OutputType string   `json:"output_type"`
+	Name       string   `json:"name"`
+	Text       []string `json:"text"`
+}
+
+// notebook renders the session as a Jupyter notebook with a markdown cell
+// per prompt and a code cell per command
+func (s *session) notebook() ([]byte, error) {
+	cells := make([]interface{}, 0, len(s.entries)*2)
+	executions := 0
+
+	for _, entry := range s.entries {
+		markdown := "## " + entry.Prompt
+		if entry.Explanation != "" {
+			markdown += "\n\n" + strings.TrimSpace(entry.Explanation)
+		}
+		cells = append(cells, markdownCell{
+			CellType: "markdown",
+			Metadata: map[string]interface{}{},
+			Source:   notebookLines(markdown),
+		})
+
+		if entry.Command == "" {
+			continue
+		}
+
+		cell := codeCell{
+			CellType: "code",
+			Metadata: map[string]interface{}{},
+			Outputs:  []notebookOutput{},
+			Source:   notebookLines(entry.Command),
+		}
+		if entry.Executed {
+			executions++
+			count := executions
+			cell.ExecutionCount = &count
+			cell.Outputs = append(cell.Outputs, notebookOutput{
+				OutputType: "stream",
+				Name:       "stdout",
+				Text:       notebookLines(entry.Output),
+			})
+		}
+		cells = append(cells, cell)
+	}
+
+	notebook := map[string]interface{}{
+		"cells": cells,
+		"metadata": map[string]interface{}{
+			"kernelspec": map[string]string{
+				"display_name": s.shell,
+				"language":     s.shell,
+				"name":         s.shell,
+			},
+			"language_info": map[string]string{
+				"name": s.shell,
+			},
+		},
+		"nbformat":       4,
+		"nbformat_minor": 4,
+	}
+
+	data, err := json.MarshalIndent(notebook, "", " ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode notebook: %v", err)
+	}
+	return data, nil
+}
+
+// notebookLines splits text into lines keeping the line endings, as the
+// notebook format expects for multi-line sources
+func notebookLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	"craftcom/pkg/craftcom"
+	"craftcom/pkg/types"
+)
+
+// shellScripts holds the integration code printed by `craftcom shell-init`.
+// Each script binds Alt-g to replace the current command line with a
+// generated command and installs a hook that records exit codes of commands
+// run directly in the shell.
+var shellScripts = map[string]string{
+	"bash": `# CraftCom shell integration for bash
+# Add to ~/.bashrc:  eval "$(craftcom shell-init bash)"
+
+__craftcom_bin={{quote .Binary}}
+
+# Replace the command line with a generated command (Alt-g)
+__craftcom_widget() {
+    [[ -z "$READLINE_LINE" ]] && return
+    local cmd
+    cmd="$("$__craftcom_bin" suggest -- "$READLINE_LINE")" || return
+    if [[ -n "$cmd" ]]; then
+        READLINE_LINE="$cmd"
+        READLINE_POINT=${#READLINE_LINE}
+    fi
+}
+bind -x '"\eg": __craftcom_widget'
+
+# Report exit codes of commands run outside CraftCom
+__craftcom_last_entry() {
+    local entry
+    entry="$(HISTTIMEFORMAT= builtin history 1)"
+    [[ $entry =~ ^[[:space:]]*([0-9]+)[*]?[[:space:]]+(.*)$ ]] || return 1
+    __craftcom_histnum="${BASH_REMATCH[1]}"
+    __craftcom_histcmd="${BASH_REMATCH[2]}"
+}
+
+__craftcom_precmd() {
+    local exit_code=$?
+    if __craftcom_last_entry && [[ "$__craftcom_histnum" != "$__craftcom_seen" ]]; then
+        __craftcom_seen="$__craftcom_histnum"
+        case "$__craftcom_histcmd" in
+            craftcom|craftcom\ *) ;;
+            *) ("$__craftcom_bin" record --exit-code "$exit_code" -- "$__craftcom_histcmd" >/dev/null 2>&1 &) ;;
+        esac
+    fi
+    return $exit_code
+}
+
+__craftcom_last_entry && __craftcom_seen="$__craftcom_histnum"
+PROMPT_COMMAND="__craftcom_precmd${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+`,
+
+	"zsh": `# CraftCom shell integration for zsh
+# Add to ~/.zshrc:  eval "$(craftcom shell-init zsh)"
+
+__craftcom_bin={{quote .Binary}}
+
+# Replace the command line with a generated command (Alt-g)
+__craftcom_widget() {
+    [[ -z "$BUFFER" ]] && return
+    local cmd
+    cmd="$("$__craftcom_bin" suggest -- "$BUFFER")"
+    if [[ -n "$cmd" ]]; then
+        BUFFER="$cmd"
+        CURSOR=${#BUFFER}
+    fi
+    zle reset-prompt
+}
+zle -N __craftcom_widget
+bindkey '^[g' __craftcom_widget
+
+# Report exit codes of commands run outside CraftCom
+zmodload zsh/datetime 2>/dev/null
+
+__craftcom_preexec() {
+    __craftcom_cmd="$1"
+    __craftcom_start=$EPOCHREALTIME
+}
+
+__craftcom_precmd() {
+    local exit_code=$?
+    [[ -z "$__craftcom_cmd" ]] && return
+    local cmd="$__craftcom_cmd"
+    local -i elapsed
+    unset __craftcom_cmd
+    [[ "$cmd" == craftcom || "$cmd" == "craftcom "* ]] && return
+    (( elapsed = (EPOCHREALTIME - __craftcom_start) * 1000 ))
+    ("$__craftcom_bin" record --exit-code "$exit_code" --duration "${elapsed}ms" -- "$cmd" &>/dev/null &)
+}
+
+autoload -Uz add-zsh-hook
+add-zsh-hook preexec __craftcom_preexec
+add-zsh-hook precmd __craftcom_precmd
+`,
+
+	"fish": `# CraftCom shell integration for fish
+# Add to ~/.config/fish/config.fish:  craftcom shell-init fish | source
+
+set -g __craftcom_bin {{quote .Binary}}
+
+# Replace the command line with a generated command (Alt-g)
+function __craftcom_widget
+    set -l buffer (commandline | string collect)
+    test -z "$buffer"; and return
+    set -l cmd ($__craftcom_bin suggest -- "$buffer" | string collect)
+    if test -n "$cmd"
+        commandline -r -- $cmd
+    end
+    commandline -f repaint
+end
+bind \eg __craftcom_widget
+
+# Report exit codes of commands run outside CraftCom
+function __craftcom_postexec --on-event fish_postexec
+    set -l exit_code $status
+    set -l cmd $argv[1]
+    test -z "$cmd"; and return
+    string match -qr '^craftcom(\s|$)' -- $cmd; and return
+    $__craftcom_bin record --exit-code $exit_code --duration {$CMD_DURATION}ms -- $cmd >/dev/null 2>&1 &
+    disown 2>/dev/null
+end
+`,
+}
+
+// runShellCommand handles commands that are invoked from shell hooks and
+// completion functions
+func runShellCommand(kongCtx *kong.Context, cli *CLI) error {
+	switch commandName(kongCtx) {
+	case "shell-init":
+		return handleShellInit(cli.ShellInit.Shell)
+	case "record":
+		return handleRecord(cli)
+	case "completion":
+		return handleCompletion(cli.Completion.Shell)
+	case "__complete":
+		return handleComplete(kongCtx, cli.Complete.Words)
+	default:
+		return fmt.Errorf("unknown shell command: %s", kongCtx.Command())
+	}
+}
+
+// handleShellInit prints the integration script for the requested shell
+func handleShellInit(shell string) error {
+	return printShellScript(shellScripts, shell)
+}
+
+// printShellScript renders one of the per-shell script templates to stdout
+func printShellScript(scripts map[string]string, shell string) error {
+	if shell == "" {
+		detected, err := currentShell()
+		if err != nil {
+			return err
+		}
+		shell = detected
+	}
+
+	script, ok := scripts[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell: %s (supported: %s)", shell, strings.Join(sortedKeys(scripts), ", "))
+	}
+
+	binary, err := os.Executable()
+	if err != nil {
+		binary = "craftcom"
+	}
+
+	tmpl, err := template.New(shell).Funcs(template.FuncMap{
+		"quote": shellQuote,
+	}).Parse(script)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s script: %v", shell, err)
+	}
+
+	return tmpl.Execute(os.Stdout, struct{ Binary string }{Binary: binary})
+}
+
+// currentShell returns the name of the shell CraftCom was started from
+func currentShell() (string, error) {
+	sysInfo, err := types.GetSystemInfo()
+	if err != nil {
+		return "", fmt.Errorf("failed to detect shell: %v", err)
+	}
+	return sysInfo.Shell, nil
+}
+
+// handleRecord appends a command run outside CraftCom to the history file
+func handleRecord(cli *CLI) error {
+	config, err := loadLibConfig(cli.Config)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	endTime := time.Now()
+	entry := types.CommandHistory{
+		Command:   cli.Record.Command,
+		ExitCode:  cli.Record.ExitCode,
+		StartTime: endTime.Add(-cli.Record.Duration),
+		EndTime:   endTime,
+		Source:    types.HistorySourceShell,
+	}
+
+	store := libterma.NewHistoryStore(config.HistoryFile, config.HistorySize)
+	return store.Append(entry)
+}
+
+// handleSuggest prints only the generated command so shell widgets can
+// substitute it into the command line
+func (app *Application) handleSuggest(ctx context.Context, prompt string) error {
+	chat, err := app.assistant.Chat(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create chat: %v", err)
+	}
+	defer chat.Close()
+
+	resp, err := chat.Send(ctx, prompt)
+	if err != nil {
+		return fmt.Errorf("failed to process command: %v", err)
+	}
+
+	if resp.Code == "" {
+		return fmt.Errorf("no command generated for: %s", prompt)
+	}
+
+	fmt.Println(resp.Code)
+	return nil
+}
+
+// shellQuote wraps a value in single quotes for POSIX shells and fish
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
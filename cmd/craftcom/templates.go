@@ -0,0 +1,146 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"craftcom/pkg/craftcom"
+	"craftcom/pkg/types"
+)
+
+// handleTemplates lists the templates that can be run as @name
+func handleTemplates(cli *CLI) error {
+	config, err := loadConfigWithFlags(cli)
+	if err != nil {
+		return err
+	}
+
+	format, err := resolveOutputFormat(cli.OutputFormat, config.OutputFormat)
+	if err != nil {
+		return err
+	}
+
+	templates, err := config.Templates()
+	if err != nil {
+		return fmt.Errorf("failed to load templates: %v", err)
+	}
+
+	if isStructuredFormat(format) {
+		return printStructured(format, templates)
+	}
+
+	if len(templates) == 0 {
+		info.Println("No templates defined")
+		return nil
+	}
+
+	for _, t := range templates {
+		bold.Println(t.Usage())
+		if t.Description != "" {
+			fmt.Printf("  %s\n", t.Description)
+		}
+		if t.IsCommand() {
+			fmt.Printf("  $ %s\n", t.Command)
+		} else {
+			fmt.Printf("  %s\n", firstLine(t.Prompt))
+		}
+		info.Printf("  from %s\n", t.Source)
+	}
+	return nil
+}
+
+// expandTemplate finds the template named by "@name" and fills in its
+// parameters
+func (app *Application) expandTemplate(name string, args []string) (libterma.Template, string, error) {
+	t, err := app.config.Template(strings.TrimPrefix(name, "@"))
+	if err != nil {
+		return libterma.Template{}, "", err
+	}
+
+	text, err := t.Expand(args)
+	if err != nil {
+		return libterma.Template{}, "", err
+	}
+	app.debug("Template %s expanded to: %s", t.Name, text)
+	return t, text, nil
+}
+
+// handleTemplate runs "craftcom @name args": prompt templates are sent like
+// any request, command templates are run after confirmation
+func (app *Application) handleTemplate(ctx context.Context, cli *CLI) error {
+	t, text, err := app.expandTemplate(cli.Execute.Command, cli.Execute.Files)
+	if err != nil {
+		return err
+	}
+
+	if !t.IsCommand() {
+		cli.Execute.Command = text
+		cli.Execute.Files = nil
+		return app.handleExecute(ctx, cli)
+	}
+
+	if err := app.config.ValidateCommand(text); err != nil {
+		return err
+	}
+
+	prompt := strings.Join(append([]string{cli.Execute.Command}, cli.Execute.Files...), " ")
+	resp := types.Response{Code: text, FullOutput: t.Description}
+	app.session.add(prompt, resp)
+
+	if isStructuredFormat(app.format) {
+		result := newExecuteResult(prompt, resp)
+		var execErr error
+		if !cli.Quiet && app.confirm("Execute this command") {
+			var history types.CommandHistory
+			history, execErr = app.runCommand(ctx, text)
+			result.setExecution(history)
+		}
+		if err := printStructured(app.format, result); err != nil {
+			return err
+		}
+		if execErr != nil {
+			return fmt.Errorf("failed to execute command: %v", execErr)
+		}
+		return app.saveOutput(cli)
+	}
+
+	bold.Println("Template Command:")
+	fmt.Printf("$ %s\n\n", text)
+	if !cli.Quiet {
+		if err := app.confirmAndExecute(ctx, text); err != nil {
+			return err
+		}
+	}
+
+	return app.saveOutput(cli)
+}
+
+// firstLine returns the first line of text, marking that more follows
+func firstLine(text string) string {
+	line, rest, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	if strings.TrimSpace(rest) != "" {
+		return line + " ..."
+	}
+	return line
+}
@@ -0,0 +1,441 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/manifoldco/promptui"
+
+	"craftcom/pkg/craftcom"
+	"craftcom/pkg/secrets"
+	"craftcom/pkg/types"
+)
+
+// safetyLevels are the accepted values of safety_level
+var safetyLevels = []string{"low", "medium", "high"}
+
+// handleConfigure runs the configuration wizard, or resets the config file
+// to defaults with --reset. Flags like --model are not applied, so only
+// the choices made here are saved.
+func handleConfigure(cli *CLI) error {
+	if stdinIsPipe() {
+		return fmt.Errorf("configure needs an interactive terminal")
+	}
+
+	configPath := cli.Config
+	if configPath == "" {
+		path, err := libterma.DefaultConfigPath()
+		if err != nil {
+			return fmt.Errorf("failed to get config path: %v", err)
+		}
+		configPath = path
+	}
+
+	config, err := libterma.LoadConfigFromPath(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+	secrets.PassphrasePrompt = promptPassphrase
+
+	if cli.Configure.Reset {
+		if !confirmPrompt(fmt.Sprintf("Reset %s to defaults (API keys are kept)", configPath)) {
+			return nil
+		}
+		if err := config.Reset(); err != nil {
+			return fmt.Errorf("failed to reset configuration: %v", err)
+		}
+		success.Printf("Configuration at %s reset to defaults\n", configPath)
+		return nil
+	}
+
+	return runConfigurationWizard(context.Background(), config)
+}
+
+// runConfigurationWizard walks through the main settings and saves them
+// after showing what changes
+func runConfigurationWizard(ctx context.Context, config *libterma.Config) error {
+	before, err := config.Values()
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %v", err)
+	}
+
+	bold.Println("CraftCom configuration")
+	info.Printf("Editing %s\n\n", config.Path())
+
+	providerName, err := selectOption("Provider", sortedKeys(config.Providers), config.DefaultProvider)
+	if err != nil {
+		return err
+	}
+	providerConfig := config.Providers[providerName]
+
+	provider, newKey, err := promptAPIKey(ctx, providerName, &providerConfig)
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		defer provider.Close()
+	}
+
+	// Keys stored outside the config file are only written once confirmed
+	var storeKey func() error
+	if newKey != "" {
+		if storeKey, err = chooseKeyStorage(ctx, providerName, newKey, &providerConfig); err != nil {
+			return err
+		}
+	}
+
+	// Prefer the models the provider reports over the configured list
+	models := append([]string{}, providerConfig.Models...)
+	if provider != nil {
+		if available, err := provider.ListModels(ctx); err == nil && len(available) > 0 {
+			models = available
+			sort.Strings(models)
+		}
+	}
+	model, err := selectOption("Default model", models, config.DefaultModel)
+	if err != nil {
+		return err
+	}
+
+	safetyLevel, err := selectOption("Safety level", safetyLevels, config.SafetyLevel)
+	if err != nil {
+		return err
+	}
+
+	shell, err := promptValue("Shell", config.Shell, nil)
+	if err != nil {
+		return err
+	}
+
+	historySize, err := promptValue("History size", strconv.Itoa(config.HistorySize), validatePositiveInt)
+	if err != nil {
+		return err
+	}
+	size, _ := strconv.Atoi(historySize)
+
+	// Models offered by the provider must be listed for validation to pass
+	if len(providerConfig.Models) > 0 && !containsModel(providerConfig.Models, model) {
+		providerConfig.Models = append(providerConfig.Models, model)
+	}
+	providerConfig.Enabled = true
+
+	config.Providers[providerName] = providerConfig
+	config.DefaultProvider = providerName
+	config.DefaultModel = model
+	config.SafetyLevel = safetyLevel
+	config.Shell = shell
+	config.HistorySize = size
+
+	after, err := config.Values()
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %v", err)
+	}
+
+	bold.Println("\nChanges:")
+	if !printConfigDiff(before, after) {
+		info.Println("No changes")
+		return nil
+	}
+	fmt.Println()
+
+	if !confirmPrompt("Save changes to " + config.Path()) {
+		warning.Println("Changes discarded")
+		return nil
+	}
+
+	if storeKey != nil {
+		if err := storeKey(); err != nil {
+			return fmt.Errorf("failed to store API key: %v", err)
+		}
+	}
+	if err := config.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration: %v", err)
+	}
+	success.Printf("Saved %s\n", config.Path())
+
+	for _, key := range []string{"default_provider", "default_model", "safety_level", "shell", "history_size"} {
+		if origin := config.OverriddenBy(key); origin != "" {
+			warning.Printf("Note: %s is set by %s, which takes precedence over the saved value\n", key, origin)
+		}
+	}
+	return nil
+}
+
+// promptAPIKey asks for an API key with masked input and checks it against
+// the provider, returning the working provider and the key entered. An
+// empty answer keeps the current key, wherever it is stored. The key can
+// be kept even when the check fails.
+func promptAPIKey(ctx context.Context, name string, providerConfig *libterma.ProviderConfig) (types.Provider, string, error) {
+	hasKey := providerConfig.APIKey != "" || !providerConfig.SecretSource(name).IsZero()
+
+	for {
+		label := "API key"
+		if hasKey {
+			label += " (enter to keep current)"
+		}
+
+		key, err := (&promptui.Prompt{Label: label, Mask: '*'}).Run()
+		if err != nil {
+			return nil, "", fmt.Errorf("prompt error: %v", err)
+		}
+		key = strings.TrimSpace(key)
+
+		// A new key replaces whatever source the current one comes from
+		candidate := *providerConfig
+		if key != "" {
+			candidate = withAPIKey(candidate, key)
+		}
+
+		s := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
+		s.Prefix = "Checking API key "
+		s.Start()
+		provider, err := libterma.NewProvider(ctx, name, candidate, "")
+		if err == nil {
+			if err = provider.ValidateConfig(); err != nil {
+				provider.Close()
+				provider = nil
+			}
+		}
+		s.Stop()
+
+		if err == nil {
+			success.Println("✓ API key works")
+			return provider, key, nil
+		}
+
+		errLog.Printf("API key check failed: %v\n", err)
+		choice, err := selectOption("What now", []string{"Enter another key", "Keep this key anyway"}, "")
+		if err != nil {
+			return nil, "", err
+		}
+		if choice == "Keep this key anyway" {
+			return nil, key, nil
+		}
+	}
+}
+
+// Places the wizard can store an API key
+const (
+	storeInConfig  = "Config file (plaintext)"
+	storeInFile    = "Encrypted file (passphrase)"
+	storeInKeyring = "Secret Service (system keyring)"
+)
+
+// chooseKeyStorage asks where a new API key is kept and points the
+// provider config at it. Storing the key outside the config file is left
+// to the returned function, so nothing is written before the changes are
+// confirmed.
+func chooseKeyStorage(ctx context.Context, name, key string, providerConfig *libterma.ProviderConfig) (func() error, error) {
+	current := storeInConfig
+	options := []string{storeInConfig, storeInFile}
+	if secrets.KeyringAvailable(ctx) {
+		options = append(options, storeInKeyring)
+		if providerConfig.APIKeyKeyring {
+			current = storeInKeyring
+		}
+	}
+	if providerConfig.APIKeyFile != "" {
+		current = storeInFile
+	}
+
+	choice, err := selectOption("Store the API key in", options, current)
+	if err != nil {
+		return nil, err
+	}
+
+	switch choice {
+	case storeInFile:
+		path := providerConfig.APIKeyFile
+		if path == "" {
+			dir, err := os.UserConfigDir()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get config directory: %v", err)
+			}
+			path = filepath.Join(dir, "craftcom", name+".key.age")
+		}
+		if path, err = promptValue("Encrypted file", path, nil); err != nil {
+			return nil, err
+		}
+		passphrase, err := promptNewPassphrase()
+		if err != nil {
+			return nil, err
+		}
+
+		*providerConfig = withAPIKey(*providerConfig, "")
+		providerConfig.APIKeyFile = path
+		return func() error {
+			if err := secrets.WriteFile(path, key, passphrase); err != nil {
+				return err
+			}
+			success.Printf("API key encrypted to %s\n", path)
+			return nil
+		}, nil
+
+	case storeInKeyring:
+		*providerConfig = withAPIKey(*providerConfig, "")
+		providerConfig.APIKeyKeyring = true
+		return func() error {
+			label := fmt.Sprintf("CraftCom %s API key", name)
+			if err := secrets.StoreKeyring(ctx, libterma.KeyringService, name, label, key); err != nil {
+				return err
+			}
+			success.Println("API key stored in the Secret Service")
+			return nil
+		}, nil
+
+	default:
+		*providerConfig = withAPIKey(*providerConfig, key)
+		return nil, nil
+	}
+}
+
+// withAPIKey returns the provider config with the given plaintext key and
+// no other key source
+func withAPIKey(providerConfig libterma.ProviderConfig, key string) libterma.ProviderConfig {
+	providerConfig.APIKey = key
+	providerConfig.APIKeyEnv = ""
+	providerConfig.APIKeyCmd = ""
+	providerConfig.APIKeyFile = ""
+	providerConfig.APIKeyKeyring = false
+	return providerConfig
+}
+
+// promptPassphrase asks for the passphrase of an encrypted secret file.
+// The prompt goes to stderr to keep stdout clean for structured output.
+func promptPassphrase(path string) (string, error) {
+	prompt := promptui.Prompt{
+		Label:  "Passphrase for " + path,
+		Mask:   '*',
+		Stdout: os.Stderr,
+	}
+	return prompt.Run()
+}
+
+// promptNewPassphrase asks for a new passphrase twice
+func promptNewPassphrase() (string, error) {
+	for {
+		passphrase, err := (&promptui.Prompt{Label: "Passphrase", Mask: '*', Validate: validateNotEmpty}).Run()
+		if err != nil {
+			return "", fmt.Errorf("prompt error: %v", err)
+		}
+		confirmation, err := (&promptui.Prompt{Label: "Repeat passphrase", Mask: '*'}).Run()
+		if err != nil {
+			return "", fmt.Errorf("prompt error: %v", err)
+		}
+		if passphrase == confirmation {
+			return passphrase, nil
+		}
+		errLog.Println("Passphrases do not match")
+	}
+}
+
+// selectOption shows a list with the current value preselected
+func selectOption(label string, options []string, current string) (string, error) {
+	if len(options) == 0 {
+		return "", fmt.Errorf("no options available for %s", strings.ToLower(label))
+	}
+
+	cursor := 0
+	for i, option := range options {
+		if option == current {
+			cursor = i
+		}
+	}
+
+	prompt := promptui.Select{
+		Label:     label,
+		Items:     options,
+		CursorPos: cursor,
+	}
+
+	_, result, err := prompt.Run()
+	if err != nil {
+		return "", fmt.Errorf("prompt error: %v", err)
+	}
+	return result, nil
+}
+
+// promptValue asks for a free-form value, starting from the current one
+func promptValue(label, current string, validate promptui.ValidateFunc) (string, error) {
+	prompt := promptui.Prompt{
+		Label:     label,
+		Default:   current,
+		AllowEdit: true,
+		Validate:  validate,
+	}
+
+	result, err := prompt.Run()
+	if err != nil {
+		return "", fmt.Errorf("prompt error: %v", err)
+	}
+	return strings.TrimSpace(result), nil
+}
+
+// confirmPrompt asks a yes/no question
+func confirmPrompt(label string) bool {
+	prompt := promptui.Prompt{
+		Label:     label,
+		IsConfirm: true,
+	}
+
+	result, err := prompt.Run()
+	return err == nil && strings.ToLower(result) == "y"
+}
+
+// validatePositiveInt accepts whole numbers of at least 1
+func validatePositiveInt(input string) error {
+	value, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil {
+		return errors.New("must be a number")
+	}
+	if value < 1 {
+		return errors.New("must be at least 1")
+	}
+	return nil
+}
+
+// validateNotEmpty rejects blank input
+func validateNotEmpty(input string) error {
+	if strings.TrimSpace(input) == "" {
+		return errors.New("must not be empty")
+	}
+	return nil
+}
+
+// containsModel reports whether models lists model
+func containsModel(models []string, model string) bool {
+	for _, m := range models {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
@@ -1,15 +1,22 @@
 module craftcom
 
-go 1.20
+go 1.21
 
 require (
+	filippo.io/age v1.0.0
+	github.com/BurntSushi/toml v1.3.2
 	github.com/alecthomas/kong v1.4.0
 	github.com/atotto/clipboard v0.1.4
 	github.com/briandowns/spinner v1.23.0
+	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
 	github.com/fatih/color v1.18.0
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/google/generative-ai-go v0.5.0
 	github.com/manifoldco/promptui v0.9.0
+	golang.org/x/sys v0.25.0
 	google.golang.org/api v0.149.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -17,7 +24,6 @@ require (
 	cloud.google.com/go/compute v1.23.1 // indirect
 	cloud.google.com/go/compute/metadata v0.2.3 // indirect
 	cloud.google.com/go/longrunning v0.5.2 // indirect
-	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/google/s2a-go v0.1.7 // indirect
@@ -30,7 +36,6 @@ require (
 	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/oauth2 v0.13.0 // indirect
 	golang.org/x/sync v0.4.0 // indirect
-	golang.org/x/sys v0.25.0 // indirect
 	golang.org/x/term v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
@@ -0,0 +1,296 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package attach expands directories and glob patterns given as
+// attachments into the text files they contain, skipping files ignored by
+// .gitignore or .craftcomignore, and packs them into one attachment that
+// fits a token budget.
+package attach
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"craftcom/pkg/types"
+)
+
+// skippedOverBudget is why files that do not fit the budget are left out
+const skippedOverBudget = "over the token budget"
+
+// Options controls how attachments are expanded
+type Options struct {
+	// Reader reads files, applying the file access policy and the allowed
+	// file types
+	Reader *types.FileReader
+	// Budget is the most tokens packed into one attachment, 0 for no limit
+	Budget int
+	// Count counts tokens with the model's tokenizer; they are estimated
+	// when it is nil
+	Count types.TokenCounter
+}
+
+// Bundle is the result of expanding a directory or glob pattern
+type Bundle struct {
+	Pattern string             // Directory or glob pattern expanded
+	Files   []string           // Files packed, in order
+	Skipped map[string]string  // Files left out and why
+	Tokens  int                // Tokens of the packed files
+	Content *types.FileContent // Packed files, nil when there are none
+}
+
+// IsPattern reports whether an attachment is a directory or a glob
+// pattern rather than a single file. Existing files are never patterns,
+// even when their name contains glob characters.
+func IsPattern(arg string) bool {
+	file, _ := types.SplitPageRange(arg)
+	if stat, err := os.Stat(file); err == nil {
+		return stat.IsDir()
+	}
+	return strings.ContainsAny(arg, "*?[")
+}
+
+// Expand packs the text files in a directory, or matching a glob pattern
+// such as "src/**/*.go", into one text attachment with a header before
+// each file. Files are added in path order until the budget is used up.
+func Expand(ctx context.Context, pattern string, opts Options) (*Bundle, error) {
+	base, match := splitPattern(pattern)
+	stat, err := os.Stat(base)
+	if err != nil {
+		return nil, types.ErrInputf("failed to read %s: %v", base, err)
+	}
+	if !stat.IsDir() {
+		return nil, types.ErrInputf("not a directory: %s", base)
+	}
+
+	paths, err := walk(ctx, base, match)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, types.ErrInputf("no files match %s", pattern)
+	}
+
+	// Files are packed by their length, then packed again when the count
+	// of the packed files shows tokens hold more or fewer characters
+	bundle, err := pack(ctx, pattern, paths, opts.Reader, opts.Budget*types.CharsPerToken)
+	if err != nil || bundle.Content == nil {
+		return bundle, err
+	}
+	text := string(bundle.Content.Data)
+	tokens, _ := types.CountTokens(ctx, opts.Count, text)
+	if opts.Budget > 0 && tokens > 0 && (tokens > opts.Budget || bundle.overBudget()) {
+		budget := opts.Budget * len(text) / tokens
+		if bundle, err = pack(ctx, pattern, paths, opts.Reader, budget); err != nil || bundle.Content == nil {
+			return bundle, err
+		}
+		tokens, _ = types.CountTokens(ctx, opts.Count, string(bundle.Content.Data))
+	}
+	bundle.Tokens = tokens
+	return bundle, nil
+}
+
+// pack packs files into one text attachment of at most budget bytes, 0
+// for no limit
+func pack(ctx context.Context, pattern string, paths []string, reader *types.FileReader, budget int) (*Bundle, error) {
+	bundle := &Bundle{Pattern: pattern, Skipped: make(map[string]string)}
+	var packed strings.Builder
+
+	for _, file := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Skip what cannot fit before reading it
+		if stat, err := os.Stat(file); err == nil && budget > 0 && packed.Len()+int(stat.Size()) > budget {
+			bundle.Skipped[file] = skippedOverBudget
+			continue
+		}
+
+		content, err := reader.ReadFile(ctx, file)
+		if err != nil {
+			bundle.Skipped[file] = skipReason(err)
+			continue
+		}
+		if content.Type != types.FileTypeText {
+			bundle.Skipped[file] = fmt.Sprintf("%s file", content.Type)
+			continue
+		}
+
+		section := fmt.Sprintf("=== %s ===\n%s\n\n", filepath.ToSlash(file), strings.TrimRight(content.String(), "\n"))
+		if budget > 0 && packed.Len()+len(section) > budget {
+			bundle.Skipped[file] = skippedOverBudget
+			continue
+		}
+		packed.WriteString(section)
+		bundle.Files = append(bundle.Files, file)
+	}
+
+	if len(bundle.Files) == 0 {
+		return bundle, nil
+	}
+
+	data := []byte(fmt.Sprintf("%d files from %s:\n\n%s", len(bundle.Files), pattern, packed.String()))
+	bundle.Content = &types.FileContent{
+		Type:     types.FileTypeText,
+		Data:     data,
+		MimeType: "text/plain; charset=utf-8",
+		Name:     pattern,
+		Size:     int64(len(data)),
+		Metadata: map[string]interface{}{
+			"source": "directory",
+			"files":  bundle.Files,
+		},
+	}
+	return bundle, nil
+}
+
+// overBudget reports whether files were left out for the budget
+func (b *Bundle) overBudget() bool {
+	for _, reason := range b.Skipped {
+		if reason == skippedOverBudget {
+			return true
+		}
+	}
+	return false
+}
+
+// skipReason shortens a read error to why a file was left out
+func skipReason(err error) string {
+	message := err.Error()
+	if i := strings.LastIndex(message, "_error: "); i >= 0 {
+		message = message[i+len("_error: "):]
+	}
+	return message
+}
+
+// splitPattern splits a glob pattern into the directory to walk and the
+// pattern files in it must match, relative to that directory. A directory
+// matches every file in it.
+func splitPattern(pattern string) (string, []string) {
+	pattern = filepath.Clean(pattern)
+	if !strings.ContainsAny(pattern, "*?[") {
+		return pattern, []string{"**"}
+	}
+
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	i := 0
+	for i < len(segments)-1 && !strings.ContainsAny(segments[i], "*?[") {
+		i++
+	}
+	base := strings.Join(segments[:i], "/")
+	switch {
+	case base == "" && strings.HasPrefix(pattern, "/"):
+		base = "/"
+	case base == "":
+		base = "."
+	}
+	return filepath.FromSlash(base), segments[i:]
+}
+
+// walk returns the files under base matching the pattern segments, in
+// path order, leaving out .git and what ignore files exclude
+func walk(ctx context.Context, base string, match []string) ([]string, error) {
+	top, rel := base, "."
+	if abs, err := filepath.Abs(base); err == nil {
+		if root := repositoryRoot(abs); root != "" {
+			if r, err := filepath.Rel(root, abs); err == nil {
+				top, rel = root, r
+			}
+		}
+	}
+
+	// Rules of the directories above base apply too
+	var rules ignoreList
+	dir := "."
+	rules = rules.withDir(top, dir)
+	if rel != "." {
+		for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+			dir = filepath.Join(dir, name)
+			rules = rules.withDir(top, dir)
+		}
+	}
+	dirRules := map[string]ignoreList{filepath.Clean(base): rules}
+
+	var files []string
+	err := filepath.WalkDir(base, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable entries are left out
+			if entry != nil && entry.IsDir() && file != base {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if file == base {
+			return nil
+		}
+
+		parent := dirRules[filepath.Dir(file)]
+		fromBase, err := filepath.Rel(base, file)
+		if err != nil {
+			return nil
+		}
+		fromTop := filepath.Join(rel, fromBase)
+		if entry.IsDir() {
+			if entry.Name() == ".git" || parent.ignored(fromTop, true) {
+				return filepath.SkipDir
+			}
+			dirRules[file] = parent.withDir(top, fromTop)
+			return nil
+		}
+		if !entry.Type().IsRegular() || parent.ignored(fromTop, false) {
+			return nil
+		}
+
+		if !matchSegments(match, strings.Split(filepath.ToSlash(fromBase), "/")) {
+			return nil
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// repositoryRoot returns the root of the git repository an absolute dir
+// is in, so its ignore files apply, or "" outside a repository
+func repositoryRoot(dir string) string {
+	for current := dir; ; {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return current
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return ""
+		}
+		current = parent
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package attach
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFiles are read in every directory walked, in this order, so
+// .craftcomignore can add to or override .gitignore
+var ignoreFiles = []string{".gitignore", ".craftcomignore"}
+
+// ignoreRule is one line of an ignore file
+type ignoreRule struct {
+	base     string   // Directory of the ignore file, relative to the walk root
+	segments []string // Pattern split on "/"
+	anchored bool     // Pattern contains a slash, so it matches from base
+	negate   bool     // Pattern starts with "!" and re-includes matches
+	dirOnly  bool     // Pattern ends with "/" and only matches directories
+}
+
+// ignoreList holds the rules that apply in a directory, from its own
+// ignore files and those of its parents
+type ignoreList []ignoreRule
+
+// parseIgnoreFile reads the rules of an ignore file in dir, relative to
+// base. Missing files have no rules.
+func parseIgnoreFile(file, base string) ignoreList {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules ignoreList
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate, line = true, line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored, line = true, strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.segments = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// withDir returns the rules that apply in dir, the rules of its parent
+// followed by those of its own ignore files
+func (l ignoreList) withDir(root, dir string) ignoreList {
+	rules := l
+	for _, name := range ignoreFiles {
+		if added := parseIgnoreFile(filepath.Join(root, dir, name), dir); len(added) > 0 {
+			rules = append(rules[:len(rules):len(rules)], added...)
+		}
+	}
+	return rules
+}
+
+// ignored reports whether a path relative to the walk root is ignored.
+// The last rule matching decides, as in git.
+func (l ignoreList) ignored(rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	ignored := false
+	for _, rule := range l {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.matches(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matches reports whether a rule matches a path relative to the walk root
+func (r ignoreRule) matches(rel string) bool {
+	if r.base != "." {
+		prefix := filepath.ToSlash(r.base) + "/"
+		if !strings.HasPrefix(rel, prefix) {
+			return false
+		}
+		rel = rel[len(prefix):]
+	}
+
+	if !r.anchored {
+		matched, _ := path.Match(r.segments[0], path.Base(rel))
+		return matched
+	}
+	return matchSegments(r.segments, strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments, with "**" standing for any number
+// of them
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(name); i >= 0; i-- {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package attach
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates files under dir, with directories for paths ending
+// in "/"
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"basename anywhere", "*.log", "a/b/debug.log", false, true},
+		{"no match", "*.log", "a/b/debug.txt", false, false},
+		{"negation", "*.log\n!keep.log", "logs/keep.log", false, false},
+		{"negation then ignore again", "*.log\n!keep.log\nkeep.log", "keep.log", false, true},
+		{"dir-only matches directory", "build/", "build", true, true},
+		{"dir-only skips file", "build/", "build", false, false},
+		{"dir-only nested directory", "build/", "src/build", true, true},
+		{"anchored", "/todo.txt", "todo.txt", false, true},
+		{"anchored not below", "/todo.txt", "docs/todo.txt", false, false},
+		{"path with slash is anchored", "docs/*.md", "docs/a.md", false, true},
+		{"path with slash not nested", "docs/*.md", "src/docs/a.md", false, false},
+		{"double star", "docs/**/*.tmp", "docs/a/b/c.tmp", false, true},
+		{"double star no dirs", "docs/**/*.tmp", "docs/c.tmp", false, true},
+		{"leading double star", "**/cache", "a/b/cache", true, true},
+		{"comment", "# *.log", "a.log", false, false},
+		{"escaped hash", `\#notes`, "#notes", false, true},
+		{"escaped bang", `\!important`, "!important", false, true},
+		{"trailing spaces", "*.bak  ", "a.bak", false, true},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{".gitignore": tt.rules})
+		rules := ignoreList(nil).withDir(dir, ".")
+		if got := rules.ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%s: ignored(%q, %v) with %q = %v, want %v", tt.name, tt.path, tt.isDir, tt.rules, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoredNestedRules(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":          "*.log\n",
+		"sub/.craftcomignore": "!keep.log\n/local.txt\n",
+	})
+	rules := ignoreList(nil).withDir(dir, ".").withDir(dir, "sub")
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"sub/debug.log", true},
+		{"sub/keep.log", false},
+		{"sub/local.txt", true},
+		{"sub/deeper/local.txt", false},
+		{"local.txt", false},
+	}
+	for _, tt := range tests {
+		if got := rules.ignored(tt.path, false); got != tt.want {
+			t.Errorf("ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/b/c", false},
+		{"a/*", "a/b", true},
+		{"a/*", "a/b/c", false},
+		{"**", "a/b/c", true},
+		{"**/c", "c", true},
+		{"**/c", "a/b/c", true},
+		{"a/**", "a/b/c", true},
+		{"a/**/c", "a/c", true},
+		{"a/**/c", "a/x/y/c", true},
+		{"a/**/c", "a/x/y/d", false},
+		{"*.go", "main.go", true},
+		{"[ab].txt", "b.txt", true},
+		{"[ab].txt", "c.txt", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchSegments(strings.Split(tt.pattern, "/"), strings.Split(tt.name, "/")); got != tt.want {
+			t.Errorf("matchSegments(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".git/":               "",
+		".gitignore":          "build/\n*.log\n!keep.log\n",
+		"main.go":             "package main",
+		"debug.log":           "",
+		"keep.log":            "",
+		"build/out.txt":       "",
+		"src/build":           "a file named like an ignored directory",
+		"src/app.go":          "package src",
+		"src/.craftcomignore": "*.gen.go\n",
+		"src/api.gen.go":      "package src",
+	})
+
+	tests := []struct {
+		base  string
+		match []string
+		want  []string
+	}{
+		{".", []string{"**"}, []string{".gitignore", "keep.log", "main.go", "src/.craftcomignore", "src/app.go", "src/build"}},
+		{".", []string{"**", "*.go"}, []string{"main.go", "src/app.go"}},
+		{"src", []string{"**"}, []string{"src/.craftcomignore", "src/app.go", "src/build"}},
+	}
+
+	for _, tt := range tests {
+		files, err := walk(context.Background(), filepath.Join(dir, tt.base), tt.match)
+		if err != nil {
+			t.Fatalf("walk(%q): %v", tt.base, err)
+		}
+		got := make([]string, len(files))
+		for i, file := range files {
+			rel, _ := filepath.Rel(dir, file)
+			got[i] = filepath.ToSlash(rel)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("walk(%q, %v) = %v, want %v", tt.base, tt.match, got, tt.want)
+		}
+	}
+}
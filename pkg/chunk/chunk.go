@@ -0,0 +1,102 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package chunk handles text too large to send in one request. The text is
+// split into overlapping parts at line breaks, each part is summarized
+// with what matters for the request, and the summaries replace the text.
+package chunk
+
+import "strings"
+
+// Chunk is a part of a text
+type Chunk struct {
+	Text      string
+	FirstLine int
+	LastLine  int
+}
+
+// Split splits text into chunks of at most maxChars bytes, each repeating
+// the last overlapChars of the one before so nothing is lost at the seams.
+// Chunks end at line breaks, at a blank line when there is one near the
+// end so functions and paragraphs stay whole. Lines longer than a chunk
+// are split.
+func Split(text string, maxChars, overlapChars int) []Chunk {
+	if maxChars <= 0 {
+		return []Chunk{{Text: text, FirstLine: 1, LastLine: strings.Count(text, "\n") + 1}}
+	}
+	if overlapChars >= maxChars/2 {
+		overlapChars = maxChars / 4
+	}
+
+	// Lines keep their line break; overlong lines are split in pieces
+	// that keep the line number
+	type line struct {
+		text   string
+		number int
+	}
+	var lines []line
+	for i, text := range strings.SplitAfter(text, "\n") {
+		for len(text) > maxChars {
+			lines = append(lines, line{text[:maxChars], i + 1})
+			text = text[maxChars:]
+		}
+		if text != "" {
+			lines = append(lines, line{text, i + 1})
+		}
+	}
+
+	var chunks []Chunk
+	for start := 0; start < len(lines); {
+		end, size := start, 0
+		for end < len(lines) && size+len(lines[end].text) <= maxChars {
+			size += len(lines[end].text)
+			end++
+		}
+
+		// Prefer ending after a blank line in the last fifth of the chunk
+		if end < len(lines) {
+			for i, tail := end-1, 0; i > start && tail < size/5; i-- {
+				if strings.TrimSpace(lines[i].text) == "" {
+					end = i + 1
+					break
+				}
+				tail += len(lines[i].text)
+			}
+		}
+
+		var b strings.Builder
+		for _, l := range lines[start:end] {
+			b.WriteString(l.text)
+		}
+		chunks = append(chunks, Chunk{Text: b.String(), FirstLine: lines[start].number, LastLine: lines[end-1].number})
+		if end == len(lines) {
+			break
+		}
+
+		// Step back over the overlap, always moving forward
+		next, overlap := end, 0
+		for next-1 > start && overlap+len(lines[next-1].text) <= overlapChars {
+			next--
+			overlap += len(lines[next].text)
+		}
+		start = next
+	}
+	return chunks
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chunk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"craftcom/pkg/types"
+)
+
+// maxLevels limits how often summaries that are still too large are
+// summarized again
+const maxLevels = 3
+
+// SystemPrompt is the system prompt of the chat parts are summarized in
+const SystemPrompt = `You summarize parts of large texts, such as logs, source code and documents, for a request about the whole text.
+Keep exact error messages, names, numbers and line numbers. Do not answer the request or suggest commands.`
+
+// Options controls how oversized text is summarized
+type Options struct {
+	// Budget is the most tokens of text sent in one request
+	Budget int
+	// Count counts tokens with the model's tokenizer; they are estimated
+	// when it is nil
+	Count types.TokenCounter
+	// Progress is called before each part is summarized
+	Progress func(name string, part, parts int)
+}
+
+// Oversized reports whether content is text too large for the budget.
+// Text estimated at under half the budget is not counted.
+func Oversized(ctx context.Context, content *types.FileContent, opts Options) bool {
+	if content.Type != types.FileTypeText || opts.Budget <= 0 {
+		return false
+	}
+	text := string(content.Data)
+	if types.EstimateTokens(text) <= opts.Budget/2 {
+		return false
+	}
+	tokens, _ := types.CountTokens(ctx, opts.Count, text)
+	return tokens > opts.Budget
+}
+
+// Summarize summarizes text content too large for one request part by
+// part, keeping what matters for the request, and returns the combined
+// summaries to send in its place. Summaries still too large are
+// summarized again. chat should be a chat with SystemPrompt that sends
+// no context with requests.
+func Summarize(ctx context.Context, chat types.Chat, request string, content *types.FileContent, opts Options) (*types.FileContent, error) {
+	text, name := string(content.Data), content.Name
+	parts := 0
+
+	for level := 1; ; level++ {
+		// Parts are sized by how many characters a token of the text holds
+		charsPerToken := types.CharsPerTokenOf(ctx, opts.Count, text)
+		if float64(len(text)) <= float64(opts.Budget)*charsPerToken {
+			break
+		}
+		if level > maxLevels {
+			return nil, types.ErrInputf("%s is too large to summarize", name)
+		}
+
+		// Leave room in each request for the instructions and context
+		maxChars := int(float64(opts.Budget*3/4) * charsPerToken)
+		chunks := Split(text, maxChars, maxChars/50)
+		summaries := make([]string, 0, len(chunks))
+		for i, chunk := range chunks {
+			if opts.Progress != nil {
+				opts.Progress(name, i+1, len(chunks))
+			}
+
+			part := &types.FileContent{
+				Type:     types.FileTypeText,
+				Data:     []byte(chunk.Text),
+				MimeType: "text/plain; charset=utf-8",
+				Name:     fmt.Sprintf("%s, part %d of %d", name, i+1, len(chunks)),
+				Size:     int64(len(chunk.Text)),
+			}
+			resp, err := chat.SendWithAttachments(ctx, mapPrompt(request, name, level), nil, []*types.FileContent{part})
+			if err != nil {
+				return nil, types.ErrExecutionf("failed to summarize part %d of %s: %v", i+1, name, err)
+			}
+			summaries = append(summaries, fmt.Sprintf("--- Part %d of %d, lines %d-%d ---\n%s",
+				i+1, len(chunks), chunk.FirstLine, chunk.LastLine, strings.TrimSpace(resp.FullOutput)))
+		}
+
+		if level == 1 {
+			parts = len(chunks)
+		}
+		text = strings.Join(summaries, "\n\n")
+		name = fmt.Sprintf("summaries of %s", content.Name)
+	}
+
+	data := []byte(fmt.Sprintf("%s was too large to send whole. These are summaries of its %d consecutive parts, "+
+		"with the line numbers of the original:\n\n%s", content.Name, parts, text))
+	return &types.FileContent{
+		Type:     types.FileTypeText,
+		Data:     data,
+		MimeType: "text/plain; charset=utf-8",
+		Name:     content.Name + " (summarized)",
+		Size:     int64(len(data)),
+		Metadata: map[string]interface{}{
+			"source": "summary",
+			"parts":  parts,
+		},
+	}, nil
+}
+
+// mapPrompt asks for the summary of one part
+func mapPrompt(request, name string, level int) string {
+	subject := "a part of " + name
+	if level > 1 {
+		subject = "summaries of consecutive parts of " + name
+	}
+	return fmt.Sprintf("The attached text is %s, which is too large to send whole. "+
+		"Summarize it for this request, which will be answered from the summaries of all parts: %q\n\n"+
+		"Keep everything relevant to the request, with exact error messages, names, numbers and line numbers. "+
+		"Leave out what is irrelevant. Do not answer the request or suggest commands.", subject, request)
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package collector gathers information about the project and environment
+// a request is made in, so suggestions fit the tools that are actually
+// installed.
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeTimeout bounds each external command run while collecting, such as
+// git or a version query
+const probeTimeout = time.Second
+
+// Options selects what is collected. Budget is the maximum number of
+// characters added to a request; 0 disables collection.
+type Options struct {
+	Budget    int
+	Project   bool
+	Git       bool
+	Tools     bool
+	Versions  bool
+	Directory bool
+}
+
+// Collector builds the context section sent with each request
+type Collector struct {
+	options func() Options
+
+	// Versions do not change during a session, so each is queried once
+	versions   map[string]string
+	versionsMu sync.Mutex
+}
+
+// New creates a collector. options is called for each request, so changed
+// settings apply without creating a new collector.
+func New(options func() Options) *Collector {
+	return &Collector{
+		options:  options,
+		versions: make(map[string]string),
+	}
+}
+
+// section is one item of collected context
+type section struct {
+	title string
+	lines []string
+}
+
+// Collect returns the context for a request made in the current
+// directory, or "" when collection is disabled. Sections are added in
+// order of usefulness until the budget is used up.
+func (c *Collector) Collect(ctx context.Context, request string) string {
+	options := c.options()
+	if options.Budget <= 0 {
+		return ""
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+
+	var project *projectInfo
+	if options.Project || options.Versions {
+		project = detectProject(dir)
+	}
+
+	var sections []section
+	if options.Project && project != nil {
+		sections = append(sections, project.section())
+	}
+	if options.Git {
+		if s, ok := gitSection(ctx, dir); ok {
+			sections = append(sections, s)
+		}
+	}
+	if options.Tools {
+		if s, ok := toolsSection(request); ok {
+			sections = append(sections, s)
+		}
+	}
+	if options.Versions {
+		if s, ok := c.versionsSection(ctx, project, request); ok {
+			sections = append(sections, s)
+		}
+	}
+	if options.Directory {
+		if s, ok := directorySection(dir); ok {
+			sections = append(sections, s)
+		}
+	}
+
+	return render(sections, options.Budget)
+}
+
+// render formats sections within budget characters. A section that does
+// not fit is cut at a line boundary.
+func render(sections []section, budget int) string {
+	var b strings.Builder
+	for _, s := range sections {
+		header := s.title + ":\n"
+		if b.Len()+len(header) >= budget {
+			break
+		}
+
+		var body strings.Builder
+		truncated := false
+		for _, line := range s.lines {
+			entry := "  " + line + "\n"
+			if b.Len()+len(header)+body.Len()+len(entry) > budget {
+				truncated = true
+				break
+			}
+			body.WriteString(entry)
+		}
+		if body.Len() == 0 {
+			break
+		}
+
+		b.WriteString(header)
+		b.WriteString(body.String())
+		if truncated {
+			break
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// run executes a probe command in dir and returns its trimmed output
+func run(ctx context.Context, dir, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
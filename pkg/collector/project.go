@@ -0,0 +1,305 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package collector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// maxMakeTargets limits how many Makefile targets are listed
+const maxMakeTargets = 20
+
+// makeTargetPattern matches rule lines, but not variable assignments
+var makeTargetPattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9_./-]*)\s*:([^=]|$)`)
+
+// projectInfo describes the project a directory belongs to
+type projectInfo struct {
+	root        string
+	dir         string
+	kinds       []string
+	makeTargets []string
+	toolchains  []string // Commands whose versions matter to the project
+}
+
+// projectMarker recognizes a kind of project from a file in its root
+type projectMarker struct {
+	files  []string
+	detect func(root, file string) (kind string, toolchains []string)
+}
+
+// projectMarkers are checked in order; several may match one project
+var projectMarkers = []projectMarker{
+	{[]string{"go.mod"}, detectGo},
+	{[]string{"package.json"}, detectNode},
+	{[]string{"Cargo.toml"}, detectRust},
+	{[]string{"pyproject.toml", "requirements.txt", "setup.py"}, detectPython},
+	{[]string{"Gemfile"}, fixedKind("Ruby project (bundler)", "ruby", "bundle")},
+	{[]string{"composer.json"}, fixedKind("PHP project (composer)", "php", "composer")},
+	{[]string{"pom.xml"}, fixedKind("Java project (Maven)", "java", "mvn")},
+	{[]string{"build.gradle", "build.gradle.kts"}, fixedKind("Java project (Gradle)", "java", "gradle")},
+	{[]string{"CMakeLists.txt"}, fixedKind("CMake project", "cmake")},
+	{[]string{"Dockerfile", "docker-compose.yml", "docker-compose.yaml", "compose.yaml"}, fixedKind("Docker", "docker")},
+}
+
+// detectProject walks up from dir to the nearest directory with a known
+// project file, stopping at the home directory
+func detectProject(dir string) *projectInfo {
+	homeDir, _ := os.UserHomeDir()
+	for current := dir; ; {
+		if info := readProject(current); info != nil {
+			info.dir = dir
+			return info
+		}
+		parent := filepath.Dir(current)
+		if parent == current || (homeDir != "" && current == homeDir) {
+			return nil
+		}
+		current = parent
+	}
+}
+
+// readProject recognizes the project in root, if any
+func readProject(root string) *projectInfo {
+	info := &projectInfo{root: root}
+
+	for _, marker := range projectMarkers {
+		for _, name := range marker.files {
+			if !isFile(filepath.Join(root, name)) {
+				continue
+			}
+			kind, toolchains := marker.detect(root, name)
+			info.kinds = append(info.kinds, kind)
+			info.toolchains = appendMissing(info.toolchains, toolchains...)
+			break
+		}
+	}
+
+	for _, name := range []string{"GNUmakefile", "Makefile", "makefile"} {
+		if path := filepath.Join(root, name); isFile(path) {
+			info.makeTargets = makeTargets(path)
+			info.toolchains = appendMissing(info.toolchains, "make")
+			break
+		}
+	}
+
+	if len(info.kinds) == 0 && info.makeTargets == nil {
+		return nil
+	}
+	return info
+}
+
+// section describes the project for the model
+func (p *projectInfo) section() section {
+	var lines []string
+	if p.root != p.dir {
+		lines = append(lines, "Root: "+p.root)
+	}
+	lines = append(lines, p.kinds...)
+	if len(p.makeTargets) > 0 {
+		lines = append(lines, "Make targets: "+strings.Join(p.makeTargets, ", "))
+	}
+	return section{title: "Project", lines: lines}
+}
+
+// detectGo reads the module path and Go version from go.mod
+func detectGo(root, file string) (string, []string) {
+	kind := "Go module"
+	data, err := os.ReadFile(filepath.Join(root, file))
+	if err != nil {
+		return kind, []string{"go"}
+	}
+
+	var module, version string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "module":
+			module = strings.Trim(fields[1], `"`)
+		case "go":
+			version = fields[1]
+		}
+	}
+
+	if module != "" {
+		kind += " " + module
+	}
+	if version != "" {
+		kind += fmt.Sprintf(" (go %s)", version)
+	}
+	return kind, []string{"go"}
+}
+
+// detectNode reads the package name and scripts from package.json and
+// the package manager from the lock file
+func detectNode(root, file string) (string, []string) {
+	manager := "npm"
+	for _, lock := range []struct{ file, manager string }{
+		{"pnpm-lock.yaml", "pnpm"},
+		{"yarn.lock", "yarn"},
+		{"bun.lockb", "bun"},
+	} {
+		if isFile(filepath.Join(root, lock.file)) {
+			manager = lock.manager
+			break
+		}
+	}
+
+	kind := "Node.js package"
+	var pkg struct {
+		Name    string            `json:"name"`
+		Scripts map[string]string `json:"scripts"`
+	}
+	if data, err := os.ReadFile(filepath.Join(root, file)); err == nil && json.Unmarshal(data, &pkg) == nil {
+		if pkg.Name != "" {
+			kind += " " + pkg.Name
+		}
+		kind += fmt.Sprintf(" (%s)", manager)
+		if len(pkg.Scripts) > 0 {
+			scripts := make([]string, 0, len(pkg.Scripts))
+			for name := range pkg.Scripts {
+				scripts = append(scripts, name)
+			}
+			sort.Strings(scripts)
+			kind += ", scripts: " + strings.Join(scripts, ", ")
+		}
+	}
+	return kind, []string{"node", manager}
+}
+
+// detectRust reads the crate name from Cargo.toml
+func detectRust(root, file string) (string, []string) {
+	kind := "Rust crate"
+	var manifest struct {
+		Package struct {
+			Name string `toml:"name"`
+		} `toml:"package"`
+		Workspace *struct{} `toml:"workspace"`
+	}
+	if _, err := toml.DecodeFile(filepath.Join(root, file), &manifest); err == nil {
+		switch {
+		case manifest.Package.Name != "":
+			kind += " " + manifest.Package.Name
+		case manifest.Workspace != nil:
+			kind = "Rust workspace"
+		}
+	}
+	return kind, []string{"cargo"}
+}
+
+// detectPython reads the project name from pyproject.toml and the package
+// manager from the lock file
+func detectPython(root, file string) (string, []string) {
+	manager := "pip"
+	for _, lock := range []struct{ file, manager string }{
+		{"poetry.lock", "poetry"},
+		{"uv.lock", "uv"},
+		{"Pipfile.lock", "pipenv"},
+	} {
+		if isFile(filepath.Join(root, lock.file)) {
+			manager = lock.manager
+			break
+		}
+	}
+
+	kind := "Python project"
+	if file == "pyproject.toml" {
+		var pyproject struct {
+			Project struct {
+				Name string `toml:"name"`
+			} `toml:"project"`
+			Tool struct {
+				Poetry struct {
+					Name string `toml:"name"`
+				} `toml:"poetry"`
+			} `toml:"tool"`
+		}
+		if _, err := toml.DecodeFile(filepath.Join(root, file), &pyproject); err == nil {
+			if name := pyproject.Project.Name; name != "" {
+				kind += " " + name
+			} else if name := pyproject.Tool.Poetry.Name; name != "" {
+				kind += " " + name
+			}
+		}
+	}
+	return kind + fmt.Sprintf(" (%s)", manager), []string{"python3", manager}
+}
+
+// fixedKind recognizes a project without reading its files
+func fixedKind(kind string, toolchains ...string) func(root, file string) (string, []string) {
+	return func(root, file string) (string, []string) {
+		return kind, toolchains
+	}
+}
+
+// makeTargets lists the explicit targets of a Makefile
+func makeTargets(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	targets := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() && len(targets) < maxMakeTargets {
+		match := makeTargetPattern.FindStringSubmatch(scanner.Text())
+		if match == nil || strings.Contains(match[1], "%") {
+			continue
+		}
+		targets = appendMissing(targets, match[1])
+	}
+	return targets
+}
+
+// isFile reports whether path is an existing regular file
+func isFile(path string) bool {
+	stat, err := os.Stat(path)
+	return err == nil && stat.Mode().IsRegular()
+}
+
+// appendMissing appends the values not yet in list
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
@@ -0,0 +1,347 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxListedEntries limits the directory listing
+const maxListedEntries = 40
+
+// toolKeywords maps words in a request to the tools it may need, so the
+// model learns which are missing as well as which are installed
+var toolKeywords = map[string][]string{
+	"docker":     {"docker", "podman"},
+	"container":  {"docker", "podman"},
+	"compose":    {"docker-compose"},
+	"kubernetes": {"kubectl", "helm"},
+	"k8s":        {"kubectl", "helm"},
+	"pod":        {"kubectl"},
+	"pods":       {"kubectl"},
+	"helm":       {"helm"},
+	"json":       {"jq"},
+	"yaml":       {"yq"},
+	"search":     {"rg", "fd", "grep"},
+	"grep":       {"rg", "grep"},
+	"find":       {"fd", "find"},
+	"download":   {"curl", "wget"},
+	"http":       {"curl", "wget"},
+	"api":        {"curl"},
+	"git":        {"git", "gh"},
+	"github":     {"gh"},
+	"pr":         {"gh"},
+	"python":     {"python3", "pip3"},
+	"pip":        {"pip3", "uv"},
+	"node":       {"node", "npm"},
+	"npm":        {"npm", "pnpm", "yarn"},
+	"javascript": {"node", "npm"},
+	"golang":     {"go"},
+	"rust":       {"cargo", "rustc"},
+	"cargo":      {"cargo"},
+	"archive":    {"tar", "zip", "unzip", "7z"},
+	"compress":   {"tar", "gzip", "zstd", "xz"},
+	"zip":        {"zip", "unzip"},
+	"extract":    {"tar", "unzip", "7z"},
+	"image":      {"magick", "convert"},
+	"resize":     {"magick", "convert"},
+	"video":      {"ffmpeg"},
+	"audio":      {"ffmpeg"},
+	"convert":    {"ffmpeg", "magick", "pandoc"},
+	"disk":       {"du", "df", "ncdu"},
+	"memory":     {"free", "htop"},
+	"process":    {"ps", "htop", "pgrep"},
+	"port":       {"ss", "lsof", "netstat"},
+	"network":    {"ip", "ss", "ping"},
+	"ssh":        {"ssh", "scp", "rsync"},
+	"sync":       {"rsync"},
+	"service":    {"systemctl", "launchctl"},
+	"cron":       {"crontab"},
+	"pdf":        {"pdftotext", "qpdf"},
+	"markdown":   {"pandoc"},
+	"aws":        {"aws"},
+	"gcp":        {"gcloud"},
+	"azure":      {"az"},
+	"terraform":  {"terraform"},
+	"database":   {"psql", "mysql", "sqlite3"},
+	"postgres":   {"psql"},
+	"mysql":      {"mysql"},
+	"sqlite":     {"sqlite3"},
+}
+
+// packageManagers are listed when installed, since models tend to assume
+// one distribution's
+var packageManagers = []string{"apt", "dnf", "yum", "pacman", "zypper", "apk", "brew", "port", "nix", "winget", "choco", "scoop"}
+
+// versionArgs are the arguments that print a tool's version
+var versionArgs = map[string][]string{
+	"go":      {"version"},
+	"node":    {"--version"},
+	"npm":     {"--version"},
+	"pnpm":    {"--version"},
+	"yarn":    {"--version"},
+	"bun":     {"--version"},
+	"python3": {"--version"},
+	"poetry":  {"--version"},
+	"uv":      {"--version"},
+	"cargo":   {"--version"},
+	"rustc":   {"--version"},
+	"ruby":    {"--version"},
+	"php":     {"--version"},
+	"java":    {"-version"},
+	"mvn":     {"--version"},
+	"gradle":  {"--version"},
+	"cmake":   {"--version"},
+	"make":    {"--version"},
+	"docker":  {"--version"},
+	"kubectl": {"version", "--client"},
+	"helm":    {"version", "--short"},
+}
+
+// maxVersions limits how many tools are queried for their version
+const maxVersions = 6
+
+// wordPattern splits a request into words that may name commands
+var wordPattern = regexp.MustCompile(`[A-Za-z0-9][A-Za-z0-9_.+-]*`)
+
+// toolsSection lists tools relevant to the request that are installed or
+// missing, plus the installed package managers
+func toolsSection(request string) (section, bool) {
+	var wanted, mentioned []string
+	for _, word := range wordPattern.FindAllString(strings.ToLower(request), -1) {
+		if tools, ok := toolKeywords[word]; ok {
+			wanted = appendMissing(wanted, tools...)
+		} else if len(word) > 1 && len(mentioned) < 20 {
+			mentioned = appendMissing(mentioned, word)
+		}
+	}
+
+	var installed, missing []string
+	for _, tool := range wanted {
+		if _, err := exec.LookPath(tool); err == nil {
+			installed = append(installed, tool)
+		} else {
+			missing = append(missing, tool)
+		}
+	}
+	// Words that are not keywords only count when they are commands
+	for _, word := range mentioned {
+		if _, err := exec.LookPath(word); err == nil {
+			installed = appendMissing(installed, word)
+		}
+	}
+
+	var managers []string
+	for _, manager := range packageManagers {
+		if _, err := exec.LookPath(manager); err == nil {
+			managers = append(managers, manager)
+		}
+	}
+
+	var lines []string
+	if len(installed) > 0 {
+		lines = append(lines, "Installed: "+strings.Join(installed, ", "))
+	}
+	if len(missing) > 0 {
+		lines = append(lines, "Not installed: "+strings.Join(missing, ", "))
+	}
+	if len(managers) > 0 {
+		lines = append(lines, "Package managers: "+strings.Join(managers, ", "))
+	}
+	return section{title: "Tools", lines: lines}, len(lines) > 0
+}
+
+// versionsSection reports the versions of the project's toolchain and of
+// tools mentioned in the request
+func (c *Collector) versionsSection(ctx context.Context, project *projectInfo, request string) (section, bool) {
+	var tools []string
+	if project != nil {
+		tools = append(tools, project.toolchains...)
+	}
+	for _, word := range wordPattern.FindAllString(strings.ToLower(request), -1) {
+		tools = appendMissing(tools, toolKeywords[word]...)
+	}
+
+	var queried []string
+	for _, tool := range tools {
+		if _, ok := versionArgs[tool]; !ok {
+			continue
+		}
+		if _, err := exec.LookPath(tool); err != nil {
+			continue
+		}
+		queried = append(queried, tool)
+		if len(queried) == maxVersions {
+			break
+		}
+	}
+
+	versions := c.queryVersions(ctx, queried)
+	var lines []string
+	for _, tool := range queried {
+		if version := versions[tool]; version != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", tool, version))
+		}
+	}
+	return section{title: "Tool versions", lines: lines}, len(lines) > 0
+}
+
+// queryVersions runs the version commands of tools not queried before,
+// in parallel
+func (c *Collector) queryVersions(ctx context.Context, tools []string) map[string]string {
+	c.versionsMu.Lock()
+	defer c.versionsMu.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, tool := range tools {
+		if _, ok := c.versions[tool]; ok {
+			continue
+		}
+		wg.Add(1)
+		go func(tool string) {
+			defer wg.Done()
+			version := toolVersion(ctx, tool)
+			mu.Lock()
+			c.versions[tool] = version
+			mu.Unlock()
+		}(tool)
+	}
+	wg.Wait()
+
+	result := make(map[string]string, len(tools))
+	for _, tool := range tools {
+		result[tool] = c.versions[tool]
+	}
+	return result
+}
+
+// toolVersion returns the first line a tool prints about its version
+func toolVersion(ctx context.Context, tool string) string {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	// Some tools, like java, print their version on stderr
+	output, err := exec.CommandContext(ctx, tool, versionArgs[tool]...).CombinedOutput()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return strings.TrimSpace(line)
+}
+
+// gitSection summarizes the branch and working tree of the repository dir
+// is in
+func gitSection(ctx context.Context, dir string) (section, bool) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return section{}, false
+	}
+	output, err := run(ctx, dir, "git", "status", "--porcelain=v1", "--branch")
+	if err != nil {
+		return section{}, false
+	}
+
+	lines := strings.Split(output, "\n")
+	branch := strings.TrimPrefix(lines[0], "## ")
+	if name, upstream, ok := strings.Cut(branch, "..."); ok {
+		branch = fmt.Sprintf("%s (tracking %s)", name, upstream)
+	}
+
+	var staged, modified, untracked, conflicts int
+	for _, line := range lines[1:] {
+		if len(line) < 2 {
+			continue
+		}
+		x, y := line[0], line[1]
+		switch {
+		case x == '?' && y == '?':
+			untracked++
+		case x == 'U' || y == 'U' || (x == 'A' && y == 'A') || (x == 'D' && y == 'D'):
+			conflicts++
+		default:
+			if x != ' ' {
+				staged++
+			}
+			if y != ' ' {
+				modified++
+			}
+		}
+	}
+
+	var changes []string
+	for _, count := range []struct {
+		n     int
+		label string
+	}{
+		{staged, "staged"},
+		{modified, "modified"},
+		{untracked, "untracked"},
+		{conflicts, "conflicted"},
+	} {
+		if count.n > 0 {
+			changes = append(changes, fmt.Sprintf("%d %s", count.n, count.label))
+		}
+	}
+	status := "clean"
+	if len(changes) > 0 {
+		status = strings.Join(changes, ", ")
+	}
+
+	return section{title: "Git", lines: []string{"Branch: " + branch, "Working tree: " + status}}, true
+}
+
+// directorySection lists the entries of dir, directories marked with a
+// trailing slash
+func directorySection(dir string) (section, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) == 0 {
+		return section{}, false
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	title := fmt.Sprintf("Directory listing (%d entries)", len(names))
+	if len(names) > maxListedEntries {
+		names = append(names[:maxListedEntries], fmt.Sprintf("... and %d more", len(names)-maxListedEntries))
+	}
+
+	// Several names per line keep the listing compact
+	var lines []string
+	for i := 0; i < len(names); i += 8 {
+		lines = append(lines, strings.Join(names[i:min(i+8, len(names))], "  "))
+	}
+	return section{title: title, lines: lines}, true
+}
@@ -21,11 +21,13 @@
 package libterma
 
 import (
-	"encoding/json"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 
+	"craftcom/pkg/secrets"
 	"craftcom/pkg/types"
 )
 
@@ -41,6 +43,7 @@ type Config struct {
 	// Terminal settings
 	Shell        string            `json:"shell"`
 	HistorySize  int               `json:"history_size"`
+	HistoryFile  string            `json:"history_file"`
 	WorkingDir   string            `json:"working_dir"`
 	Environment  map[string]string `json:"environment"`
 	SystemPrompt string            `json:"system_prompt"`
@@ -54,19 +57,48 @@ type Config struct {
 	MaxFileSize      int64    `json:"max_file_size"`
 	AllowedFileTypes []string `json:"allowed_file_types"`
 
+	// Which files may be attached to requests
+	FileAccess FileAccessConfig `json:"file_access"`
+
 	// Runtime settings
-	Debug       bool `json:"debug"`
-	Quiet       bool `json:"quiet"`
-	ColorOutput bool `json:"color_output"`
+	Debug        bool   `json:"debug"`
+	Quiet        bool   `json:"quiet"`
+	ColorOutput  bool   `json:"color_output"`
+	OutputFormat string `json:"output_format"`
+
+	// Project and environment information sent with each request
+	Context ContextConfig `json:"context"`
+
+	// Secrets and personal data replaced before anything is sent
+	Redaction RedactionConfig `json:"redaction"`
+
+	// Command aliases
+	Aliases map[string]string `json:"aliases"`
+
+	// Named profiles and the one in use
+	Profiles map[string]Profile `json:"profiles"`
+	Profile  string             `json:"profile"`
 
 	// Internal fields
 	configPath string
+	layers     []configLayer
+	userTree   map[string]interface{} // Contents of configPath
+	merged     map[string]interface{} // Merged layers, including unknown keys
+	loaded     map[string]interface{} // Effective settings when loaded
+	origins    map[string]string      // Origin of each effective setting
 	mu         sync.RWMutex
 }
 
-// ProviderConfig contains provider-specific settings
+// ProviderConfig contains provider-specific settings. The API key can be
+// kept out of the config file with one of the APIKey* sources; keys read
+// from them are only passed to the provider and never saved.
 type ProviderConfig struct {
-	APIKey      string            `json:"api_key"`
+	APIKey        string `json:"api_key"`
+	APIKeyEnv     string `json:"api_key_env,omitempty"`
+	APIKeyCmd     string `json:"api_key_cmd,omitempty"`
+	APIKeyFile    string `json:"api_key_file,omitempty"`
+	APIKeyKeyring bool   `json:"api_key_keyring,omitempty"`
+
 	Name        string            `json:"name"`
 	Enabled     bool              `json:"enabled"`
 	Models      []string          `json:"models"`
@@ -76,12 +108,57 @@ type ProviderConfig struct {
 	Settings    map[string]string `json:"settings"`
 }
 
+// KeyringService is the Secret Service "service" attribute API keys are
+// stored under
+const KeyringService = "craftcom"
+
+// ContextConfig selects the project and environment information sent with
+// each request, within a budget of characters. A budget of 0 sends none.
+type ContextConfig struct {
+	Budget    int  `json:"budget"`
+	Project   bool `json:"project"`
+	Git       bool `json:"git"`
+	Tools     bool `json:"tools"`
+	Versions  bool `json:"versions"`
+	Directory bool `json:"directory"`
+}
+
+// FileAccessConfig is the policy for files attached to requests: they
+// must be in one of AllowedRoots, when any are given, and must not match
+// Denied. Confirm lists what will be sent and asks first.
+type FileAccessConfig struct {
+	AllowedRoots []string `json:"allowed_roots"`
+	Denied       []string `json:"denied"`
+	Confirm      bool     `json:"confirm"`
+}
+
+// RedactionConfig controls what is replaced with placeholders in prompts,
+// context and file contents before they are sent. Secrets such as API
+// keys, tokens, private keys and passwords are always redacted while
+// Enabled is set; the other kinds can be turned off.
+type RedactionConfig struct {
+	Enabled     bool     `json:"enabled"`
+	Emails      bool     `json:"emails"`
+	IPAddresses bool     `json:"ip_addresses"`
+	Identity    bool     `json:"identity"`
+	Patterns    []string `json:"patterns"`
+}
+
 const (
-	defaultConfigName  = ".craftcom.json"
-	defaultHistorySize = 1000
-	defaultSafetyLevel = "medium"
+	defaultConfigName    = ".craftcom"
+	defaultHistorySize   = 1000
+	defaultSafetyLevel   = "medium"
+	defaultContextBudget = 2000
 )
 
+// legacyKeys are top-level provider settings written by earlier versions
+// of the CLI, before they moved under "providers"
+var legacyKeys = []string{"api_key", "max_tokens", "temperature"}
+
+// legacyAllowedFileTypes is the allowed_file_types default written by
+// earlier versions, before attachments were recognised by their content
+var legacyAllowedFileTypes = []string{".txt", ".md", ".pdf", ".png", ".jpg", ".jpeg", ".mp3", ".wav", ".mp4"}
+
 // DefaultConfig creates a new configuration with default values
 func DefaultConfig() *Config {
 	homeDir, _ := os.UserHomeDir()
@@ -102,6 +179,7 @@ func DefaultConfig() *Config {
 		DefaultModel:    "gemini-1.5-pro",
 		Shell:           os.Getenv("SHELL"),
 		HistorySize:     defaultHistorySize,
+		HistoryFile:     filepath.Join(homeDir, defaultHistoryName),
 		WorkingDir:      homeDir,
 		Environment:     map[string]string{},
 		SystemPrompt:    defaultSystemPrompt,
@@ -117,67 +195,264 @@ func DefaultConfig() *Config {
 			"/boot",
 			"/root",
 		},
-		SafetyLevel: defaultSafetyLevel,
-		MaxFileSize: 100 * 1024 * 1024, // 100MB
-		AllowedFileTypes: []string{
-			".txt", ".md", ".pdf",
-			".png", ".jpg", ".jpeg",
-			".mp3", ".wav",
-			".mp4",
+		SafetyLevel:      defaultSafetyLevel,
+		MaxFileSize:      100 * 1024 * 1024, // 100MB
+		AllowedFileTypes: append([]string(nil), types.DefaultAllowedFileTypes...),
+		FileAccess: FileAccessConfig{
+			AllowedRoots: []string{},
+			Denied:       append([]string(nil), types.DefaultDeniedFiles...),
+			Confirm:      true,
+		},
+		Context: ContextConfig{
+			Budget:    defaultContextBudget,
+			Project:   true,
+			Git:       true,
+			Tools:     true,
+			Versions:  true,
+			Directory: true,
+		},
+		Redaction: RedactionConfig{
+			Enabled:     true,
+			Emails:      true,
+			IPAddresses: true,
+			Identity:    true,
+			Patterns:    []string{},
 		},
-		ColorOutput: true,
+		ColorOutput:  true,
+		OutputFormat: "markdown",
+		Aliases:      map[string]string{},
+		Profiles:     map[string]Profile{},
 	}
 }
 
+// DefaultConfigPath returns the location of the user's configuration file,
+// preferring an existing JSON, YAML or TOML file
+func DefaultConfigPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", types.ErrConfigurationf("failed to get home directory: %v", err)
+	}
+
+	if path := findConfigFile(homeDir, defaultConfigName); path != "" {
+		return path, nil
+	}
+	return filepath.Join(homeDir, defaultConfigName+".json"), nil
+}
+
 // LoadConfig loads the configuration from the default location
 func LoadConfig() (*Config, error) {
-	homeDir, err := os.UserHomeDir()
+	configPath, err := DefaultConfigPath()
 	if err != nil {
-		return nil, types.ErrConfigurationf("failed to get home directory: %v", err)
+		return nil, err
 	}
 
-	configPath := filepath.Join(homeDir, defaultConfigName)
 	return LoadConfigFromPath(configPath)
 }
 
-// LoadConfigFromPath loads the configuration from a specific path
+// LoadConfigFromPath loads the configuration from a specific path, merged
+// with the system, user directory, project and environment layers
 func LoadConfigFromPath(path string) (*Config, error) {
 	config := DefaultConfig()
 	config.configPath = path
 
-	data, err := os.ReadFile(path)
+	defaults, err := toTree(config)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Create default config if it doesn't exist
-			return config, config.Save()
-		}
-		return nil, types.ErrConfigurationf("failed to read config: %v", err)
+		return nil, err
+	}
+	files, userTree, create, migrated, err := readFileLayers(path, defaults)
+	if err != nil {
+		return nil, err
 	}
+	config.userTree = userTree
+	config.layers = append([]configLayer{{origin: OriginDefault, tree: defaults}}, files...)
 
-	if err := json.Unmarshal(data, config); err != nil {
-		return nil, types.ErrConfigurationf("failed to parse config: %v", err)
+	env, err := envLayers()
+	if err != nil {
+		return nil, err
+	}
+	config.layers = append(config.layers, env...)
+
+	if err := config.rebuild(); err != nil {
+		return nil, err
+	}
+
+	// Reject typos and invalid values instead of silently using defaults
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	if create || migrated {
+		if err := config.writeUserTree(); err != nil {
+			return nil, err
+		}
 	}
 
 	return config, nil
 }
 
+// migrateLegacy updates settings written by earlier versions. It reports
+// whether anything changed so the file can be rewritten in the current
+// schema.
+func migrateLegacy(tree map[string]interface{}) bool {
+	moved := migrateProviderKeys(tree)
+	replaced := migrateAllowedFileTypes(tree)
+	return moved || replaced
+}
+
+// migrateAllowedFileTypes replaces the old default list of extensions, which
+// every saved config holds, with the current default of file types
+func migrateAllowedFileTypes(tree map[string]interface{}) bool {
+	list, ok := tree["allowed_file_types"].([]interface{})
+	if !ok || len(list) != len(legacyAllowedFileTypes) {
+		return false
+	}
+	for _, value := range list {
+		ext, _ := value.(string)
+		if !containsString(legacyAllowedFileTypes, strings.ToLower(ext)) {
+			return false
+		}
+	}
+
+	allowed := make([]interface{}, 0, len(types.DefaultAllowedFileTypes))
+	for _, fileType := range types.DefaultAllowedFileTypes {
+		allowed = append(allowed, fileType)
+	}
+	tree["allowed_file_types"] = allowed
+	return true
+}
+
+// migrateProviderKeys moves top-level provider settings from the old CLI
+// schema into the default provider's section, reporting whether any moved
+func migrateProviderKeys(tree map[string]interface{}) bool {
+	moved := make(map[string]interface{})
+	for _, key := range legacyKeys {
+		if value, ok := tree[key]; ok {
+			moved[key] = value
+			delete(tree, key)
+		}
+	}
+	if len(moved) == 0 {
+		return false
+	}
+
+	name, _ := tree["default_provider"].(string)
+	if name == "" {
+		name = "gemini"
+	}
+
+	providers, ok := tree["providers"].(map[string]interface{})
+	if !ok {
+		providers = make(map[string]interface{})
+		tree["providers"] = providers
+	}
+	provider, ok := providers[name].(map[string]interface{})
+	if !ok {
+		provider = make(map[string]interface{})
+		providers[name] = provider
+	}
+
+	// Settings already under "providers" take precedence
+	for key, value := range moved {
+		if existing, ok := provider[key]; !ok || existing == nil || existing == "" || existing == float64(0) {
+			provider[key] = value
+		}
+	}
+
+	return true
+}
+
+// Path returns the file the configuration is saved to
+func (c *Config) Path() string {
+	return c.configPath
+}
+
 // Save writes the configuration to disk
 func (c *Config) Save() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	data, err := json.MarshalIndent(c, "", "  ")
+	return c.save()
+}
+
+// save writes changes made since loading to the config file, leaving
+// values from other layers out; the caller must hold c.mu
+func (c *Config) save() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	current, err := toTree(c)
 	if err != nil {
-		return types.ErrConfigurationf("failed to marshal config: %v", err)
+		return err
+	}
+
+	if c.userTree == nil {
+		c.userTree = make(map[string]interface{})
+	}
+	mergeTree(c.userTree, diffTree(c.loaded, current), "", c.configPath, nil, nil)
+	c.loaded = current
+
+	return c.writeUserTree()
+}
+
+// writeUserTree writes the config file layer to disk
+func (c *Config) writeUserTree() error {
+	data, err := encodeConfig(c.configPath, c.userTree)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.configPath), 0755); err != nil {
+		return types.ErrConfigurationf("failed to create config directory: %v", err)
 	}
 
 	if err := os.WriteFile(c.configPath, data, 0600); err != nil {
 		return types.ErrConfigurationf("failed to write config: %v", err)
 	}
 
+	// WriteFile keeps the mode of an existing file, which may be readable
+	// by others when it was created by an older version
+	if err := os.Chmod(c.configPath, 0600); err != nil {
+		return types.ErrConfigurationf("failed to set config permissions: %v", err)
+	}
+
 	return nil
 }
 
+// Reset replaces the config file with the defaults, keeping API keys and
+// where they are read from
+func (c *Config) Reset() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	defaults, err := toTree(DefaultConfig())
+	if err != nil {
+		return err
+	}
+
+	providers, _ := c.userTree["providers"].(map[string]interface{})
+	for name, value := range providers {
+		provider, _ := value.(map[string]interface{})
+		for key, setting := range provider {
+			if key == "api_key" || strings.HasPrefix(key, "api_key_") {
+				mergeTree(defaults, setPath("providers."+name+"."+key, setting), "", "", nil, nil)
+			}
+		}
+	}
+
+	for i := range c.layers {
+		if c.layers[i].origin == c.configPath {
+			c.layers[i] = configLayer{origin: c.configPath, path: c.configPath, tree: defaults}
+		}
+	}
+	c.userTree = defaults
+
+	if err := c.rebuild(); err != nil {
+		return err
+	}
+	return c.writeUserTree()
+}
+
 // GetProviderConfig returns configuration for a specific provider
 func (c *Config) GetProviderConfig(name string) (ProviderConfig, error) {
 	c.mu.RLock()
@@ -191,13 +466,82 @@ func (c *Config) GetProviderConfig(name string) (ProviderConfig, error) {
 	return config, nil
 }
 
+// DefaultProviderName returns the provider used unless another is chosen
+func (c *Config) DefaultProviderName() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.DefaultProvider
+}
+
+// ConfirmAttachments reports whether attached files are listed and
+// confirmed before they are sent
+func (c *Config) ConfirmAttachments() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.FileAccess.Confirm
+}
+
+// DebugEnabled reports whether debug output is on
+func (c *Config) DebugEnabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Debug
+}
+
+// historySize returns how many commands the history keeps
+func (c *Config) historySize() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.HistorySize
+}
+
 // UpdateProviderConfig updates configuration for a specific provider
 func (c *Config) UpdateProviderConfig(name string, config ProviderConfig) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	c.Providers[name] = config
-	return c.Save()
+	return c.save()
+}
+
+// GenerationTemperature returns the sampling temperature, preferring the
+// temperature field over the "temperature" setting. Zero means the model
+// default is used.
+func (p ProviderConfig) GenerationTemperature() float32 {
+	return p.floatSetting(p.Temperature, "temperature")
+}
+
+// GenerationTopP returns the nucleus sampling value, preferring the top_p
+// field over the "top_p" setting. Zero means the model default is used.
+func (p ProviderConfig) GenerationTopP() float32 {
+	return p.floatSetting(p.TopP, "top_p")
+}
+
+// SecretSource returns where the API key of the named provider is read
+// from when api_key is empty. Secret Service items are looked up by the
+// attributes service=craftcom and account=<provider>.
+func (p ProviderConfig) SecretSource(name string) secrets.Source {
+	return secrets.Source{
+		Env:     p.APIKeyEnv,
+		Command: p.APIKeyCmd,
+		File:    p.APIKeyFile,
+		Keyring: p.APIKeyKeyring,
+		Service: KeyringService,
+		Account: name,
+	}
+}
+
+// floatSetting returns value when set, otherwise the named setting
+func (p ProviderConfig) floatSetting(value float32, key string) float32 {
+	if value != 0 {
+		return value
+	}
+
+	parsed, err := strconv.ParseFloat(p.Settings[key], 32)
+	if err != nil {
+		return 0
+	}
+	return float32(parsed)
 }
 
 // ValidateCommand checks if a command is allowed
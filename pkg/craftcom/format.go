@@ -0,0 +1,296 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"craftcom/pkg/types"
+)
+
+// Config file formats, chosen by file extension
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// configExtensions are tried in order when looking for a config file
+var configExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// configFormat returns the format of a config file from its extension
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	default:
+		return formatJSON
+	}
+}
+
+// findConfigFile returns the first existing file named base with one of
+// the config extensions, or "" when there is none
+func findConfigFile(dir, base string) string {
+	for _, ext := range configExtensions {
+		candidate := filepath.Join(dir, base+ext)
+		if stat, err := os.Stat(candidate); err == nil && !stat.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// decodeConfig parses a config file into a generic tree and the line each
+// key is defined on. Values are normalized to what encoding/json produces.
+func decodeConfig(path string, data []byte) (map[string]interface{}, map[string]int, error) {
+	var raw interface{}
+	var lines map[string]int
+
+	switch configFormat(path) {
+	case formatYAML:
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, nil, types.ErrConfigurationf("failed to parse %s: %v", path, err)
+		}
+		if err := node.Decode(&raw); err != nil && len(node.Content) > 0 {
+			return nil, nil, types.ErrConfigurationf("failed to parse %s: %v", path, err)
+		}
+		lines = yamlKeyLines(&node)
+	case formatTOML:
+		var tree map[string]interface{}
+		if _, err := toml.Decode(string(data), &tree); err != nil {
+			var parseErr toml.ParseError
+			if errors.As(err, &parseErr) {
+				// Drop the "toml: line N" prefix, the position is reported first
+				line := parseErr.Position.Line
+				message := strings.TrimPrefix(parseErr.Error(), fmt.Sprintf("toml: line %d", line))
+				if i := strings.Index(message, ": "); i >= 0 {
+					message = message[i+2:]
+				}
+				return nil, nil, types.ErrConfigurationf("%s:%d: %s", path, line, message)
+			}
+			return nil, nil, types.ErrConfigurationf("failed to parse %s: %v", path, err)
+		}
+		raw = tree
+		lines = tomlKeyLines(data)
+	default:
+		if err := json.Unmarshal(data, &raw); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return nil, nil, types.ErrConfigurationf("%s:%d: %v", path, lineAt(data, syntaxErr.Offset), err)
+			}
+			return nil, nil, types.ErrConfigurationf("failed to parse %s: %v", path, err)
+		}
+		lines = jsonKeyLines(data)
+	}
+
+	if raw == nil {
+		return make(map[string]interface{}), lines, nil
+	}
+
+	normalized, err := normalizeValue(raw)
+	if err != nil {
+		return nil, nil, types.ErrConfigurationf("failed to parse %s: %v", path, err)
+	}
+	tree, ok := normalized.(map[string]interface{})
+	if !ok {
+		return nil, nil, types.ErrConfigurationf("%s:1: configuration must be a mapping of settings", path)
+	}
+	return tree, lines, nil
+}
+
+// encodeConfig writes a tree in the format of the given path
+func encodeConfig(path string, tree map[string]interface{}) ([]byte, error) {
+	switch configFormat(path) {
+	case formatYAML:
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(tree); err != nil {
+			return nil, types.ErrConfigurationf("failed to marshal config: %v", err)
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, types.ErrConfigurationf("failed to marshal config: %v", err)
+		}
+		return buf.Bytes(), nil
+	case formatTOML:
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(tomlValue(tree)); err != nil {
+			return nil, types.ErrConfigurationf("failed to marshal config: %v", err)
+		}
+		return buf.Bytes(), nil
+	default:
+		data, err := json.MarshalIndent(tree, "", "  ")
+		if err != nil {
+			return nil, types.ErrConfigurationf("failed to marshal config: %v", err)
+		}
+		return data, nil
+	}
+}
+
+// tomlValue converts whole numbers back to integers, which TOML keeps
+// distinct from floats
+func tomlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[key] = tomlValue(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = tomlValue(item)
+		}
+		return converted
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// lineAt returns the 1-based line of a byte offset
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// jsonKeyLines maps dotted keys to the line they are defined on
+func jsonKeyLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	var walk func(prefix string) error
+	walk = func(prefix string) error {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case json.Delim('{'):
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := keyToken.(string)
+				path := joinPath(prefix, key)
+				lines[path] = lineAt(data, decoder.InputOffset())
+				if err := walk(path); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		case json.Delim('['):
+			for decoder.More() {
+				if err := walk(prefix); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		}
+		return err
+	}
+
+	// Errors were already reported when parsing; keep what was found
+	_ = walk("")
+	return lines
+}
+
+// yamlKeyLines maps dotted keys to the line they are defined on
+func yamlKeyLines(node *yaml.Node) map[string]int {
+	lines := make(map[string]int)
+
+	var walk func(node *yaml.Node, prefix string)
+	walk = func(node *yaml.Node, prefix string) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(child, prefix)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				path := joinPath(prefix, node.Content[i].Value)
+				lines[path] = node.Content[i].Line
+				walk(node.Content[i+1], path)
+			}
+		}
+	}
+
+	walk(node, "")
+	return lines
+}
+
+// tomlKeyLines maps dotted keys to the line they are defined on. It only
+// understands tables and key/value lines, which is all configs use.
+func tomlKeyLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	table := ""
+
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			continue
+		case strings.HasPrefix(trimmed, "["):
+			header, _, _ := strings.Cut(trimmed, "]")
+			table = tomlKey(strings.TrimLeft(header, "["))
+			lines[table] = i + 1
+		default:
+			key, _, ok := strings.Cut(trimmed, "=")
+			if !ok {
+				continue
+			}
+			lines[joinPath(table, tomlKey(key))] = i + 1
+		}
+	}
+
+	return lines
+}
+
+// tomlKey turns a possibly quoted, dotted TOML key into a dotted path
+func tomlKey(key string) string {
+	parts := strings.Split(strings.TrimSpace(key), ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"craftcom/pkg/types"
+)
+
+const defaultHistoryName = ".craftcom_history"
+
+// HistoryStore persists command history as JSON lines so that entries
+// written by separate processes (e.g. shell hooks) can be appended cheaply
+type HistoryStore struct {
+	path    string
+	maxSize int
+	mu      sync.Mutex
+}
+
+// NewHistoryStore creates a history store backed by the given file
+func NewHistoryStore(path string, maxSize int) *HistoryStore {
+	if maxSize <= 0 {
+		maxSize = defaultHistorySize
+	}
+	return &HistoryStore{
+		path:    path,
+		maxSize: maxSize,
+	}
+}
+
+// Path returns the location of the history file
+func (s *HistoryStore) Path() string {
+	return s.path
+}
+
+// Load reads the most recent entries from disk, compacting the file if it
+// has grown past the configured size
+func (s *HistoryStore) Load() ([]types.CommandHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read()
+	if err != nil || len(entries) <= s.maxSize {
+		return entries, err
+	}
+
+	// Shell hooks append from other processes, so compact under the file
+	// lock with what is in the file by then
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, err = s.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > s.maxSize {
+		entries = entries[len(entries)-s.maxSize:]
+		if err := s.rewrite(entries); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+// read decodes all entries in the history file
+func (s *HistoryStore) read() ([]types.CommandHistory, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make([]types.CommandHistory, 0), nil
+		}
+		return nil, types.ErrConfigurationf("failed to open history: %v", err)
+	}
+	defer file.Close()
+
+	entries := make([]types.CommandHistory, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		var entry types.CommandHistory
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Skip lines that were partially written or corrupted
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, types.ErrConfigurationf("failed to read history: %v", err)
+	}
+	return entries, nil
+}
+
+// Append adds entries to the end of the history file
+func (s *HistoryStore) Append(entries ...types.CommandHistory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return types.ErrConfigurationf("failed to create history directory: %v", err)
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return types.ErrConfigurationf("failed to open history: %v", err)
+	}
+	defer file.Close()
+
+	return writeHistoryEntries(file, entries)
+}
+
+// Clear removes all persisted history
+func (s *HistoryStore) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return types.ErrConfigurationf("failed to clear history: %v", err)
+	}
+	return nil
+}
+
+// lockPath returns the file locked while the history file is written. The
+// history file itself is replaced when compacted, so it cannot be locked.
+func (s *HistoryStore) lockPath() string {
+	return s.path + ".lock"
+}
+
+// lock takes an advisory lock shared by all processes writing the history
+// file, and returns the function releasing it
+func (s *HistoryStore) lock() (func(), error) {
+	file, err := os.OpenFile(s.lockPath(), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, types.ErrConfigurationf("failed to lock history: %v", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, types.ErrConfigurationf("failed to lock history: %v", err)
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
+
+// rewrite replaces the history file atomically with the given entries
+func (s *HistoryStore) rewrite(entries []types.CommandHistory) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".craftcom_history_*")
+	if err != nil {
+		return types.ErrConfigurationf("failed to compact history: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeHistoryEntries(tmp, entries); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return types.ErrConfigurationf("failed to compact history: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return types.ErrConfigurationf("failed to compact history: %v", err)
+	}
+	return nil
+}
+
+// writeHistoryEntries encodes entries one per line
+func writeHistoryEntries(file *os.File, entries []types.CommandHistory) error {
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return types.ErrConfigurationf("failed to encode history: %v", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return types.ErrConfigurationf("failed to write history: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"craftcom/pkg/types"
+)
+
+func TestHistoryCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	store := NewHistoryStore(path, 3)
+
+	for i := 0; i < 5; i++ {
+		if err := store.Append(types.CommandHistory{Command: fmt.Sprintf("cmd-%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Command != "cmd-2" || entries[2].Command != "cmd-4" {
+		t.Fatalf("Load() = %v, want cmd-2 to cmd-4", entries)
+	}
+
+	// The file was compacted, so another store sees the same entries
+	entries, err = NewHistoryStore(path, 100).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("compacted file has %d entries, want 3", len(entries))
+	}
+}
+
+// TestHistoryAppendDuringCompaction appends through separate stores, as
+// shell hooks do from other processes, while the file is compacted.
+// Compaction drops the oldest entries only, so the appended entries left
+// must run without a gap up to the last one.
+func TestHistoryAppendDuringCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	const size = 500
+
+	var appended atomic.Int64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			entry := types.CommandHistory{Command: fmt.Sprint(appended.Load())}
+			if err := NewHistoryStore(path, size).Append(entry); err != nil {
+				t.Error(err)
+				return
+			}
+			appended.Add(1)
+		}
+	}()
+	for appended.Load() < 5000 {
+		if _, err := NewHistoryStore(path, size).Load(); err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	total := int(appended.Load())
+	entries, err := NewHistoryStore(path, total+1).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		t.Fatal("history is empty")
+	}
+	first, _ := strconv.Atoi(entries[0].Command)
+	for i, entry := range entries {
+		if want := strconv.Itoa(first + i); entry.Command != want {
+			t.Fatalf("entry %s was lost during compaction", want)
+		}
+	}
+	if last := entries[len(entries)-1].Command; last != strconv.Itoa(total-1) {
+		t.Errorf("last entry is %s, want %d", last, total-1)
+	}
+}
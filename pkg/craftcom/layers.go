@@ -0,0 +1,702 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"craftcom/pkg/types"
+)
+
+// Configuration is merged from these layers, later ones taking precedence:
+//
+//  1. built-in defaults
+//  2. the system config, /etc/craftcom/config.json
+//  3. the user config directory, ~/.config/craftcom/config.json
+//  4. the config file being loaded, ~/.craftcom.json by default
+//  5. the selected profile, see profile.go
+//  6. the nearest project .craftcom.json found walking up from the cwd
+//  7. CRAFTCOM_* environment variables
+//  8. overrides for a single run, such as command line flags
+//
+// Each file may also be YAML (.yaml, .yml) or TOML (.toml) instead.
+//
+// Objects are merged key by key; lists and scalar values are replaced.
+// Save only ever writes the file from layer 4.
+
+const (
+	// OriginDefault marks values that come from the built-in defaults
+	OriginDefault = "default"
+
+	envPrefix           = "CRAFTCOM_"
+	projectConfigName   = ".craftcom"
+	layeredConfigName   = "config"
+	layeredConfigDir    = "craftcom"
+	systemConfigDirUnix = "/etc/craftcom"
+)
+
+// projectDeniedKeys may not be set by project files, which are usually
+// checked into repositories and should not redirect credentials or history
+var projectDeniedKeys = []string{
+	"providers",
+	"profiles",
+	"history_file",
+	"shell",
+	"environment",
+	"working_dir",
+}
+
+// projectAdditiveKeys are lists that project files extend rather than
+// replace, so a repository can add protections but not remove them
+var projectAdditiveKeys = []string{
+	"protected_paths",
+	"disallowed_commands",
+	"redaction.patterns",
+	"file_access.denied",
+}
+
+// projectGuardedKeys hold protections project files may only tighten:
+// their lists are additive, their switches may only be turned on and
+// anything else under them is denied
+var projectGuardedKeys = []string{
+	"redaction",
+	"file_access",
+}
+
+// projectTightenOnlyKeys are switches project files may only turn on
+var projectTightenOnlyKeys = []string{
+	"redaction.enabled",
+	"redaction.emails",
+	"redaction.ip_addresses",
+	"redaction.identity",
+	"file_access.confirm",
+}
+
+// configLayer is one source of configuration values
+type configLayer struct {
+	origin   string
+	path     string // File the layer was read from, if any
+	tree     map[string]interface{}
+	lines    map[string]int // Line each key is set on, for files
+	additive []string
+	// Set for layers that take precedence over the selected profile
+	aboveProfile bool
+}
+
+// ConfigValue is a single effective setting and where it came from
+type ConfigValue struct {
+	Key    string      `json:"key" yaml:"key"`
+	Value  interface{} `json:"value" yaml:"value"`
+	Origin string      `json:"origin" yaml:"origin"`
+}
+
+// deletedValue marks a key removed since the configuration was loaded
+type deletedValue struct{}
+
+// systemConfigPath returns the machine-wide configuration file, if any
+func systemConfigPath() string {
+	if runtime.GOOS == "windows" {
+		programData := os.Getenv("ProgramData")
+		if programData == "" {
+			return ""
+		}
+		return findConfigFile(filepath.Join(programData, layeredConfigDir), layeredConfigName)
+	}
+	return findConfigFile(systemConfigDirUnix, layeredConfigName)
+}
+
+// userConfigPath returns the configuration file in the user config
+// directory, if any
+func userConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return findConfigFile(filepath.Join(dir, layeredConfigDir), layeredConfigName)
+}
+
+// findProjectConfig walks up from dir looking for a project config file,
+// ignoring skip, which is the file already loaded as the user config. The
+// search stops below the home directory, where the user config lives.
+func findProjectConfig(dir, skip string) string {
+	homeDir, _ := os.UserHomeDir()
+	for {
+		if homeDir != "" && samePath(dir, homeDir) {
+			return ""
+		}
+
+		candidate := findConfigFile(dir, projectConfigName)
+		if candidate != "" && !samePath(candidate, skip) {
+			return candidate
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// samePath reports whether two paths refer to the same file
+func samePath(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	if absA == absB {
+		return true
+	}
+
+	statA, errA := os.Stat(absA)
+	statB, errB := os.Stat(absB)
+	return errA == nil && errB == nil && os.SameFile(statA, statB)
+}
+
+// readFileLayers reads the system, user directory, user and project config
+// files for a config file at path. A missing file at path starts from the
+// defaults and is reported as created; legacy settings in it are migrated.
+func readFileLayers(path string, defaults map[string]interface{}) (layers []configLayer, userTree map[string]interface{}, created, migrated bool, err error) {
+	for _, layerPath := range []string{systemConfigPath(), userConfigPath()} {
+		if layerPath == "" || samePath(layerPath, path) {
+			continue
+		}
+		layer, err := readConfigLayer(layerPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, false, false, types.ErrConfigurationf("failed to read config: %v", err)
+		}
+		layers = append(layers, layer)
+	}
+
+	userLayer, err := readConfigLayer(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, nil, false, false, types.ErrConfigurationf("failed to read config: %v", err)
+		}
+		userLayer = configLayer{origin: path, path: path, tree: copyTree(defaults)}
+		created = true
+	}
+	migrated = migrateLegacy(userLayer.tree)
+	layers = append(layers, userLayer)
+
+	if wd, err := os.Getwd(); err == nil {
+		if projectPath := findProjectConfig(wd, path); projectPath != "" {
+			layer, err := projectLayer(projectPath)
+			if err != nil {
+				return nil, nil, false, false, err
+			}
+			layers = append(layers, layer)
+		}
+	}
+
+	return layers, userLayer.tree, created, migrated, nil
+}
+
+// readConfigLayer reads a configuration file as a layer
+func readConfigLayer(path string) (configLayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return configLayer{}, err
+	}
+
+	tree, lines, err := decodeConfig(path, data)
+	if err != nil {
+		return configLayer{}, err
+	}
+	return configLayer{origin: path, path: path, tree: tree, lines: lines}, nil
+}
+
+// projectLayer reads a project config, rejecting keys projects may not set
+// and resolving relative protected paths against the project directory
+func projectLayer(path string) (configLayer, error) {
+	layer, err := readConfigLayer(path)
+	if err != nil {
+		return configLayer{}, types.ErrConfigurationf("failed to read project config: %v", err)
+	}
+
+	if err := checkProjectKeys(path, layer); err != nil {
+		return configLayer{}, err
+	}
+
+	if paths, ok := layer.tree["protected_paths"].([]interface{}); ok {
+		dir := filepath.Dir(path)
+		for i, value := range paths {
+			if p, ok := value.(string); ok && p != "" && !filepath.IsAbs(p) {
+				paths[i] = filepath.Join(dir, p)
+			}
+		}
+	}
+
+	layer.additive = projectAdditiveKeys
+	layer.aboveProfile = true
+	return layer, nil
+}
+
+// checkProjectKeys rejects settings a project config may not change
+func checkProjectKeys(path string, layer configLayer) error {
+	values := make(map[string]interface{})
+	flattenTree(layer.tree, "", values)
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		top, _, _ := strings.Cut(key, ".")
+		line := layer.lines[key]
+		if line == 0 {
+			line = layer.lines[top]
+		}
+
+		switch {
+		case containsString(projectDeniedKeys, top):
+			return types.ErrConfigurationf("%s:%d: %s: cannot be set by a project config", path, line, top)
+		case !containsString(projectGuardedKeys, top), containsString(projectAdditiveKeys, key):
+			continue
+		case containsString(projectTightenOnlyKeys, key):
+			if on, _ := values[key].(bool); !on {
+				return types.ErrConfigurationf("%s:%d: %s: can only be turned on by a project config", path, line, key)
+			}
+		default:
+			return types.ErrConfigurationf("%s:%d: %s: cannot be set by a project config", path, line, key)
+		}
+	}
+	return nil
+}
+
+// envLayers returns a layer for each CRAFTCOM_* variable naming a top-level
+// setting, e.g. CRAFTCOM_DEFAULT_MODEL. Lists are comma separated.
+func envLayers() ([]configLayer, error) {
+	var layers []configLayer
+
+	configType := reflect.TypeOf((*Config)(nil)).Elem()
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		name := envPrefix + strings.ToUpper(key)
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		value, supported, err := parseEnvValue(field.Type, raw)
+		if err != nil {
+			return nil, types.ErrConfigurationf("invalid value for %s: %v", name, err)
+		}
+		if !supported {
+			continue
+		}
+
+		layers = append(layers, configLayer{
+			origin:       "env " + name,
+			tree:         map[string]interface{}{key: value},
+			aboveProfile: true,
+		})
+	}
+
+	return layers, nil
+}
+
+// parseEnvValue converts an environment variable to a tree value for a
+// field of the given type
+func parseEnvValue(fieldType reflect.Type, raw string) (interface{}, bool, error) {
+	switch fieldType.Kind() {
+	case reflect.String:
+		return raw, true, nil
+	case reflect.Bool:
+		value, err := strconv.ParseBool(raw)
+		return value, true, err
+	case reflect.Int, reflect.Int64:
+		value, err := strconv.ParseInt(raw, 10, 64)
+		return float64(value), true, err
+	case reflect.Slice:
+		if fieldType.Elem().Kind() != reflect.String {
+			return nil, false, nil
+		}
+		values := make([]interface{}, 0)
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		return values, true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+// toTree converts a value to the generic tree form used for merging
+func toTree(value interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, types.ErrConfigurationf("failed to marshal config: %v", err)
+	}
+
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, types.ErrConfigurationf("failed to unmarshal config: %v", err)
+	}
+	return tree, nil
+}
+
+// normalizeValue converts a Go value to its tree form, e.g. ints to float64
+func normalizeValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, types.ErrConfigurationf("invalid config value: %v", err)
+	}
+
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, types.ErrConfigurationf("invalid config value: %v", err)
+	}
+	return normalized, nil
+}
+
+// copyTree returns a deep copy of a tree
+func copyTree(tree map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(tree))
+	for key, value := range tree {
+		copied[key] = copyValue(value)
+	}
+	return copied
+}
+
+// copyValue returns a deep copy of a tree value
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyTree(v)
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// mergeTree merges src into dst, recording the origin of every value set.
+// Keys listed in additive have their lists appended instead of replaced.
+func mergeTree(dst, src map[string]interface{}, prefix, origin string, additive []string, origins map[string]string) {
+	for key, value := range src {
+		path := joinPath(prefix, key)
+
+		if _, ok := value.(deletedValue); ok {
+			delete(dst, key)
+			continue
+		}
+
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap && len(srcMap) > 0 {
+			mergeTree(dstMap, srcMap, path, origin, additive, origins)
+			continue
+		}
+
+		if list, ok := value.([]interface{}); ok && containsString(additive, path) {
+			if existing, ok := dst[key].([]interface{}); ok {
+				value = appendUnique(existing, list)
+			}
+		}
+
+		dst[key] = copyValue(value)
+		if origins != nil {
+			markOrigins(path, dst[key], origin, origins)
+		}
+	}
+}
+
+// markOrigins records origin for a value and everything below it
+func markOrigins(path string, value interface{}, origin string, origins map[string]string) {
+	if tree, ok := value.(map[string]interface{}); ok && len(tree) > 0 {
+		for key, child := range tree {
+			markOrigins(joinPath(path, key), child, origin, origins)
+		}
+		return
+	}
+	origins[path] = origin
+}
+
+// diffTree returns the changes from old to current as a tree that can be
+// merged, with removed keys marked as deleted
+func diffTree(old, current map[string]interface{}) map[string]interface{} {
+	changes := make(map[string]interface{})
+
+	for key, value := range current {
+		previous, existed := old[key]
+		if !existed {
+			changes[key] = value
+			continue
+		}
+
+		currentMap, currentIsMap := value.(map[string]interface{})
+		previousMap, previousIsMap := previous.(map[string]interface{})
+		if currentIsMap && previousIsMap {
+			if nested := diffTree(previousMap, currentMap); len(nested) > 0 {
+				changes[key] = nested
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(previous, value) {
+			changes[key] = value
+		}
+	}
+
+	for key := range old {
+		if _, ok := current[key]; !ok {
+			changes[key] = deletedValue{}
+		}
+	}
+
+	return changes
+}
+
+// flattenTree lists the leaves of a tree with dotted keys
+func flattenTree(tree map[string]interface{}, prefix string, values map[string]interface{}) {
+	for key, value := range tree {
+		path := joinPath(prefix, key)
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenTree(nested, path, values)
+			continue
+		}
+		values[path] = value
+	}
+}
+
+// setPath builds a tree holding value at a dotted key
+func setPath(key string, value interface{}) map[string]interface{} {
+	parts := strings.Split(key, ".")
+	tree := map[string]interface{}{parts[len(parts)-1]: value}
+	for i := len(parts) - 2; i >= 0; i-- {
+		tree = map[string]interface{}{parts[i]: tree}
+	}
+	return tree
+}
+
+// joinPath joins dotted key segments
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// appendUnique appends the items not already present in list
+func appendUnique(list, items []interface{}) []interface{} {
+	merged := append([]interface{}{}, list...)
+	for _, item := range items {
+		found := false
+		for _, existing := range merged {
+			if reflect.DeepEqual(existing, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// rebuild merges all layers and decodes the result; the caller must hold
+// c.mu or have exclusive access to c
+func (c *Config) rebuild() error {
+	merged, origins := c.mergeLayers(nil)
+	c.origins = origins
+	c.merged = merged
+
+	// The profile is chosen by the merged layers, then merged in its place
+	if name, _ := merged["profile"].(string); name != "" {
+		profile, err := c.profileLayer(merged, name)
+		if err != nil {
+			return err
+		}
+		merged, origins = c.mergeLayers(&profile)
+		c.origins = origins
+		c.merged = merged
+	}
+
+	// Report wrong types with their position before decoding fails on them
+	errs, err := schemaErrors(merged)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return c.report(errs)
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return types.ErrConfigurationf("failed to marshal config: %v", err)
+	}
+
+	// Maps are merged by Unmarshal, so start them empty
+	c.Providers = nil
+	c.Environment = nil
+	c.Aliases = nil
+	c.Profiles = nil
+	if err := json.Unmarshal(data, c); err != nil {
+		return types.ErrConfigurationf("failed to parse config: %v", err)
+	}
+
+	loaded, err := toTree(c)
+	if err != nil {
+		return err
+	}
+
+	c.loaded = loaded
+	return nil
+}
+
+// mergeLayers merges all layers, placing profile below the layers that
+// take precedence over it
+func (c *Config) mergeLayers(profile *configLayer) (map[string]interface{}, map[string]string) {
+	merged := make(map[string]interface{})
+	origins := make(map[string]string)
+
+	for _, layer := range c.layers {
+		if profile != nil && layer.aboveProfile {
+			mergeTree(merged, profile.tree, "", profile.origin, profile.additive, origins)
+			profile = nil
+		}
+		mergeTree(merged, layer.tree, "", layer.origin, layer.additive, origins)
+	}
+	if profile != nil {
+		mergeTree(merged, profile.tree, "", profile.origin, profile.additive, origins)
+	}
+
+	return merged, origins
+}
+
+// Override sets a value for this run only, such as from a command line
+// flag. The key is a dotted path like "default_model". Overrides are not
+// written by Save; apply them before making changes that should be saved.
+func (c *Config) Override(key string, value interface{}, origin string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	normalized, err := normalizeValue(value)
+	if err != nil {
+		return err
+	}
+
+	c.layers = append(c.layers, configLayer{
+		origin:       origin,
+		tree:         setPath(key, normalized),
+		aboveProfile: true,
+	})
+	if err := c.rebuild(); err != nil {
+		return err
+	}
+	return c.validate()
+}
+
+// Origin returns where the effective value of a dotted key came from
+func (c *Config) Origin(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.origins[key]
+}
+
+// OverriddenBy returns the profile, project, environment variable or flag
+// that takes precedence over the config file for a dotted key, or "" when
+// the value saved to the config file is the one in effect
+func (c *Config) OverriddenBy(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	origin := c.origins[key]
+	if strings.HasPrefix(origin, "profile ") {
+		return origin
+	}
+	for _, layer := range c.layers {
+		if layer.aboveProfile && layer.origin == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+// Values lists every effective setting with its origin, sorted by key.
+// Settings changed since loading are reported as unsaved.
+func (c *Config) Values() ([]ConfigValue, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	current, err := toTree(c)
+	if err != nil {
+		return nil, err
+	}
+
+	leaves := make(map[string]interface{})
+	flattenTree(current, "", leaves)
+	loaded := make(map[string]interface{})
+	flattenTree(c.loaded, "", loaded)
+
+	values := make([]ConfigValue, 0, len(leaves))
+	for key, value := range leaves {
+		origin := c.origins[key]
+		if previous, ok := loaded[key]; !ok || !reflect.DeepEqual(previous, value) {
+			origin = "unsaved"
+		}
+		values = append(values, ConfigValue{Key: key, Value: value, Origin: origin})
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Key < values[j].Key
+	})
+	return values, nil
+}
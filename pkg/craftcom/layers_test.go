@@ -0,0 +1,378 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"craftcom/pkg/types"
+)
+
+// isolate gives a test an empty home and user config directory without
+// CRAFTCOM_* variables, and runs it in a project directory below the home.
+// It returns the home and project directories.
+func isolate(t *testing.T) (string, string) {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	for _, env := range os.Environ() {
+		if name, _, _ := strings.Cut(env, "="); strings.HasPrefix(name, envPrefix) {
+			// Setenv restores the variable after the test
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+
+	project := filepath.Join(home, "src", "project")
+	if err := os.MkdirAll(project, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(project); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	return home, project
+}
+
+// writeConfig writes a config file, creating its directory
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLayerPrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		dir        string // ~/.config/craftcom/config.json
+		file       string // ~/.craftcom.json
+		project    string // .craftcom.json in the working directory
+		env        string // CRAFTCOM_SYSTEM_PROMPT
+		override   string
+		want       string
+		wantOrigin string
+	}{
+		{name: "defaults", want: defaultSystemPrompt, wantOrigin: OriginDefault},
+		{name: "user config directory", dir: "dir", want: "dir", wantOrigin: "dir"},
+		{name: "config file over directory", dir: "dir", file: "file", want: "file", wantOrigin: "file"},
+		{name: "project over config file", file: "file", project: "project", want: "project", wantOrigin: "project"},
+		{name: "environment over project", project: "project", env: "env", want: "env", wantOrigin: "env CRAFTCOM_SYSTEM_PROMPT"},
+		{name: "override over environment", env: "env", override: "flag", want: "flag", wantOrigin: "--flag"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home, project := isolate(t)
+			origins := map[string]string{OriginDefault: OriginDefault}
+
+			if tt.dir != "" {
+				path := filepath.Join(home, ".config", layeredConfigDir, "config.json")
+				writeConfig(t, path, `{"system_prompt": "`+tt.dir+`"}`)
+				origins["dir"] = path
+			}
+			// A missing config file would be created with the defaults
+			path := filepath.Join(home, ".craftcom.json")
+			writeConfig(t, path, `{}`)
+			if tt.file != "" {
+				writeConfig(t, path, `{"system_prompt": "`+tt.file+`"}`)
+				origins["file"] = path
+			}
+			if tt.project != "" {
+				projectPath := filepath.Join(project, ".craftcom.json")
+				writeConfig(t, projectPath, `{"system_prompt": "`+tt.project+`"}`)
+				origins["project"] = projectPath
+			}
+			if tt.env != "" {
+				t.Setenv("CRAFTCOM_SYSTEM_PROMPT", tt.env)
+			}
+			origins["env CRAFTCOM_SYSTEM_PROMPT"] = "env CRAFTCOM_SYSTEM_PROMPT"
+			origins["--flag"] = "--flag"
+
+			config, err := LoadConfigFromPath(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.override != "" {
+				if err := config.Override("system_prompt", tt.override, "--flag"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if config.SystemPrompt != tt.want {
+				t.Errorf("system_prompt = %q, want %q", config.SystemPrompt, tt.want)
+			}
+			if origin := config.Origin("system_prompt"); origin != origins[tt.wantOrigin] {
+				t.Errorf("Origin(system_prompt) = %q, want %q", origin, origins[tt.wantOrigin])
+			}
+		})
+	}
+}
+
+func TestLayerObjectsMerge(t *testing.T) {
+	home, _ := isolate(t)
+	writeConfig(t, filepath.Join(home, ".config", layeredConfigDir, "config.json"), `{"environment": {"EDITOR": "vi", "PAGER": "less"}}`)
+	path := filepath.Join(home, ".craftcom.json")
+	writeConfig(t, path, `{"environment": {"EDITOR": "nano"}}`)
+
+	config, err := LoadConfigFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"EDITOR": "nano", "PAGER": "less"}
+	if !reflect.DeepEqual(config.Environment, want) {
+		t.Errorf("environment = %v, want %v", config.Environment, want)
+	}
+}
+
+func TestProjectDeniedKeys(t *testing.T) {
+	for _, key := range projectDeniedKeys {
+		t.Run(key, func(t *testing.T) {
+			home, project := isolate(t)
+			writeConfig(t, filepath.Join(project, ".craftcom.json"), `{"`+key+`": {}}`)
+
+			_, err := LoadConfigFromPath(filepath.Join(home, ".craftcom.json"))
+			if err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("project config setting %s: err = %v", key, err)
+			}
+		})
+	}
+}
+
+func TestProjectAdditiveKeys(t *testing.T) {
+	home, project := isolate(t)
+	writeConfig(t, filepath.Join(project, ".craftcom.json"), `{
+		"protected_paths": ["secrets", "/srv"],
+		"disallowed_commands": ["terraform destroy"]
+	}`)
+
+	config, err := LoadConfigFromPath(filepath.Join(home, ".craftcom.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := DefaultConfig()
+	wantPaths := append(defaults.ProtectedPaths, filepath.Join(project, "secrets"), "/srv")
+	if !reflect.DeepEqual(config.ProtectedPaths, wantPaths) {
+		t.Errorf("protected_paths = %v, want %v", config.ProtectedPaths, wantPaths)
+	}
+	wantCommands := append(defaults.DisallowedCommands, "terraform destroy")
+	if !reflect.DeepEqual(config.DisallowedCommands, wantCommands) {
+		t.Errorf("disallowed_commands = %v, want %v", config.DisallowedCommands, wantCommands)
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	tree := map[string]interface{}{
+		"default_provider": "gemini",
+		"api_key":          "key",
+		"max_tokens":       float64(2048),
+		"providers": map[string]interface{}{
+			"gemini": map[string]interface{}{"max_tokens": float64(4096)},
+		},
+	}
+
+	if !migrateLegacy(tree) {
+		t.Fatal("migrateLegacy reported no change")
+	}
+	if _, ok := tree["api_key"]; ok {
+		t.Error("api_key was left at the top level")
+	}
+	gemini := tree["providers"].(map[string]interface{})["gemini"].(map[string]interface{})
+	if gemini["api_key"] != "key" {
+		t.Errorf("providers.gemini.api_key = %v, want key", gemini["api_key"])
+	}
+	// Settings already under providers take precedence
+	if gemini["max_tokens"] != float64(4096) {
+		t.Errorf("providers.gemini.max_tokens = %v, want 4096", gemini["max_tokens"])
+	}
+
+	if migrateLegacy(tree) {
+		t.Error("migrateLegacy changed a migrated tree")
+	}
+}
+
+func TestLoadMigratesFile(t *testing.T) {
+	home, _ := isolate(t)
+	path := filepath.Join(home, ".craftcom.json")
+	writeConfig(t, path, `{"api_key": "key", "default_provider": "gemini"}`)
+
+	config, err := LoadConfigFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider, _ := config.GetProviderConfig("gemini"); provider.APIKey != "key" {
+		t.Errorf("gemini api_key = %q, want key", provider.APIKey)
+	}
+
+	// The file is rewritten in the current schema
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved map[string]interface{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved["api_key"]; ok {
+		t.Errorf("file still has a top-level api_key:\n%s", data)
+	}
+}
+
+func TestProjectRedactionKeys(t *testing.T) {
+	tests := []struct {
+		name         string
+		project      string
+		wantPatterns []string
+		wantEmails   bool
+		wantErr      string
+	}{
+		{name: "add patterns", project: `{"redaction": {"patterns": ["ACME-[0-9]+"]}}`, wantPatterns: []string{"ticket-[0-9]+", "ACME-[0-9]+"}},
+		{name: "turn on", project: `{"redaction": {"enabled": true, "emails": true}}`, wantPatterns: []string{"ticket-[0-9]+"}, wantEmails: true},
+		{name: "turn off", project: "{\n  \"redaction\": {\n    \"enabled\": false\n  }\n}", wantErr: ":3: redaction.enabled: can only be turned on by a project config"},
+		{name: "turn off identity", project: `{"redaction": {"identity": false}}`, wantErr: "redaction.identity: can only be turned on"},
+		{name: "replace the object", project: `{"redaction": false}`, wantErr: "redaction: cannot be set by a project config"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home, project := isolate(t)
+			path := filepath.Join(home, ".craftcom.json")
+			writeConfig(t, path, `{"redaction": {"emails": false, "patterns": ["ticket-[0-9]+"]}}`)
+			writeConfig(t, filepath.Join(project, ".craftcom.json"), tt.project)
+
+			config, err := LoadConfigFromPath(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !config.Redaction.Enabled {
+				t.Error("redaction was turned off")
+			}
+			if !reflect.DeepEqual(config.Redaction.Patterns, tt.wantPatterns) {
+				t.Errorf("patterns = %v, want %v", config.Redaction.Patterns, tt.wantPatterns)
+			}
+			if config.Redaction.Emails != tt.wantEmails {
+				t.Errorf("emails = %v, want %v", config.Redaction.Emails, tt.wantEmails)
+			}
+		})
+	}
+}
+
+func TestProjectFileAccessKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		wantErr string
+	}{
+		{name: "add denied files", project: `{"file_access": {"denied": ["**/*.sqlite"]}}`},
+		{name: "turn confirmation on", project: `{"file_access": {"confirm": true}}`},
+		{name: "turn confirmation off", project: `{"file_access": {"confirm": false}}`, wantErr: "file_access.confirm: can only be turned on by a project config"},
+		{name: "allowed roots", project: `{"file_access": {"allowed_roots": ["/"]}}`, wantErr: "file_access.allowed_roots: cannot be set by a project config"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home, project := isolate(t)
+			path := filepath.Join(home, ".craftcom.json")
+			writeConfig(t, path, `{"file_access": {"allowed_roots": ["~/src"], "confirm": false}}`)
+			writeConfig(t, filepath.Join(project, ".craftcom.json"), tt.project)
+
+			config, err := LoadConfigFromPath(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Denied files are added to the configured ones
+			policy := config.FileAccess
+			if !reflect.DeepEqual(policy.AllowedRoots, []string{"~/src"}) {
+				t.Errorf("allowed_roots = %v", policy.AllowedRoots)
+			}
+			for _, denied := range DefaultConfig().FileAccess.Denied {
+				if !containsString(policy.Denied, denied) {
+					t.Errorf("denied lost %q", denied)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateAllowedFileTypes(t *testing.T) {
+	current := make([]interface{}, 0, len(types.DefaultAllowedFileTypes))
+	for _, fileType := range types.DefaultAllowedFileTypes {
+		current = append(current, fileType)
+	}
+
+	tests := []struct {
+		name    string
+		allowed []interface{}
+		want    []interface{}
+		changed bool
+	}{
+		{"old default", []interface{}{".txt", ".md", ".pdf", ".png", ".jpg", ".jpeg", ".mp3", ".wav", ".mp4"}, current, true},
+		{"old default reordered", []interface{}{".MP4", ".wav", ".mp3", ".jpeg", ".jpg", ".png", ".pdf", ".md", ".txt"}, current, true},
+		{"customised", []interface{}{".txt", ".md"}, []interface{}{".txt", ".md"}, false},
+		{"one changed", []interface{}{".txt", ".md", ".pdf", ".png", ".jpg", ".jpeg", ".mp3", ".wav", ".go"}, []interface{}{".txt", ".md", ".pdf", ".png", ".jpg", ".jpeg", ".mp3", ".wav", ".go"}, false},
+		{"current default", current, current, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := map[string]interface{}{"allowed_file_types": tt.allowed}
+			if changed := migrateAllowedFileTypes(tree); changed != tt.changed {
+				t.Errorf("migrateAllowedFileTypes() = %v, want %v", changed, tt.changed)
+			}
+			if !reflect.DeepEqual(tree["allowed_file_types"], tt.want) {
+				t.Errorf("allowed_file_types = %v, want %v", tree["allowed_file_types"], tt.want)
+			}
+		})
+	}
+
+	if migrateAllowedFileTypes(map[string]interface{}{}) {
+		t.Error("migrateAllowedFileTypes changed a config without allowed_file_types")
+	}
+}
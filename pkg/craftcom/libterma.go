@@ -24,9 +24,15 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 
+	"craftcom/pkg/collector"
 	"craftcom/pkg/gemini"
+	"craftcom/pkg/redact"
+	"craftcom/pkg/secrets"
 	"craftcom/pkg/types"
 )
 
@@ -37,10 +43,20 @@ const Version = "0.1.0"
 type Terma struct {
 	config    *Config
 	providers map[string]types.Provider
+	collector *collector.Collector
+	settings  map[string]providerSettings // What each provider was created from
+	retired   []types.Provider            // Replaced on reload, closed with Terma
 	history   []types.CommandHistory
+	store     *HistoryStore
 	mu        sync.RWMutex
 }
 
+// providerSettings are the settings a provider is created from
+type providerSettings struct {
+	config       ProviderConfig
+	systemPrompt string
+}
+
 // New creates a new Terma instance
 func New(configPath string) (*Terma, error) {
 	// Load configuration
@@ -49,11 +65,26 @@ func New(configPath string) (*Terma, error) {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
+	return NewWithConfig(config)
+}
+
+// NewWithConfig creates a new Terma instance from a loaded configuration
+func NewWithConfig(config *Config) (*Terma, error) {
+	// Load persisted history
+	store := NewHistoryStore(config.HistoryFile, config.HistorySize)
+	history, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load history: %w", err)
+	}
+
 	t := &Terma{
 		config:    config,
 		providers: make(map[string]types.Provider),
-		history:   make([]types.CommandHistory, 0, config.HistorySize),
+		settings:  make(map[string]providerSettings),
+		history:   history,
+		store:     store,
 	}
+	t.collector = collector.New(t.contextOptions)
 
 	// Initialize providers
 	if err := t.initializeProviders(); err != nil {
@@ -65,42 +96,289 @@ func New(configPath string) (*Terma, error) {
 
 // initializeProviders sets up AI providers based on configuration
 func (t *Terma) initializeProviders() error {
-	ctx := context.Background()
+	_, err := t.applyProviders(context.Background())
+	return err
+}
 
+// applyProviders creates the enabled providers whose settings changed since
+// they were last created and drops disabled ones, reporting whether any
+// changed. If a provider cannot be created the current ones are kept.
+func (t *Terma) applyProviders(ctx context.Context) (bool, error) {
+	t.config.mu.RLock()
+	wanted := make(map[string]providerSettings)
 	for name, config := range t.config.Providers {
-		if !config.Enabled {
+		if config.Enabled {
+			wanted[name] = providerSettings{config: config, systemPrompt: t.config.SystemPrompt}
+		}
+	}
+	t.config.mu.RUnlock()
+
+	t.mu.RLock()
+	current, settings := t.providers, t.settings
+	t.mu.RUnlock()
+
+	providers := make(map[string]types.Provider, len(wanted))
+	created := make([]types.Provider, 0)
+	changed := len(wanted) != len(current)
+	for name, want := range wanted {
+		if provider, ok := current[name]; ok && reflect.DeepEqual(settings[name], want) {
+			providers[name] = provider
 			continue
 		}
 
-		switch name {
-		case "gemini":
-			provider, err := gemini.NewProvider(
-				ctx,
-				config.APIKey,
-				t.config.SystemPrompt,
-			)
-			if err != nil {
-				return fmt.Errorf("failed to initialize Gemini provider: %w", err)
+		provider, err := NewProvider(ctx, name, want.config, want.systemPrompt)
+		if err != nil {
+			for _, p := range created {
+				p.Close()
 			}
-			t.providers[name] = provider
+			return false, err
+		}
+		if setter, ok := provider.(interface {
+			SetContextCollector(types.ContextCollector)
+		}); ok {
+			setter.SetContextCollector(t.collector)
+		}
+		if setter, ok := provider.(interface {
+			SetRedactor(func() types.Redactor)
+		}); ok {
+			setter.SetRedactor(t.newRedactor)
+		}
+		if setter, ok := provider.(interface {
+			SetFileReader(func() *types.FileReader)
+		}); ok {
+			setter.SetFileReader(t.FileReader)
+		}
+		providers[name] = provider
+		created = append(created, provider)
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
 
-		// Add more providers here as needed
-		default:
-			return fmt.Errorf("unknown provider: %s", name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Chats may still use the replaced providers, so they are closed last
+	for name, provider := range t.providers {
+		if providers[name] != provider {
+			t.retired = append(t.retired, provider)
 		}
 	}
+	t.providers = providers
+	t.settings = wanted
+	return true, nil
+}
 
-	return nil
+// contextOptions returns the context settings currently in effect
+func (t *Terma) contextOptions() collector.Options {
+	t.config.mu.RLock()
+	defer t.config.mu.RUnlock()
+
+	settings := t.config.Context
+	return collector.Options{
+		Budget:    settings.Budget,
+		Project:   settings.Project,
+		Git:       settings.Git,
+		Tools:     settings.Tools,
+		Versions:  settings.Versions,
+		Directory: settings.Directory,
+	}
+}
+
+// newRedactor creates the redactor for a new chat from the settings in
+// effect, or returns nil when redaction is disabled
+func (t *Terma) newRedactor() types.Redactor {
+	t.config.mu.RLock()
+	settings := t.config.Redaction
+	t.config.mu.RUnlock()
+
+	if !settings.Enabled {
+		return nil
+	}
+	options := redact.Options{
+		Emails:      settings.Emails,
+		IPAddresses: settings.IPAddresses,
+		Identity:    settings.Identity,
+		Patterns:    settings.Patterns,
+	}
+	redactor, err := redact.New(options)
+	if err != nil {
+		// Patterns are validated with the configuration; never send
+		// unredacted text because of one
+		options.Patterns = nil
+		redactor, _ = redact.New(options)
+	}
+	return redactor
+}
+
+// FileReader returns a reader for attached files that applies the file
+// access policy and size limit in effect
+func (t *Terma) FileReader() *types.FileReader {
+	t.config.mu.RLock()
+	defer t.config.mu.RUnlock()
+
+	reader := types.NewFileReader()
+	if t.config.MaxFileSize > 0 {
+		reader.MaxSize = t.config.MaxFileSize
+	}
+	if len(t.config.AllowedFileTypes) > 0 {
+		reader.AllowedTypes = append([]string(nil), t.config.AllowedFileTypes...)
+	}
+	reader.Policy = types.FileAccessPolicy{
+		AllowedRoots: append([]string(nil), t.config.FileAccess.AllowedRoots...),
+		Denied:       append([]string(nil), t.config.FileAccess.Denied...),
+	}
+	return reader
+}
+
+// ConfigReload is the outcome of reloading the configuration
+type ConfigReload struct {
+	// Err is why the change was rejected; the previous settings stay active
+	Err error
+	// ProvidersChanged is set when providers were recreated; chats created
+	// before keep the previous settings
+	ProvidersChanged bool
+}
+
+// WatchConfig reloads the configuration when its files change, until ctx
+// is done, and recreates the providers whose settings changed. The safety
+// policy applies to the next command checked. notify is called after each
+// change.
+func (t *Terma) WatchConfig(ctx context.Context, notify func(ConfigReload)) error {
+	return t.config.Watch(ctx, func(err error) {
+		if err != nil {
+			notify(ConfigReload{Err: err})
+			return
+		}
+		changed, err := t.applyProviders(ctx)
+		if err != nil {
+			err = fmt.Errorf("failed to apply provider settings: %w", err)
+		}
+		notify(ConfigReload{Err: err, ProvidersChanged: changed})
+	})
+}
+
+// NewProvider creates the named provider from its configuration
+func NewProvider(ctx context.Context, name string, config ProviderConfig, systemPrompt string) (types.Provider, error) {
+	apiKey, err := ResolveAPIKey(ctx, name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "gemini":
+		provider, err := gemini.NewProvider(ctx, apiKey, systemPrompt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Gemini provider: %w", err)
+		}
+		provider.SetGenerationConfig(gemini.GenerationConfig{
+			Temperature:     config.GenerationTemperature(),
+			TopP:            config.GenerationTopP(),
+			MaxOutputTokens: config.MaxTokens,
+		})
+		return provider, nil
+
+	// Add more providers here as needed
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", name)
+	}
+}
+
+// KnownModels returns the models a provider offers without creating it,
+// so nothing such as API key commands runs
+func KnownModels(name string) []string {
+	switch name {
+	case "gemini":
+		return []string{gemini.ModelGemini15Pro.Name, gemini.ModelGemini15Flash.Name}
+	default:
+		return nil
+	}
+}
+
+// ResolveAPIKey returns the API key of a provider: the api_key setting,
+// then the configured secret source, then the provider's environment
+// variable, e.g. GEMINI_API_KEY. The config is left unchanged.
+func ResolveAPIKey(ctx context.Context, name string, config ProviderConfig) (string, error) {
+	if config.APIKey != "" {
+		return config.APIKey, nil
+	}
+
+	source := config.SecretSource(name)
+	if source.IsZero() {
+		return os.Getenv(strings.ToUpper(name) + "_API_KEY"), nil
+	}
+
+	apiKey, err := secrets.Resolve(ctx, source)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s API key from %s: %w", name, source, err)
+	}
+	return apiKey, nil
 }
 
 // Chat creates a new chat session
 func (t *Terma) Chat(ctx context.Context) (types.Chat, error) {
-	provider, err := t.getProvider(t.config.DefaultProvider)
+	t.config.mu.RLock()
+	providerName, model := t.config.DefaultProvider, t.config.DefaultModel
+	t.config.mu.RUnlock()
+
+	provider, err := t.getProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.Chat(ctx, model)
+}
+
+// TaskChat creates a chat for a task such as summarizing, with its own
+// system prompt and without the context sent with requests. Providers
+// that cannot create one give a regular chat.
+func (t *Terma) TaskChat(ctx context.Context, systemPrompt string) (types.Chat, error) {
+	t.config.mu.RLock()
+	providerName, model := t.config.DefaultProvider, t.config.DefaultModel
+	t.config.mu.RUnlock()
+
+	provider, err := t.getProvider(providerName)
 	if err != nil {
 		return nil, err
 	}
 
-	return provider.Chat(ctx, t.config.DefaultModel)
+	if tasks, ok := provider.(interface {
+		TaskChat(ctx context.Context, model, systemPrompt string) (types.Chat, error)
+	}); ok {
+		return tasks.TaskChat(ctx, model, systemPrompt)
+	}
+	return provider.Chat(ctx, model)
+}
+
+// ModelInfo returns the limits and features of the model chats are
+// created with
+func (t *Terma) ModelInfo() (types.ModelInfo, error) {
+	t.config.mu.RLock()
+	providerName, model := t.config.DefaultProvider, t.config.DefaultModel
+	t.config.mu.RUnlock()
+
+	provider, err := t.getProvider(providerName)
+	if err != nil {
+		return types.ModelInfo{}, err
+	}
+
+	return provider.GetModelInfo(model)
+}
+
+// CountTokens counts the tokens text is to the model chats are created
+// with
+func (t *Terma) CountTokens(ctx context.Context, text string) (int, error) {
+	t.config.mu.RLock()
+	providerName, model := t.config.DefaultProvider, t.config.DefaultModel
+	t.config.mu.RUnlock()
+
+	provider, err := t.getProvider(providerName)
+	if err != nil {
+		return 0, err
+	}
+
+	return provider.CountTokens(ctx, model, text)
 }
 
 // ChatWithProvider creates a chat session with a specific provider
@@ -113,6 +391,24 @@ func (t *Terma) ChatWithProvider(ctx context.Context, providerName, model string
 	return provider.Chat(ctx, model)
 }
 
+// ListModels returns the models offered by all initialized providers
+func (t *Terma) ListModels(ctx context.Context) ([]string, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	models := make([]string, 0)
+	for name, provider := range t.providers {
+		providerModels, err := provider.ListModels(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list models for %s: %w", name, err)
+		}
+		models = append(models, providerModels...)
+	}
+
+	sort.Strings(models)
+	return models, nil
+}
+
 // Execute runs a command through the AI assistant
 func (t *Terma) Execute(ctx context.Context, input string) (*types.CommandHistory, error) {
 	// Get chat instance
@@ -140,7 +436,9 @@ func (t *Terma) Execute(ctx context.Context, input string) (*types.CommandHistor
 	}
 
 	// Add to history
-	t.addToHistory(result)
+	if err := t.addToHistory(result); err != nil {
+		return nil, err
+	}
 
 	return result, nil
 }
@@ -167,7 +465,9 @@ func (t *Terma) ExecuteWithFiles(ctx context.Context, input string, files []stri
 		Output:  resp.FullOutput,
 	}
 
-	t.addToHistory(result)
+	if err := t.addToHistory(result); err != nil {
+		return nil, err
+	}
 
 	return result, nil
 }
@@ -180,13 +480,29 @@ func (t *Terma) GetHistory() []types.CommandHistory {
 }
 
 // ClearHistory clears command history
-func (t *Terma) ClearHistory() {
+func (t *Terma) ClearHistory() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.history = make([]types.CommandHistory, 0, t.config.HistorySize)
+	t.history = make([]types.CommandHistory, 0, t.config.historySize())
+	return t.store.Clear()
+}
+
+// RecordHistory adds an externally executed command to history
+func (t *Terma) RecordHistory(entry types.CommandHistory) error {
+	return t.addToHistory(&entry)
+}
+
+// Config returns the configuration the instance was created with
+func (t *Terma) Config() *Config {
+	return t.config
+}
+
+// Provider returns an initialized provider by name
+func (t *Terma) Provider(name string) (types.Provider, error) {
+	return t.getProvider(name)
 }
 
-// GetProvider returns a provider by name
+// getProvider returns a provider by name
 func (t *Terma) getProvider(name string) (types.Provider, error) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -199,15 +515,21 @@ func (t *Terma) getProvider(name string) (types.Provider, error) {
 	return provider, nil
 }
 
-// addToHistory adds a command to history
-func (t *Terma) addToHistory(cmd *types.CommandHistory) {
+// addToHistory adds a command to history and persists it
+func (t *Terma) addToHistory(cmd *types.CommandHistory) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	if cmd.Source == "" {
+		cmd.Source = types.HistorySourceCraftCom
+	}
+
 	t.history = append(t.history, *cmd)
-	if len(t.history) > t.config.HistorySize {
+	if len(t.history) > t.config.historySize() {
 		t.history = t.history[1:]
 	}
+
+	return t.store.Append(*cmd)
 }
 
 // Close cleans up resources
@@ -215,7 +537,11 @@ func (t *Terma) Close() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	providers := append([]types.Provider{}, t.retired...)
 	for _, provider := range t.providers {
+		providers = append(providers, provider)
+	}
+	for _, provider := range providers {
 		if err := provider.Close(); err != nil {
 			return fmt.Errorf("failed to close provider: %w", err)
 		}
@@ -229,12 +555,20 @@ func (t *Terma) GetSystemInfo() types.SystemInfo {
 	wd, _ := os.Getwd()
 	homeDir, _ := os.UserHomeDir()
 
+	t.config.mu.RLock()
+	shell := t.config.Shell
+	environment := make(map[string]string, len(t.config.Environment))
+	for key, value := range t.config.Environment {
+		environment[key] = value
+	}
+	t.config.mu.RUnlock()
+
 	return types.SystemInfo{
 		OS:          os.Getenv("GOOS"),
-		Shell:       t.config.Shell,
+		Shell:       shell,
 		User:        os.Getenv("USER"),
 		HomeDir:     homeDir,
 		WorkingDir:  wd,
-		Environment: t.config.Environment,
+		Environment: environment,
 	}
 }
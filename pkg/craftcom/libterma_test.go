@@ -0,0 +1,68 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"context"
+	"runtime"
+	"testing"
+)
+
+func TestResolveAPIKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ProviderConfig
+		want   string
+	}{
+		{"api_key first", ProviderConfig{APIKey: "from-config", APIKeyEnv: "CRAFTCOM_TEST_KEY", APIKeyCmd: "echo from-command"}, "from-config"},
+		{"api_key_env before the command", ProviderConfig{APIKeyEnv: "CRAFTCOM_TEST_KEY", APIKeyCmd: "echo from-command"}, "from-env"},
+		{"api_key_cmd", ProviderConfig{APIKeyCmd: "echo from-command"}, "from-command"},
+		{"provider variable last", ProviderConfig{}, "from-gemini-env"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && tt.config.APIKeyCmd != "" {
+				t.Skip("commands are run by sh")
+			}
+			t.Setenv("CRAFTCOM_TEST_KEY", "from-env")
+			t.Setenv("GEMINI_API_KEY", "from-gemini-env")
+
+			got, err := ResolveAPIKey(context.Background(), "gemini", tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveAPIKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveAPIKeySourceFails(t *testing.T) {
+	// A configured source that fails is reported, not skipped for the
+	// provider's variable
+	t.Setenv("GEMINI_API_KEY", "from-gemini-env")
+	_, err := ResolveAPIKey(context.Background(), "gemini", ProviderConfig{APIKeyEnv: "CRAFTCOM_TEST_UNSET"})
+	if err == nil {
+		t.Error("ResolveAPIKey fell back to GEMINI_API_KEY")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profile is a named set of settings, selected with the "profile" key,
+// CRAFTCOM_PROFILE or --profile. Empty fields keep the configured value;
+// protected paths are added to the configured ones.
+type Profile struct {
+	Provider       string   `json:"provider,omitempty"`
+	Model          string   `json:"model,omitempty"`
+	SafetyLevel    string   `json:"safety_level,omitempty"`
+	SystemPrompt   string   `json:"system_prompt,omitempty"`
+	ProtectedPaths []string `json:"protected_paths,omitempty"`
+}
+
+// profileKeys maps profile fields to the settings they replace
+var profileKeys = map[string]string{
+	"provider":        "default_provider",
+	"model":           "default_model",
+	"safety_level":    "safety_level",
+	"system_prompt":   "system_prompt",
+	"protected_paths": "protected_paths",
+}
+
+// profileAdditiveKeys are lists a profile adds to instead of replacing, so
+// selecting a profile cannot drop a protection
+var profileAdditiveKeys = []string{
+	"protected_paths",
+}
+
+// ProfileNames returns the names of the defined profiles in order
+func (c *Config) ProfileNames() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profileLayer turns the named profile from the merged tree into a layer
+// of the settings it replaces
+func (c *Config) profileLayer(merged map[string]interface{}, name string) (configLayer, error) {
+	profiles, _ := merged["profiles"].(map[string]interface{})
+	profile, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		defined := sortedTreeKeys(profiles)
+		message := fmt.Sprintf("unknown profile %q (no profiles are defined)", name)
+		if len(defined) > 0 {
+			message = fmt.Sprintf("unknown profile %q (defined: %s)", name, strings.Join(defined, ", "))
+		}
+		return configLayer{}, c.report([]fieldError{{"profile", message}})
+	}
+
+	tree := make(map[string]interface{}, len(profile))
+	for field, value := range profile {
+		// Unknown fields are reported by the schema
+		if key, ok := profileKeys[field]; ok {
+			tree[key] = copyValue(value)
+		}
+	}
+
+	return configLayer{origin: "profile " + name, tree: tree, additive: profileAdditiveKeys}, nil
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const profilesConfig = `{
+  "profile": "work",
+  "safety_level": "low",
+  "profiles": {
+    "work": {"model": "gemini-1.5-pro", "safety_level": "high", "protected_paths": ["/srv"]},
+    "personal": {"model": "gemini-1.5-flash", "safety_level": "medium"}
+  }
+}`
+
+func TestProfileSelection(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         string
+		flag        string
+		wantProfile string
+		wantModel   string
+		wantSafety  string
+	}{
+		{name: "from the config file", wantProfile: "work", wantModel: "gemini-1.5-pro", wantSafety: "high"},
+		{name: "from the environment", env: "personal", wantProfile: "personal", wantModel: "gemini-1.5-flash", wantSafety: "medium"},
+		{name: "flag over environment", env: "personal", flag: "work", wantProfile: "work", wantModel: "gemini-1.5-pro", wantSafety: "high"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home, _ := isolate(t)
+			path := filepath.Join(home, ".craftcom.json")
+			writeConfig(t, path, profilesConfig)
+			if tt.env != "" {
+				t.Setenv("CRAFTCOM_PROFILE", tt.env)
+			}
+
+			config, err := LoadConfigFromPath(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.flag != "" {
+				if err := config.Override("profile", tt.flag, "--profile"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if config.Profile != tt.wantProfile || config.DefaultModel != tt.wantModel || config.SafetyLevel != tt.wantSafety {
+				t.Errorf("profile %q, model %q, safety %q; want %q, %q, %q",
+					config.Profile, config.DefaultModel, config.SafetyLevel, tt.wantProfile, tt.wantModel, tt.wantSafety)
+			}
+			if origin := config.Origin("safety_level"); origin != "profile "+tt.wantProfile {
+				t.Errorf("Origin(safety_level) = %q, want profile %s", origin, tt.wantProfile)
+			}
+		})
+	}
+}
+
+func TestProfileBelowProject(t *testing.T) {
+	home, project := isolate(t)
+	path := filepath.Join(home, ".craftcom.json")
+	writeConfig(t, path, profilesConfig)
+	writeConfig(t, filepath.Join(project, ".craftcom.json"), `{"safety_level": "medium"}`)
+
+	config, err := LoadConfigFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.SafetyLevel != "medium" || config.DefaultModel != "gemini-1.5-pro" {
+		t.Errorf("safety %q, model %q; want the project's safety and the profile's model", config.SafetyLevel, config.DefaultModel)
+	}
+}
+
+func TestProfileProtectedPathsAdded(t *testing.T) {
+	home, _ := isolate(t)
+	path := filepath.Join(home, ".craftcom.json")
+	writeConfig(t, path, profilesConfig)
+
+	config, err := LoadConfigFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(DefaultConfig().ProtectedPaths, "/srv")
+	if !reflect.DeepEqual(config.ProtectedPaths, want) {
+		t.Errorf("protected_paths = %v, want %v", config.ProtectedPaths, want)
+	}
+}
+
+func TestUnknownProfile(t *testing.T) {
+	home, _ := isolate(t)
+	path := filepath.Join(home, ".craftcom.json")
+	writeConfig(t, path, profilesConfig)
+	t.Setenv("CRAFTCOM_PROFILE", "holiday")
+
+	_, err := LoadConfigFromPath(path)
+	if err == nil || !strings.Contains(err.Error(), `unknown profile "holiday" (defined: personal, work)`) {
+		t.Errorf("err = %v, want the defined profiles listed", err)
+	}
+}
+
+func TestProfileNames(t *testing.T) {
+	home, _ := isolate(t)
+	path := filepath.Join(home, ".craftcom.json")
+	writeConfig(t, path, profilesConfig)
+
+	config, err := LoadConfigFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := config.ProfileNames(); !reflect.DeepEqual(names, []string{"personal", "work"}) {
+		t.Errorf("ProfileNames() = %v", names)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"craftcom/pkg/types"
+)
+
+// reloadDelay lets editors finish writing, which often takes several
+// events, before the files are read again
+const reloadDelay = 250 * time.Millisecond
+
+// Reload reads the config files again and applies them when the result is
+// valid. Environment variables and overrides such as flags stay in effect.
+// When the files are invalid the current configuration is kept and the
+// problem returned.
+func (c *Config) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	defaults := c.layers[0]
+	files, userTree, _, _, err := readFileLayers(c.configPath, defaults.tree)
+	if err != nil {
+		return err
+	}
+
+	layers := append([]configLayer{defaults}, files...)
+	for _, layer := range c.layers[1:] {
+		if layer.path == "" && layer.aboveProfile {
+			layers = append(layers, layer)
+		}
+	}
+
+	previousLayers, previousUserTree := c.layers, c.userTree
+	c.layers, c.userTree = layers, userTree
+	err = c.rebuild()
+	if err == nil {
+		err = c.validate()
+	}
+	if err != nil {
+		// The previous layers were valid, so this restores them
+		c.layers, c.userTree = previousLayers, previousUserTree
+		if rebuildErr := c.rebuild(); rebuildErr != nil {
+			return rebuildErr
+		}
+		return err
+	}
+	return nil
+}
+
+// Files returns the config files in effect, including the config file
+// being loaded even before it is created
+func (c *Config) Files() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var files []string
+	for _, layer := range c.layers {
+		if layer.path != "" {
+			files = append(files, layer.path)
+		}
+	}
+	return files
+}
+
+// Watch reloads the configuration whenever one of its files is written,
+// until ctx is done. Config files created next to the watched ones, such
+// as a YAML file replacing a JSON one, are picked up too. onReload is
+// called after each reload with nil, or with the error the change was
+// rejected for.
+func (c *Config) Watch(ctx context.Context, onReload func(error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return types.ErrSystemf("failed to watch config files: %v", err)
+	}
+
+	// Editors replace files instead of writing them, so the directories
+	// are watched rather than the files
+	dirs := make(map[string]bool)
+	names := map[string]bool{layeredConfigName: true, projectConfigName: true}
+	for _, file := range c.Files() {
+		dirs[filepath.Dir(file)] = true
+		names[strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))] = true
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs[filepath.Join(dir, layeredConfigDir)] = true
+	}
+
+	watched := 0
+	for dir := range dirs {
+		if err := watcher.Add(dir); err == nil {
+			watched++
+		}
+	}
+	if watched == 0 {
+		watcher.Close()
+		return types.ErrSystemf("failed to watch config files: no config directory exists")
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		timer := time.NewTimer(reloadDelay)
+		timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if isConfigFileEvent(event, names) {
+					timer.Reset(reloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				onReload(types.ErrSystemf("failed to watch config files: %v", err))
+			case <-timer.C:
+				onReload(c.Reload())
+			}
+		}
+	}()
+
+	return nil
+}
+
+// isConfigFileEvent reports whether a change is to a file that may be one
+// of the config layers
+func isConfigFileEvent(event fsnotify.Event, names map[string]bool) bool {
+	if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) &&
+		!event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+		return false
+	}
+
+	base := filepath.Base(event.Name)
+	ext := filepath.Ext(base)
+	return containsString(configExtensions, strings.ToLower(ext)) && names[strings.TrimSuffix(base, ext)]
+}
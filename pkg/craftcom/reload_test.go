@@ -0,0 +1,121 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	home, _ := isolate(t)
+	path := filepath.Join(home, ".craftcom.json")
+	writeConfig(t, path, `{"safety_level": "low", "system_prompt": "first"}`)
+	t.Setenv("CRAFTCOM_QUIET", "true")
+
+	config, err := LoadConfigFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Override("default_model", "gemini-1.5-flash", "--model"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig(t, path, `{"safety_level": "high", "system_prompt": "second", "default_model": "gemini-1.5-pro"}`)
+	if err := config.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if config.SafetyLevel != "high" || config.SystemPrompt != "second" {
+		t.Errorf("safety %q, prompt %q after reload", config.SafetyLevel, config.SystemPrompt)
+	}
+	// Environment variables and overrides stay in effect
+	if !config.Quiet || config.DefaultModel != "gemini-1.5-flash" {
+		t.Errorf("quiet %v, model %q after reload; want the environment and flag kept", config.Quiet, config.DefaultModel)
+	}
+}
+
+func TestReloadKeepsConfigWhenInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"syntax error", `{"safety_level": "high",`},
+		{"schema error", `{"safety_level": "paranoid", "system_prompt": "second"}`},
+		{"unknown key", `{"safty_level": "high", "system_prompt": "second"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home, _ := isolate(t)
+			path := filepath.Join(home, ".craftcom.json")
+			writeConfig(t, path, `{"safety_level": "low", "system_prompt": "first"}`)
+
+			config, err := LoadConfigFromPath(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			writeConfig(t, path, tt.content)
+			if err := config.Reload(); err == nil {
+				t.Fatal("Reload accepted an invalid config")
+			}
+			if config.SafetyLevel != "low" || config.SystemPrompt != "first" {
+				t.Errorf("safety %q, prompt %q; want the previous config kept", config.SafetyLevel, config.SystemPrompt)
+			}
+			if origin := config.Origin("system_prompt"); origin != path {
+				t.Errorf("Origin(system_prompt) = %q, want %q", origin, path)
+			}
+			if err := config.Validate(); err != nil {
+				t.Errorf("kept config is invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestReloadProjectConfig(t *testing.T) {
+	home, project := isolate(t)
+	path := filepath.Join(home, ".craftcom.json")
+	writeConfig(t, path, `{}`)
+
+	config, err := LoadConfigFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A project config created during the session is picked up
+	projectPath := filepath.Join(project, ".craftcom.yaml")
+	writeConfig(t, projectPath, "system_prompt: project\n")
+	if err := config.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if config.SystemPrompt != "project" {
+		t.Errorf("system_prompt = %q, want project", config.SystemPrompt)
+	}
+
+	// and one that sets a denied key is rejected
+	writeConfig(t, projectPath, "system_prompt: other\nhistory_file: /tmp/history\n")
+	if err := config.Reload(); err == nil {
+		t.Error("Reload accepted a project config setting history_file")
+	}
+	if config.SystemPrompt != "project" {
+		t.Errorf("system_prompt = %q, want the previous project value", config.SystemPrompt)
+	}
+}
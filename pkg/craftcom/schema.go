@@ -0,0 +1,453 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"craftcom/pkg/redact"
+	"craftcom/pkg/types"
+)
+
+//go:embed config.schema.json
+var configSchemaJSON []byte
+
+var (
+	configSchema     *schemaNode
+	configSchemaErr  error
+	configSchemaOnce sync.Once
+)
+
+// ConfigSchema returns the JSON Schema configuration files are validated
+// against, for editors and other tools
+func ConfigSchema() []byte {
+	return append([]byte(nil), configSchemaJSON...)
+}
+
+// schemaNode is the subset of JSON Schema used by the config schema
+type schemaNode struct {
+	Ref                  string                 `json:"$ref"`
+	Title                string                 `json:"title"`
+	Type                 string                 `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	Properties           map[string]*schemaNode `json:"properties"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	PropertyNames        *schemaNode            `json:"propertyNames"`
+	Items                *schemaNode            `json:"items"`
+	Defs                 map[string]*schemaNode `json:"$defs"`
+
+	// Resolved from AdditionalProperties; nil with closed false means any
+	// value is allowed
+	additional *schemaNode
+	closed     bool
+}
+
+// fieldError is a validation problem with a setting
+type fieldError struct {
+	key     string
+	message string
+}
+
+// loadConfigSchema parses the embedded schema once
+func loadConfigSchema() (*schemaNode, error) {
+	configSchemaOnce.Do(func() {
+		var root schemaNode
+		if err := json.Unmarshal(configSchemaJSON, &root); err != nil {
+			configSchemaErr = types.ErrConfigurationf("invalid config schema: %v", err)
+			return
+		}
+		configSchemaErr = root.prepare(&root)
+		configSchema = &root
+	})
+	return configSchema, configSchemaErr
+}
+
+// prepare resolves references and additionalProperties below the node
+func (s *schemaNode) prepare(root *schemaNode) error {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/$defs/")
+		target, ok := root.Defs[name]
+		if !ok {
+			return types.ErrConfigurationf("invalid config schema: unknown reference %s", s.Ref)
+		}
+		*s = *target
+	}
+
+	switch trimmed := strings.TrimSpace(string(s.AdditionalProperties)); {
+	case trimmed == "false":
+		s.closed = true
+	case strings.HasPrefix(trimmed, "{"):
+		var additional schemaNode
+		if err := json.Unmarshal(s.AdditionalProperties, &additional); err != nil {
+			return types.ErrConfigurationf("invalid config schema: %v", err)
+		}
+		s.additional = &additional
+	}
+
+	children := []*schemaNode{s.additional, s.PropertyNames, s.Items}
+	for _, property := range s.Properties {
+		children = append(children, property)
+	}
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		if err := child.prepare(root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks a tree value against the node, collecting problems
+func (s *schemaNode) validate(value interface{}, key string, errs *[]fieldError) {
+	if s.Type != "" && !schemaTypeMatches(s.Type, value) {
+		*errs = append(*errs, fieldError{key, fmt.Sprintf("must be %s, got %s", withArticle(s.Type), schemaTypeOf(value))})
+		return
+	}
+
+	if len(s.Enum) > 0 && !enumContains(s.Enum, value) {
+		*errs = append(*errs, fieldError{key, fmt.Sprintf("must be one of %s, got %s", enumList(s.Enum), formatValue(value))})
+	}
+
+	if number, ok := value.(float64); ok {
+		if s.Minimum != nil && number < *s.Minimum {
+			*errs = append(*errs, fieldError{key, fmt.Sprintf("must be at least %v, got %v", *s.Minimum, number)})
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			*errs = append(*errs, fieldError{key, fmt.Sprintf("must be at most %v, got %v", *s.Maximum, number)})
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range sortedTreeKeys(v) {
+			path := joinPath(key, name)
+
+			if s.PropertyNames != nil && len(s.PropertyNames.Enum) > 0 && !enumContains(s.PropertyNames.Enum, name) {
+				kind := s.PropertyNames.Title
+				if kind == "" {
+					kind = "name"
+				}
+				*errs = append(*errs, fieldError{path, fmt.Sprintf("unknown %s %q (supported: %s)", kind, name, enumList(s.PropertyNames.Enum))})
+				continue
+			}
+
+			if property, ok := s.Properties[name]; ok {
+				property.validate(v[name], path, errs)
+				continue
+			}
+			if s.additional != nil {
+				s.additional.validate(v[name], path, errs)
+				continue
+			}
+			if s.closed {
+				message := "unknown key"
+				if suggestion := closestKey(name, s.Properties); suggestion != "" {
+					message += fmt.Sprintf(", did you mean %q?", suggestion)
+				}
+				*errs = append(*errs, fieldError{path, message})
+			}
+		}
+	case []interface{}:
+		if s.Items == nil {
+			return
+		}
+		for i, item := range v {
+			var itemErrs []fieldError
+			s.Items.validate(item, key, &itemErrs)
+			for _, itemErr := range itemErrs {
+				*errs = append(*errs, fieldError{itemErr.key, fmt.Sprintf("item %d %s", i+1, itemErr.message)})
+			}
+		}
+	}
+}
+
+// schemaTypeMatches reports whether a tree value has the JSON Schema type
+func schemaTypeMatches(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return schemaTypeOf(value) == schemaType
+	}
+}
+
+// schemaTypeOf names the JSON type of a tree value
+func schemaTypeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return reflect.TypeOf(value).String()
+	}
+}
+
+// withArticle prefixes a type name with "a" or "an"
+func withArticle(name string) string {
+	if strings.ContainsAny(name[:1], "aeiou") {
+		return "an " + name
+	}
+	return "a " + name
+}
+
+// enumContains reports whether value is one of the allowed values
+func enumContains(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// enumList formats allowed values for messages
+func enumList(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		values[i] = fmt.Sprint(value)
+	}
+	return strings.Join(values, ", ")
+}
+
+// formatValue formats a tree value for messages
+func formatValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// sortedTreeKeys returns the keys of a tree in order, for stable messages
+func sortedTreeKeys(tree map[string]interface{}) []string {
+	keys := make([]string, 0, len(tree))
+	for key := range tree {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// closestKey suggests a known key for a likely typo
+func closestKey(name string, properties map[string]*schemaNode) string {
+	best, bestDistance := "", 3
+	for candidate := range properties {
+		if distance := editDistance(name, candidate); distance < bestDistance ||
+			(distance == bestDistance && best != "" && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+// Validate checks the effective configuration against the schema and for
+// consistency, such as the default model being offered by the default
+// provider. Problems are reported with the file and line that set them.
+func (c *Config) Validate() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.validate()
+}
+
+// validate checks the configuration; the caller must hold c.mu
+func (c *Config) validate() error {
+	// The merged tree keeps unknown keys that decoding into Config drops
+	current, err := toTree(c)
+	if err != nil {
+		return err
+	}
+	tree := copyTree(c.merged)
+	mergeTree(tree, diffTree(c.loaded, current), "", "", nil, nil)
+
+	errs, err := schemaErrors(tree)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c.Providers[c.DefaultProvider]; !ok {
+		errs = append(errs, fieldError{"default_provider", fmt.Sprintf("provider %q is not configured", c.DefaultProvider)})
+	} else if models := c.Providers[c.DefaultProvider].Models; len(models) > 0 && c.DefaultModel != "" && !containsString(models, c.DefaultModel) {
+		errs = append(errs, fieldError{"default_model", fmt.Sprintf("model %q is not one of the models of provider %q (%s)",
+			c.DefaultModel, c.DefaultProvider, strings.Join(models, ", "))})
+	}
+
+	for i, pattern := range c.FileAccess.Denied {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fieldError{"file_access.denied", fmt.Sprintf("item %d %q is not a valid pattern", i+1, pattern)})
+		}
+	}
+
+	for i, allowed := range c.AllowedFileTypes {
+		if !strings.HasPrefix(allowed, ".") && !containsString(types.DefaultAllowedFileTypes, allowed) {
+			errs = append(errs, fieldError{"allowed_file_types", fmt.Sprintf("item %d %q is neither a file type (%s) nor an extension",
+				i+1, allowed, strings.Join(types.DefaultAllowedFileTypes, ", "))})
+		}
+	}
+
+	for i, pattern := range c.Redaction.Patterns {
+		if _, err := redact.CompilePattern(pattern); err != nil {
+			errs = append(errs, fieldError{"redaction.patterns", fmt.Sprintf("item %d %v", i+1, err)})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return c.report(errs)
+}
+
+// schemaErrors validates a configuration tree against the schema
+func schemaErrors(tree map[string]interface{}) ([]fieldError, error) {
+	schema, err := loadConfigSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []fieldError
+	schema.validate(tree, "", &errs)
+	return errs, nil
+}
+
+// report turns validation problems into one error listing each with the
+// position that set it
+func (c *Config) report(errs []fieldError) error {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = fmt.Sprintf("%s: %s: %s", c.position(e.key), e.key, e.message)
+	}
+	return types.ErrConfigurationf("invalid configuration:\n  %s", strings.Join(messages, "\n  "))
+}
+
+// position describes where a key was set, as "file:line" for files
+func (c *Config) position(key string) string {
+	// Values set as a whole, like lists, record their origin at the key
+	origin := ""
+	for path := key; path != ""; {
+		if o, ok := c.origins[path]; ok {
+			origin = o
+			break
+		}
+		index := strings.LastIndex(path, ".")
+		if index < 0 {
+			break
+		}
+		path = path[:index]
+	}
+	if origin == "" {
+		// Tables record their origin at the keys set in them
+		origin = c.originBelow(key)
+	}
+	if origin == "" {
+		return "config"
+	}
+
+	for _, layer := range c.layers {
+		if layer.origin != origin || layer.lines == nil {
+			continue
+		}
+		for path := key; path != ""; {
+			if line, ok := layer.lines[path]; ok {
+				return fmt.Sprintf("%s:%d", origin, line)
+			}
+			index := strings.LastIndex(path, ".")
+			if index < 0 {
+				break
+			}
+			path = path[:index]
+		}
+
+		// Tables set with dotted keys have no line of their own
+		first := 0
+		for path, line := range layer.lines {
+			if strings.HasPrefix(path, key+".") && (first == 0 || line < first) {
+				first = line
+			}
+		}
+		if first > 0 {
+			return fmt.Sprintf("%s:%d", origin, first)
+		}
+	}
+	return origin
+}
+
+// originBelow returns the origin of the first key set under a table
+func (c *Config) originBelow(key string) string {
+	var below []string
+	for path := range c.origins {
+		if strings.HasPrefix(path, key+".") {
+			below = append(below, path)
+		}
+	}
+	if len(below) == 0 {
+		return ""
+	}
+	sort.Strings(below)
+	return c.origins[below[0]]
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigErrorLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []string // "line: key: message" parts of the error
+	}{
+		{
+			name:    "json unknown key",
+			file:    ".craftcom.json",
+			content: "{\n  \"history_size\": 10,\n  \"histroy_size\": 20\n}\n",
+			want:    []string{`3: histroy_size: unknown key, did you mean "history_size"?`},
+		},
+		{
+			name:    "json enum",
+			file:    ".craftcom.json",
+			content: "{\n  \"safety_level\": \"paranoid\"\n}\n",
+			want:    []string{`2: safety_level: must be one of low, medium, high, got "paranoid"`},
+		},
+		{
+			name:    "json nested type",
+			file:    ".craftcom.json",
+			content: "{\n  \"providers\": {\n    \"gemini\": {\n      \"max_tokens\": \"lots\"\n    }\n  }\n}\n",
+			want:    []string{"4: providers.gemini.max_tokens: "},
+		},
+		{
+			name:    "yaml wrong type",
+			file:    ".craftcom.yaml",
+			content: "history_size: 10\nquiet: sometimes\n",
+			want:    []string{"2: quiet: "},
+		},
+		{
+			name:    "yaml nested",
+			file:    ".craftcom.yml",
+			content: "providers:\n  gemini:\n    enabled: true\n    modles: []\n",
+			want:    []string{`4: providers.gemini.modles: unknown key, did you mean "models"?`},
+		},
+		{
+			name:    "toml enum",
+			file:    ".craftcom.toml",
+			content: "history_size = 10\n\nsafety_level = \"paranoid\"\n",
+			want:    []string{`3: safety_level: must be one of low, medium, high, got "paranoid"`},
+		},
+		{
+			name:    "toml table",
+			file:    ".craftcom.toml",
+			content: "quiet = true\n\n[providers.gemini]\nmax_tokens = \"lots\"\n",
+			want:    []string{"4: providers.gemini.max_tokens: "},
+		},
+		{
+			name:    "toml table set as a whole",
+			file:    ".craftcom.toml",
+			content: "quiet = true\n\n[aliases]\nll = 3\n",
+			want:    []string{"4: aliases.ll: "},
+		},
+		{
+			name:    "toml unknown provider table",
+			file:    ".craftcom.toml",
+			content: "quiet = true\n\n[providers.openia]\nenabled = true\n",
+			want:    []string{"3: providers.openia: unknown provider"},
+		},
+		{
+			name:    "yaml unknown provider",
+			file:    ".craftcom.yaml",
+			content: "quiet: true\nproviders:\n  openia:\n    enabled: true\n",
+			want:    []string{"3: providers.openia: unknown provider"},
+		},
+		{
+			name:    "several errors",
+			file:    ".craftcom.json",
+			content: "{\n  \"quiet\": \"no\",\n  \"safety_level\": \"none\"\n}\n",
+			want:    []string{"2: quiet: ", "3: safety_level: "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home, _ := isolate(t)
+			path := filepath.Join(home, tt.file)
+			writeConfig(t, path, tt.content)
+
+			_, err := LoadConfigFromPath(path)
+			if err == nil {
+				t.Fatal("invalid config was loaded")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), fmt.Sprintf("%s:%s", path, want)) {
+					t.Errorf("error does not contain %q:\n%v", path+":"+want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestProjectConfigErrorLine(t *testing.T) {
+	home, project := isolate(t)
+	path := filepath.Join(project, ".craftcom.yaml")
+	writeConfig(t, path, "system_prompt: be brief\nsafety_level: 3\n")
+
+	_, err := LoadConfigFromPath(filepath.Join(home, ".craftcom.json"))
+	if err == nil || !strings.Contains(err.Error(), path+":2: safety_level: ") {
+		t.Errorf("err = %v, want it at %s:2", err, path)
+	}
+}
+
+func TestValidConfigFormats(t *testing.T) {
+	tests := map[string]string{
+		".craftcom.json": `{"safety_level": "high", "providers": {"gemini": {"max_tokens": 512}}}`,
+		".craftcom.yaml": "safety_level: high\nproviders:\n  gemini:\n    max_tokens: 512\n",
+		".craftcom.toml": "safety_level = \"high\"\n[providers.gemini]\nmax_tokens = 512\n",
+	}
+
+	for file, content := range tests {
+		t.Run(file, func(t *testing.T) {
+			home, _ := isolate(t)
+			path := filepath.Join(home, file)
+			writeConfig(t, path, content)
+
+			config, err := LoadConfigFromPath(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.SafetyLevel != "high" || config.Providers["gemini"].MaxTokens != 512 {
+				t.Errorf("safety_level = %q, providers = %+v", config.SafetyLevel, config.Providers)
+			}
+		})
+	}
+}
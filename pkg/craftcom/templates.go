@@ -0,0 +1,348 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package libterma
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"craftcom/pkg/types"
+)
+
+// Templates are named prompts or commands with parameters, run as
+// "craftcom @name args". They come from, later ones taking precedence:
+//
+//  1. the "aliases" setting, a prompt or a "!"-prefixed command per name
+//  2. files in the user config directory, ~/.config/craftcom/templates/
+//  3. files in the nearest project .craftcom/templates/ directory
+//
+// A template file is named after the template. JSON, YAML and TOML files
+// set "description" and either "prompt" or "command"; .md and .txt files
+// hold a prompt.
+
+const (
+	templatesDir = "templates"
+
+	// TemplateSourceConfig marks templates defined by the aliases setting
+	TemplateSourceConfig = "config"
+)
+
+// templateExtensions are the file types read as templates
+var templateExtensions = append([]string{".md", ".txt"}, configExtensions...)
+
+// placeholderPattern matches {name} and {name=default}
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_-]*)(?:=([^{}]*))?\}`)
+
+// safeShellArgument matches arguments that need no quoting in a command
+var safeShellArgument = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// Template is a reusable prompt or command
+type Template struct {
+	Name        string          `json:"name" yaml:"name"`
+	Description string          `json:"description,omitempty" yaml:"description,omitempty"`
+	Prompt      string          `json:"prompt,omitempty" yaml:"prompt,omitempty"`
+	Command     string          `json:"command,omitempty" yaml:"command,omitempty"`
+	Params      []TemplateParam `json:"params" yaml:"params"`
+	Source      string          `json:"source" yaml:"source"`
+}
+
+// TemplateParam is a {placeholder} of a template
+type TemplateParam struct {
+	Name     string `json:"name" yaml:"name"`
+	Default  string `json:"default,omitempty" yaml:"default,omitempty"`
+	Optional bool   `json:"optional" yaml:"optional"`
+}
+
+// newTemplate creates a template, finding its parameters in the text
+func newTemplate(name, description, prompt, command, source string) Template {
+	t := Template{
+		Name:        name,
+		Description: description,
+		Prompt:      prompt,
+		Command:     command,
+		Params:      []TemplateParam{},
+		Source:      source,
+	}
+
+	seen := make(map[string]bool)
+	text := t.text()
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(text, -1) {
+		if isShellVariable(text, match[0]) {
+			continue
+		}
+		param := text[match[2]:match[3]]
+		if seen[param] {
+			continue
+		}
+		seen[param] = true
+
+		p := TemplateParam{Name: param}
+		if match[4] >= 0 {
+			p.Default = text[match[4]:match[5]]
+			p.Optional = true
+		}
+		t.Params = append(t.Params, p)
+	}
+	return t
+}
+
+// IsCommand reports whether the template runs a fixed command instead of
+// sending a prompt
+func (t Template) IsCommand() bool {
+	return t.Command != ""
+}
+
+// Usage describes how to invoke the template, e.g.
+// "@deploy-logs <service> [since=1h]"
+func (t Template) Usage() string {
+	parts := []string{"@" + t.Name}
+	for _, p := range t.Params {
+		if p.Optional {
+			parts = append(parts, fmt.Sprintf("[%s=%s]", p.Name, p.Default))
+		} else {
+			parts = append(parts, "<"+p.Name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Expand fills in the parameters from positional arguments; an empty
+// argument keeps the default. The last parameter takes any remaining
+// arguments, so free text need not be quoted. Arguments to commands are
+// quoted for the shell.
+func (t Template) Expand(args []string) (string, error) {
+	if len(t.Params) == 0 && len(args) > 0 {
+		return "", types.ErrInputf("template %s takes no arguments", t.Name)
+	}
+	if len(args) > len(t.Params) {
+		last := len(t.Params) - 1
+		args = append(args[:last:last], strings.Join(args[last:], " "))
+	}
+
+	values := make(map[string]string, len(t.Params))
+	for i, p := range t.Params {
+		switch {
+		case i < len(args) && (args[i] != "" || !p.Optional):
+			values[p.Name] = args[i]
+		case p.Optional:
+			values[p.Name] = p.Default
+		default:
+			return "", types.ErrInputf("missing %s; usage: %s", p.Name, t.Usage())
+		}
+		if t.IsCommand() {
+			values[p.Name] = quoteShellArgument(values[p.Name])
+		}
+	}
+
+	text := t.text()
+	var expanded strings.Builder
+	last := 0
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(text, -1) {
+		if isShellVariable(text, match[0]) {
+			continue
+		}
+		expanded.WriteString(text[last:match[0]])
+		expanded.WriteString(values[text[match[2]:match[3]]])
+		last = match[1]
+	}
+	expanded.WriteString(text[last:])
+	return strings.TrimSpace(expanded.String()), nil
+}
+
+// text returns the prompt or command of the template
+func (t Template) text() string {
+	if t.IsCommand() {
+		return t.Command
+	}
+	return t.Prompt
+}
+
+// isShellVariable reports whether the braces at index belong to ${VAR}
+func isShellVariable(text string, index int) bool {
+	return index > 0 && text[index-1] == '$'
+}
+
+// quoteShellArgument single-quotes an argument unless it is a plain word
+func quoteShellArgument(value string) string {
+	if safeShellArgument.MatchString(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// Templates returns the defined templates sorted by name
+func (c *Config) Templates() ([]Template, error) {
+	c.mu.RLock()
+	aliases := make(map[string]string, len(c.Aliases))
+	for name, text := range c.Aliases {
+		aliases[name] = text
+	}
+	c.mu.RUnlock()
+
+	templates := make(map[string]Template)
+	for name, text := range aliases {
+		if command, ok := strings.CutPrefix(text, "!"); ok {
+			templates[name] = newTemplate(name, "", "", strings.TrimSpace(command), TemplateSourceConfig)
+		} else {
+			templates[name] = newTemplate(name, "", text, "", TemplateSourceConfig)
+		}
+	}
+
+	var dirs []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, layeredConfigDir, templatesDir))
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if dir := findProjectTemplates(wd); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := readTemplateDir(dir, templates); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]Template, len(names))
+	for i, name := range names {
+		result[i] = templates[name]
+	}
+	return result, nil
+}
+
+// Template returns the named template
+func (c *Config) Template(name string) (Template, error) {
+	templates, err := c.Templates()
+	if err != nil {
+		return Template{}, err
+	}
+
+	best, bestDistance := "", 3
+	for _, t := range templates {
+		if t.Name == name {
+			return t, nil
+		}
+		if distance := editDistance(name, t.Name); distance < bestDistance {
+			best, bestDistance = t.Name, distance
+		}
+	}
+
+	if best != "" {
+		return Template{}, types.ErrInputf("unknown template %q, did you mean %q?", name, best)
+	}
+	return Template{}, types.ErrInputf("unknown template %q", name)
+}
+
+// findProjectTemplates walks up from dir looking for .craftcom/templates,
+// stopping below the home directory like project config files
+func findProjectTemplates(dir string) string {
+	homeDir, _ := os.UserHomeDir()
+	for {
+		if homeDir != "" && samePath(dir, homeDir) {
+			return ""
+		}
+		candidate := filepath.Join(dir, projectConfigName, templatesDir)
+		if stat, err := os.Stat(candidate); err == nil && stat.IsDir() {
+			return candidate
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// readTemplateDir adds the templates in dir, replacing ones of the same
+// name. A missing directory has no templates.
+func readTemplateDir(dir string, templates map[string]Template) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return types.ErrConfigurationf("failed to read templates: %v", err)
+	}
+
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || !containsString(templateExtensions, ext) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		t, err := readTemplateFile(path)
+		if err != nil {
+			return err
+		}
+		templates[t.Name] = t
+	}
+	return nil
+}
+
+// readTemplateFile reads a template named after its file
+func readTemplateFile(path string) (Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Template{}, types.ErrConfigurationf("failed to read template: %v", err)
+	}
+
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	if ext := strings.ToLower(ext); ext == ".md" || ext == ".txt" {
+		return newTemplate(name, "", strings.TrimSpace(string(data)), "", path), nil
+	}
+
+	tree, lines, err := decodeConfig(path, data)
+	if err != nil {
+		return Template{}, err
+	}
+
+	fields := make(map[string]string)
+	for _, key := range sortedTreeKeys(tree) {
+		switch key {
+		case "description", "prompt", "command":
+			value, ok := tree[key].(string)
+			if !ok {
+				return Template{}, types.ErrConfigurationf("%s:%d: %s: must be a string", path, lines[key], key)
+			}
+			fields[key] = value
+		default:
+			return Template{}, types.ErrConfigurationf("%s:%d: %s: unknown key (expected description, prompt or command)", path, lines[key], key)
+		}
+	}
+
+	if (fields["prompt"] == "") == (fields["command"] == "") {
+		return Template{}, types.ErrConfigurationf("%s: template must set either prompt or command", path)
+	}
+	return newTemplate(name, fields["description"], fields["prompt"], fields["command"], path), nil
+}
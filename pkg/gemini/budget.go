@@ -0,0 +1,251 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"craftcom/pkg/media"
+	"craftcom/pkg/pdf"
+	"craftcom/pkg/types"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Tokens the API counts for media, from the Gemini documentation
+const (
+	tokensPerImage       = 258
+	tokensPerPDFPage     = 258
+	tokensPerAudioSecond = 32
+	tokensPerVideoSecond = 263
+)
+
+// Context levels, each leaving out more of the context sent before a
+// request to fit the model's input token limit
+const (
+	contextFull        = iota // Everything
+	contextNoCollected        // Without project, git and tool information
+	contextMinimal            // Also without the last command's output
+)
+
+// systemAcknowledgement answers the system prompt, which is sent as the
+// first turn of the conversation
+const systemAcknowledgement = "Understood."
+
+// calibrateAbove is the share of the limit, in percent, above which the
+// estimates of a request are checked with the API's tokenizer
+const calibrateAbove = 75
+
+// requestItem is an attachment and the tokens it is estimated to use:
+// those of its text, or of media
+type requestItem struct {
+	name   string
+	text   string
+	tokens int
+}
+
+// partItem describes a part of a request as an attachment
+func partItem(name string, part genai.Part) requestItem {
+	if text, ok := part.(genai.Text); ok {
+		return requestItem{name: name, text: string(text)}
+	}
+	return requestItem{name: name, tokens: partTokens(part, types.EstimateTokens)}
+}
+
+// fittedRequest is a request that fits the model's input token limit
+type fittedRequest struct {
+	history []*genai.Content // System prompt and the turns kept
+	prompt  string           // Context and request, redacted
+	dropped int              // Turns of the conversation left out
+	level   int              // Context level used
+}
+
+// fitRequest checks a request fits the model's input token limit before
+// it is sent. Text is estimated, and once a request nears the limit its
+// text is counted with the API's tokenizer to correct the estimates. The
+// oldest turns of the conversation are left out first, then parts of the
+// context. When it still does not fit, the error breaks down what uses
+// the limit.
+func (c *Chat) fitRequest(ctx context.Context, message string, attachments []requestItem) (fittedRequest, error) {
+	limit := c.modelConfig.InputTokenLimit
+	textTokens := types.EstimateTokens
+	calibrated := false
+
+	turns := c.history
+	fitted := fittedRequest{level: contextFull}
+	fitted.prompt = c.redact(c.addContext(ctx, message, fitted.level))
+	for {
+		items := append([]requestItem{
+			{name: "request and context", text: fitted.prompt},
+			{name: "system prompt", text: c.systemText()},
+		}, attachments...)
+		total := contentTokens(turns, textTokens)
+		for i := range items {
+			items[i].tokens += textTokens(items[i].text)
+			total += items[i].tokens
+		}
+
+		switch {
+		case limit > 0 && total > limit*calibrateAbove/100 && !calibrated:
+			calibrated = true
+			textTokens = c.calibrate(ctx, turns, items)
+		case limit <= 0 || total <= limit:
+			fitted.history = c.requestHistory(turns)
+			fitted.dropped = len(c.history) - len(turns)
+			return fitted, nil
+		case len(turns) > 0:
+			// Turns are kept in pairs of request and response
+			turns = turns[min(2, len(turns)):]
+		case fitted.level < contextMinimal:
+			fitted.level++
+			fitted.prompt = c.redact(c.addContext(ctx, message, fitted.level))
+		default:
+			return fittedRequest{}, budgetError(c.modelConfig.Name, limit, total, items)
+		}
+	}
+}
+
+// systemText returns the text of the turns the system prompt is sent as
+func (c *Chat) systemText() string {
+	if c.systemPrompt == "" {
+		return ""
+	}
+	return c.systemPrompt + systemAcknowledgement
+}
+
+// calibrate counts the text of a request with the API's tokenizer and
+// returns an estimator scaled to match it. The estimator is unchanged
+// when the text cannot be counted.
+func (c *Chat) calibrate(ctx context.Context, turns []genai.Content, items []requestItem) func(string) int {
+	var texts []string
+	for _, turn := range turns {
+		texts = append(texts, partsText(turn.Parts))
+	}
+	for _, item := range items {
+		texts = append(texts, item.text)
+	}
+
+	estimated := 0
+	for _, text := range texts {
+		estimated += types.EstimateTokens(text)
+	}
+	if c.count == nil || estimated == 0 {
+		return types.EstimateTokens
+	}
+	counted, err := c.count(ctx, strings.Join(texts, "\n"))
+	if err != nil || counted == 0 {
+		return types.EstimateTokens
+	}
+
+	return func(text string) int {
+		return types.EstimateTokens(text) * counted / estimated
+	}
+}
+
+// requestHistory returns the system prompt and turns to send before a
+// request. The API has no system role, so the system prompt is the first
+// turn.
+func (c *Chat) requestHistory(turns []genai.Content) []*genai.Content {
+	history := make([]*genai.Content, 0, len(turns)+2)
+	if c.systemPrompt != "" {
+		history = append(history,
+			&genai.Content{Role: "user", Parts: []genai.Part{genai.Text(c.systemPrompt)}},
+			&genai.Content{Role: "model", Parts: []genai.Part{genai.Text(systemAcknowledgement)}},
+		)
+	}
+	for i := range turns {
+		history = append(history, &turns[i])
+	}
+	return history
+}
+
+// budgetError explains what uses the input token limit of a request that
+// does not fit it, largest first
+func budgetError(model string, limit, total int, items []requestItem) error {
+	sort.SliceStable(items, func(i, j int) bool { return items[i].tokens > items[j].tokens })
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "the request is about %d tokens, over the %s input limit of %d:", total, model, limit)
+	for _, item := range items {
+		if item.tokens > 0 {
+			fmt.Fprintf(&b, "\n  %s: %d", item.name, item.tokens)
+		}
+	}
+	b.WriteString("\nattach less, or ask about a part at a time")
+	return types.ErrInputf("%s", b.String())
+}
+
+// contentTokens estimates the tokens of turns of a conversation, those of
+// text with textTokens
+func contentTokens(turns []genai.Content, textTokens func(string) int) int {
+	tokens := 0
+	for _, turn := range turns {
+		for _, part := range turn.Parts {
+			tokens += partTokens(part, textTokens)
+		}
+	}
+	return tokens
+}
+
+// partTokens estimates the tokens of a part of a request, those of text
+// with textTokens
+func partTokens(part genai.Part, textTokens func(string) int) int {
+	switch v := part.(type) {
+	case genai.Text:
+		return textTokens(string(v))
+	case genai.Blob:
+		switch {
+		case strings.HasPrefix(v.MIMEType, "image/"):
+			return tokensPerImage
+		case v.MIMEType == "application/pdf":
+			pages, err := pdf.PageCount(v.Data)
+			if err != nil {
+				return 0
+			}
+			return pages * tokensPerPDFPage
+		case strings.HasPrefix(v.MIMEType, "audio/"):
+			return mediaTokens(v.Data, tokensPerAudioSecond)
+		case strings.HasPrefix(v.MIMEType, "video/"):
+			return mediaTokens(v.Data, tokensPerVideoSecond)
+		}
+	}
+	return 0
+}
+
+// mediaContentTokens estimates the tokens of audio or video content
+func mediaContentTokens(content *types.FileContent) int {
+	if content.Type == types.FileTypeVideo {
+		return mediaTokens(content.Data, tokensPerVideoSecond)
+	}
+	return mediaTokens(content.Data, tokensPerAudioSecond)
+}
+
+// mediaTokens estimates the tokens of media from its length. Media that
+// cannot be probed is left to the API to check.
+func mediaTokens(data []byte, perSecond int) int {
+	duration, err := media.Duration(data)
+	if err != nil {
+		return 0
+	}
+	return int(duration.Seconds() * float64(perSecond))
+}
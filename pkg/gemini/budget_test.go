@@ -0,0 +1,266 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gemini
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"craftcom/pkg/types"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// textOf returns text estimated at about tokens tokens
+func textOf(tokens int) string {
+	const word = "lorem ipsum "
+	per100 := types.EstimateTokens(strings.Repeat(word, 100))
+	return strings.Repeat(word, tokens*100/per100+1)
+}
+
+// collected is a context collector returning fixed text
+type collected string
+
+func (c collected) Collect(ctx context.Context, request string) string {
+	return string(c)
+}
+
+// testChat returns a chat with pairs of turns of about turnTokens tokens
+// each
+func testChat(limit, pairs, turnTokens int) *Chat {
+	chat := &Chat{
+		modelConfig:    ModelConfig{Name: "test-model", InputTokenLimit: limit},
+		systemPrompt:   "Be brief.",
+		currentContext: &ChatContext{WorkingDir: "/src", SystemInfo: types.SystemInfo{OS: "linux", Shell: "bash"}},
+	}
+	for i := 0; i < pairs; i++ {
+		chat.history = append(chat.history,
+			genai.Content{Role: "user", Parts: []genai.Part{genai.Text(fmt.Sprintf("request %d %s", i, textOf(turnTokens)))}},
+			genai.Content{Role: "model", Parts: []genai.Part{genai.Text(fmt.Sprintf("answer %d %s", i, textOf(turnTokens)))}},
+		)
+	}
+	return chat
+}
+
+func TestFitRequestKeepsRequestsThatFit(t *testing.T) {
+	chat := testChat(10000, 2, 100)
+	counts := 0
+	chat.count = func(ctx context.Context, text string) (int, error) {
+		counts++
+		return types.EstimateTokens(text), nil
+	}
+
+	fitted, err := chat.fitRequest(context.Background(), "list files", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fitted.dropped != 0 || fitted.level != contextFull {
+		t.Errorf("dropped %d turns at level %d, want none at full context", fitted.dropped, fitted.level)
+	}
+	// The system prompt is sent as the first two turns
+	if len(fitted.history) != 6 {
+		t.Errorf("history has %d turns, want 6", len(fitted.history))
+	}
+	if !strings.Contains(fitted.prompt, "User request: list files") || !strings.Contains(fitted.prompt, "Current directory: /src") {
+		t.Errorf("prompt = %q, want the request with its context", fitted.prompt)
+	}
+	// Requests well below the limit are not counted
+	if counts != 0 {
+		t.Errorf("counted tokens %d times, want none", counts)
+	}
+}
+
+func TestFitRequestDropsOldestTurns(t *testing.T) {
+	chat := testChat(600, 3, 200)
+
+	fitted, err := chat.fitRequest(context.Background(), "list files", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fitted.dropped != 4 {
+		t.Fatalf("dropped %d turns, want 4", fitted.dropped)
+	}
+	if len(fitted.history) != 4 {
+		t.Fatalf("history has %d turns, want the system prompt and the last pair", len(fitted.history))
+	}
+	if text := partsText(fitted.history[2].Parts); !strings.HasPrefix(text, "request 2 ") {
+		t.Errorf("first turn kept is %.20q, want the last request", text)
+	}
+	if fitted.level != contextFull {
+		t.Errorf("context level %d, want full context", fitted.level)
+	}
+}
+
+func TestFitRequestReducesContext(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		wantLevel int
+	}{
+		{"full context", 6000, contextFull},
+		{"without collected context", 1600, contextNoCollected},
+		{"without the last output", 400, contextMinimal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat := testChat(tt.limit, 1, 50)
+			chat.collector = collected("Project: Go module\n" + textOf(3000))
+			chat.currentContext.LastOutput = "total 0\n" + textOf(1000)
+
+			fitted, err := chat.fitRequest(context.Background(), "list files", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fitted.level != tt.wantLevel {
+				t.Errorf("context level %d, want %d", fitted.level, tt.wantLevel)
+			}
+			// The conversation goes before any of the context
+			if fitted.dropped != 2 && tt.wantLevel != contextFull {
+				t.Errorf("dropped %d turns, want 2", fitted.dropped)
+			}
+			if got := strings.Contains(fitted.prompt, "Project: Go module"); got != (tt.wantLevel == contextFull) {
+				t.Errorf("collected context sent: %v", got)
+			}
+			if got := strings.Contains(fitted.prompt, "total 0"); got != (tt.wantLevel < contextMinimal) {
+				t.Errorf("last output sent: %v", got)
+			}
+			if !strings.Contains(fitted.prompt, "User request: list files") {
+				t.Error("the request was left out")
+			}
+		})
+	}
+}
+
+func TestFitRequestTooLarge(t *testing.T) {
+	chat := testChat(1000, 1, 50)
+	attachments := []requestItem{
+		{name: "notes.txt", text: textOf(300)},
+		{name: "big.log", text: textOf(5000)},
+		{name: "photo.png", tokens: tokensPerImage},
+	}
+
+	_, err := chat.fitRequest(context.Background(), "what failed", attachments)
+	if !types.IsErrorType(err, types.ErrInput) {
+		t.Fatalf("error = %v, want an input error", err)
+	}
+
+	message := err.Error()
+	if !strings.Contains(message, "over the test-model input limit of 1000") {
+		t.Errorf("error does not name the limit:\n%s", message)
+	}
+	// What uses the limit is listed largest first
+	order := []string{"big.log: ", "notes.txt: ", "photo.png: 258", "request and context: "}
+	last := -1
+	for _, name := range order {
+		index := strings.Index(message, name)
+		if index < 0 || index < last {
+			t.Errorf("%q missing or out of order in:\n%s", name, message)
+		}
+		last = index
+	}
+}
+
+func TestFitRequestCalibrates(t *testing.T) {
+	tests := []struct {
+		name        string
+		scale       int
+		countErr    error
+		wantDropped int
+	}{
+		{"tokenizer counts more", 2, nil, 2},
+		{"tokenizer agrees", 1, nil, 0},
+		{"counting fails", 2, errors.New("offline"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The estimate is over 75% of the limit but fits
+			chat := testChat(1200, 2, 230)
+			counts := 0
+			chat.count = func(ctx context.Context, text string) (int, error) {
+				counts++
+				return types.EstimateTokens(text) * tt.scale, tt.countErr
+			}
+
+			fitted, err := chat.fitRequest(context.Background(), "list files", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if counts != 1 {
+				t.Errorf("counted tokens %d times, want once", counts)
+			}
+			if fitted.dropped != tt.wantDropped {
+				t.Errorf("dropped %d turns, want %d", fitted.dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestFitRequestWithoutLimit(t *testing.T) {
+	chat := testChat(0, 3, 5000)
+	fitted, err := chat.fitRequest(context.Background(), "list files", []requestItem{{name: "big.log", text: textOf(100000)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fitted.dropped != 0 {
+		t.Errorf("dropped %d turns without a limit", fitted.dropped)
+	}
+}
+
+func TestFitRequestPlain(t *testing.T) {
+	chat := testChat(10000, 0, 0)
+	chat.plain = true
+	chat.collector = collected("Project: Go module")
+
+	fitted, err := chat.fitRequest(context.Background(), "summarize this", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fitted.prompt != "summarize this" {
+		t.Errorf("prompt = %q, want the message alone", fitted.prompt)
+	}
+}
+
+func TestPartTokens(t *testing.T) {
+	double := func(text string) int { return 2 * len(text) }
+	tests := []struct {
+		name string
+		part genai.Part
+		want int
+	}{
+		{"text", genai.Text("abcd"), 8},
+		{"image", genai.Blob{MIMEType: "image/png", Data: []byte{1}}, tokensPerImage},
+		{"unreadable pdf", genai.Blob{MIMEType: "application/pdf", Data: []byte("not a pdf")}, 0},
+		{"unprobed audio", genai.Blob{MIMEType: "audio/mpeg", Data: []byte{1, 2, 3}}, 0},
+		{"other", genai.Blob{MIMEType: "application/zip", Data: []byte{1}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := partTokens(tt.part, double); got != tt.want {
+				t.Errorf("partTokens() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
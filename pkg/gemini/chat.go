@@ -23,11 +23,11 @@ package gemini
 import (
 	"context"
 	"fmt"
-	"os"
 	"regexp"
 	"strings"
 	"time"
 
+	"craftcom/pkg/pdf"
 	"craftcom/pkg/types"
 	"github.com/google/generative-ai-go/genai"
 )
@@ -35,13 +35,19 @@ import (
 // Chat represents a chat session with the Gemini model
 type Chat struct {
 	model          *genai.GenerativeModel
+	count          types.TokenCounter // The model's tokenizer
 	chat           *genai.ChatSession
 	modelConfig    ModelConfig
 	rateLimiter    *RateLimiter
 	history        []genai.Content
+	systemPrompt   string
 	safetySettings []*genai.SafetySetting
 	fileProcessor  *types.FileReader
 	currentContext *ChatContext
+	collector      types.ContextCollector
+	redactor       types.Redactor
+	apiKey         string
+	plain          bool // Requests are sent without context
 }
 
 // ChatContext maintains the current conversation context
@@ -81,16 +87,18 @@ func (c *Chat) Send(ctx context.Context, message string) (types.Response, error)
 		return types.Response{}, err
 	}
 
-	// Add context to message
-	contextualMessage := c.addContext(message)
+	// Add context to message, within the model's input token limit
+	fitted, err := c.fitRequest(ctx, message, nil)
+	if err != nil {
+		return types.Response{}, err
+	}
 
 	// Create prompt parts
 	parts := []genai.Part{
-		genai.Text(contextualMessage),
+		genai.Text(fitted.prompt),
 	}
 
-	// Generate content instead of using SendMessage
-	resp, err := c.model.GenerateContent(ctx, parts...)
+	resp, usage, err := c.generate(ctx, fitted.history, parts, nil)
 	if err != nil {
 		c.currentContext.ErrorCount++
 		return types.Response{}, types.ErrExecutionf("failed to generate content: %v", err)
@@ -109,10 +117,11 @@ func (c *Chat) Send(ctx context.Context, message string) (types.Response, error)
 
 	// Extract command and output
 	command, fullOutput := c.extractCommandAndOutput(*candidate.Content)
+	command, fullOutput = c.restore(command), c.restore(fullOutput)
 
-	// Update history
+	// Update history; the context is sent fresh with each request
 	c.history = append(c.history,
-		genai.Content{Parts: []genai.Part{genai.Text(contextualMessage)}, Role: "user"},
+		genai.Content{Parts: []genai.Part{genai.Text(c.redact(message))}, Role: "user"},
 		*candidate.Content,
 	)
 
@@ -121,24 +130,24 @@ func (c *Chat) Send(ctx context.Context, message string) (types.Response, error)
 	c.currentContext.LastModified = time.Now()
 	c.currentContext.CommandCount++
 
-	// Estimate token usage
-	tokenCount := estimateTokenCount(contextualMessage + fullOutput)
-	if err := c.rateLimiter.TrackTokens(tokenCount); err != nil {
+	// Track token usage
+	if err := c.rateLimiter.TrackTokens(usage.total()); err != nil {
 		return types.Response{}, err
 	}
 
 	return types.Response{
 		Code:       command,
 		FullOutput: fullOutput,
-		Metadata: map[string]interface{}{
-			"model":          c.modelConfig.Name,
-			"timestamp":      time.Now(),
-			"context":        c.currentContext,
-			"tokens_used":    tokenCount,
-			"command_count":  c.currentContext.CommandCount,
-			"error_count":    c.currentContext.ErrorCount,
-			"session_length": time.Since(c.currentContext.SessionStart).Minutes(),
-		},
+		Metadata: usage.metadata(map[string]interface{}{
+			"model":           c.modelConfig.Name,
+			"timestamp":       time.Now(),
+			"context":         c.currentContext,
+			"command_count":   c.currentContext.CommandCount,
+			"error_count":     c.currentContext.ErrorCount,
+			"session_length":  time.Since(c.currentContext.SessionStart).Minutes(),
+			"history_dropped": fitted.dropped,
+			"context_reduced": fitted.level > contextFull,
+		}),
 	}, nil
 }
 
@@ -150,43 +159,57 @@ func (c *Chat) initializeChat(ctx context.Context, systemPrompt string) error {
 	}
 	c.currentContext = chatContext
 
-	// Initialize history with system prompt
-	if systemPrompt != "" {
-		c.history = []genai.Content{
-			{
-				Parts: []genai.Part{genai.Text(systemPrompt)},
-				Role:  "system",
-			},
-		}
-	}
+	// The system prompt is sent before the history with each request
+	c.systemPrompt = systemPrompt
 
 	return nil
 }
 
 // SendWithFiles sends a message with file attachments
 func (c *Chat) SendWithFiles(ctx context.Context, message string, files []string) (types.Response, error) {
+	return c.SendWithAttachments(ctx, message, files, nil)
+}
+
+// SendWithAttachments sends a message with file attachments and in-memory content
+func (c *Chat) SendWithAttachments(ctx context.Context, message string, files []string, contents []*types.FileContent) (types.Response, error) {
 	if err := c.rateLimiter.CheckLimit(); err != nil {
 		return types.Response{}, err
 	}
 
-	var parts []genai.Part
-	parts = append(parts, genai.Text(c.addContext(message)))
-
-	// Process files
-	processedFiles := make([]string, 0, len(files))
-	totalSize := int64(0)
-
+	// Read the files, then handle them with the in-memory content
+	all := make([]*types.FileContent, 0, len(files)+len(contents))
+	names := make([]string, 0, len(files)+len(contents))
 	for _, file := range files {
-		// Check file exists
-		if _, err := os.Stat(file); err != nil {
-			return types.Response{}, types.ErrInputf("file not found: %s", file)
-		}
-
-		// Process file
 		content, err := c.fileProcessor.ReadFile(ctx, file)
 		if err != nil {
 			return types.Response{}, types.ErrInputf("failed to process file %s: %v", file, err)
 		}
+		all = append(all, content)
+		names = append(names, file)
+	}
+	for _, content := range contents {
+		all = append(all, content)
+		names = append(names, content.Name)
+	}
+
+	processedFiles := make([]string, 0, len(all))
+	totalSize := int64(0)
+
+	// Large media is uploaded and referred to by URI, once the request is
+	// known to fit
+	var attached []genai.Part
+	var items []requestItem
+	var toUpload []*types.FileContent
+	for i, content := range all {
+		if isMedia(content) && len(content.Data) > inlineMediaLimit {
+			if err := c.checkMedia(content); err != nil {
+				return types.Response{}, err
+			}
+			toUpload = append(toUpload, content)
+			items = append(items, requestItem{name: names[i], tokens: mediaContentTokens(content)})
+			processedFiles = append(processedFiles, names[i])
+			continue
+		}
 
 		// Check total size
 		totalSize += content.Size
@@ -201,13 +224,34 @@ func (c *Chat) SendWithFiles(ctx context.Context, message string, files []string
 		}
 
 		if part != nil {
-			parts = append(parts, part)
-			processedFiles = append(processedFiles, file)
+			attached = append(attached, part)
+			items = append(items, partItem(names[i], part))
+			processedFiles = append(processedFiles, names[i])
 		}
 	}
 
+	fitted, err := c.fitRequest(ctx, message, items)
+	if err != nil {
+		return types.Response{}, err
+	}
+	parts := append([]genai.Part{genai.Text(fitted.prompt)}, attached...)
+
+	var uploads []*uploadedFile
+	defer func() {
+		if len(uploads) > 0 {
+			c.deleteFiles(uploads)
+		}
+	}()
+	for _, content := range toUpload {
+		upload, err := c.uploadMedia(ctx, content)
+		if err != nil {
+			return types.Response{}, err
+		}
+		uploads = append(uploads, upload)
+	}
+
 	// Generate response with files
-	resp, err := c.model.GenerateContent(ctx, parts...)
+	resp, usage, err := c.generate(ctx, fitted.history, parts, uploads)
 	if err != nil {
 		c.currentContext.ErrorCount++
 		return types.Response{}, types.ErrExecutionf("failed to generate content: %v", err)
@@ -225,6 +269,7 @@ func (c *Chat) SendWithFiles(ctx context.Context, message string, files []string
 	}
 
 	command, fullOutput := c.extractCommandAndOutput(*candidate.Content)
+	command, fullOutput = c.restore(command), c.restore(fullOutput)
 
 	// Update context
 	c.currentContext.LastCommand = command
@@ -232,46 +277,81 @@ func (c *Chat) SendWithFiles(ctx context.Context, message string, files []string
 	c.currentContext.CommandCount++
 
 	// Track token usage
-	tokenCount := estimateTokenCount(message + fullOutput)
-	if err := c.rateLimiter.TrackTokens(tokenCount); err != nil {
+	if err := c.rateLimiter.TrackTokens(usage.total()); err != nil {
 		return types.Response{}, err
 	}
 
 	return types.Response{
 		Code:       command,
 		FullOutput: fullOutput,
-		Metadata: map[string]interface{}{
-			"model":          c.modelConfig.Name,
-			"timestamp":      time.Now(),
-			"files":          processedFiles,
-			"context":        c.currentContext,
-			"tokens_used":    tokenCount,
-			"command_count":  c.currentContext.CommandCount,
-			"error_count":    c.currentContext.ErrorCount,
-			"session_length": time.Since(c.currentContext.SessionStart).Minutes(),
-		},
+		Metadata: usage.metadata(map[string]interface{}{
+			"model":           c.modelConfig.Name,
+			"timestamp":       time.Now(),
+			"files":           processedFiles,
+			"history_dropped": fitted.dropped,
+			"context_reduced": fitted.level > contextFull,
+			"context":         c.currentContext,
+			"command_count":   c.currentContext.CommandCount,
+			"error_count":     c.currentContext.ErrorCount,
+			"session_length":  time.Since(c.currentContext.SessionStart).Minutes(),
+		}),
 	}, nil
 }
 
 // Helper functions
 
-func (c *Chat) addContext(message string) string {
+func (c *Chat) addContext(ctx context.Context, message string, level int) string {
+	if c.plain {
+		return message
+	}
+
+	// Project, git and tool information, when enabled
+	collected := ""
+	if c.collector != nil && level < contextNoCollected {
+		if text := c.collector.Collect(ctx, message); text != "" {
+			collected = text + "\n"
+		}
+	}
+
+	lastOutput := c.currentContext.LastOutput
+	if level >= contextMinimal {
+		lastOutput = ""
+	}
+
 	return fmt.Sprintf(`Current directory: %s
 Last command: %s
 Last output: %s
 OS: %s
 Shell: %s
-
+%s
 User request: %s`,
 		c.currentContext.WorkingDir,
 		c.currentContext.LastCommand,
-		c.currentContext.LastOutput,
+		lastOutput,
 		c.currentContext.SystemInfo.OS,
 		c.currentContext.SystemInfo.Shell,
+		collected,
 		message,
 	)
 }
 
+// redact replaces secrets and personal data in text about to be sent
+func (c *Chat) redact(text string) string {
+	if c.redactor == nil {
+		return text
+	}
+	return c.redactor.Redact(text)
+}
+
+// restore puts redacted values back in text from the model, so commands
+// work as suggested
+func (c *Chat) restore(text string) string {
+	if c.redactor == nil {
+		return text
+	}
+	return c.redactor.Restore(text)
+}
+
 func (c *Chat) extractCommandAndOutput(content genai.Content) (string, string) {
 	var command, fullOutput string
 
@@ -375,15 +455,16 @@ func isValidCommand(cmd string) bool {
 func (c *Chat) createPartFromContent(content *types.FileContent) (genai.Part, error) {
 	switch content.Type {
 	case types.FileTypeImage:
-		return genai.ImageData(content.MimeType, content.Data), nil
+		return genai.Blob{MIMEType: content.MimeType, Data: content.Data}, nil
 	case types.FileTypeText:
-		return genai.Text(content.String()), nil
+		return genai.Text(c.redact(fmt.Sprintf("Contents of %s:\n%s", content.Name, content.String()))), nil
 	case types.FileTypePDF:
-		text, err := extractTextFromPDF(content.Data)
-		if err != nil {
-			return nil, types.ErrInputf("failed to extract text from PDF: %v", err)
+		return c.pdfPart(content)
+	case types.FileTypeAudio, types.FileTypeVideo:
+		if err := c.checkMedia(content); err != nil {
+			return nil, err
 		}
-		return genai.Text(text), nil
+		return genai.Blob{MIMEType: mediaMimeType(content), Data: content.Data}, nil
 	default:
 		return nil, types.ErrInputf("unsupported file type: %s", content.Type)
 	}
@@ -422,20 +503,19 @@ func extractCommandFromText(text string) string {
 	return ""
 }
 
-func estimateTokenCount(text string) int {
-	words := len(strings.Fields(text))
-	characters := len(text)
-
-	wordBasedEstimate := float64(words) * 1.3
-	charBasedEstimate := float64(characters) / 4.0
-
-	return int((wordBasedEstimate + charBasedEstimate) / 2)
-}
+// pdfPart sends a PDF as a document when the model reads PDFs itself and
+// it is sent whole and unredacted, and as its extracted text otherwise
+func (c *Chat) pdfPart(content *types.FileContent) (genai.Part, error) {
+	pages, _ := content.Metadata["pages"].(string)
+	if c.modelConfig.HasFeature("pdf") && pages == "" && c.redactor == nil {
+		return genai.Blob{MIMEType: "application/pdf", Data: content.Data}, nil
+	}
 
-// extractTextFromPDF extracts text content from PDF data
-func extractTextFromPDF(data []byte) (string, error) {
-	// TODO: Implement PDF text extraction
-	return "", types.ErrInputf("PDF processing not implemented")
+	text, err := pdf.ExtractText(content.Data, pages)
+	if err != nil {
+		return nil, types.ErrInputf("failed to extract text from %s: %v", content.Name, err)
+	}
+	return genai.Text(c.redact(fmt.Sprintf("Contents of %s:\n%s", content.Name, text))), nil
 }
 
 // Close cleans up resources
@@ -0,0 +1,402 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gemini
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"craftcom/pkg/media"
+	"craftcom/pkg/types"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// The client library predates the File API and usage metadata, so uploads
+// and generation use the REST API directly
+const (
+	apiHost    = "generativelanguage.googleapis.com"
+	apiBaseURL = "https://" + apiHost
+
+	// inlineMediaLimit is the largest media sent inline. Requests are
+	// limited to 20MB and inline data grows by a third when encoded.
+	inlineMediaLimit = 14 << 20
+
+	filePollInterval   = 2 * time.Second
+	fileProcessTimeout = 10 * time.Minute
+)
+
+// mediaMimeTypes are the MIME types the API expects for media files
+var mediaMimeTypes = map[string]string{
+	".mp3":  "audio/mp3",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".m4a":  "audio/aac",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mov":  "video/mov",
+}
+
+// uploadedFile is media stored with the File API
+type uploadedFile struct {
+	Name     string `json:"name"`
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	State    string `json:"state"`
+	Error    *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// isMedia reports whether content is audio or video
+func isMedia(content *types.FileContent) bool {
+	return content.Type == types.FileTypeAudio || content.Type == types.FileTypeVideo
+}
+
+// mediaMimeType returns the MIME type media is sent with
+func mediaMimeType(content *types.FileContent) string {
+	if ext, ok := content.Metadata["extension"].(string); ok {
+		if mimeType, ok := mediaMimeTypes[ext]; ok {
+			return mimeType
+		}
+	}
+	return content.MimeType
+}
+
+// checkMedia enforces the model's support for audio and video and the
+// length it accepts, read from the file's headers
+func (c *Chat) checkMedia(content *types.FileContent) error {
+	feature, limit := "audio", c.modelConfig.MaxAudioLength
+	if content.Type == types.FileTypeVideo {
+		feature, limit = "video", c.modelConfig.MaxVideoLength
+	}
+	if !c.modelConfig.HasFeature(feature) {
+		return types.ErrInputf("%s does not accept %s: %s", c.modelConfig.Name, feature, content.Name)
+	}
+
+	duration, err := media.Duration(content.Data)
+	if err != nil {
+		// The API rejects what cannot be checked here
+		return nil
+	}
+	if max := time.Duration(limit) * time.Second; limit > 0 && duration > max {
+		return types.ErrInputf("%s is %s long, %s accepts %s of at most %s",
+			content.Name, duration.Round(time.Second), c.modelConfig.Name, feature, max)
+	}
+	return nil
+}
+
+// uploadMedia uploads audio or video through the File API and waits until
+// it has been processed
+func (c *Chat) uploadMedia(ctx context.Context, content *types.FileContent) (*uploadedFile, error) {
+	mimeType := mediaMimeType(content)
+
+	// Start a resumable upload, then send the data in one request
+	metadata, _ := json.Marshal(map[string]interface{}{
+		"file": map[string]string{"display_name": content.Name},
+	})
+	resp, err := c.apiRequest(ctx, http.MethodPost, apiBaseURL+"/upload/v1beta/files", bytes.NewReader(metadata), map[string]string{
+		"Content-Type":                        "application/json",
+		"X-Goog-Upload-Protocol":              "resumable",
+		"X-Goog-Upload-Command":               "start",
+		"X-Goog-Upload-Header-Content-Length": strconv.FormatInt(int64(len(content.Data)), 10),
+		"X-Goog-Upload-Header-Content-Type":   mimeType,
+	})
+	if err != nil {
+		return nil, types.ErrExecutionf("failed to upload %s: %v", content.Name, err)
+	}
+	resp.Body.Close()
+	uploadURL := resp.Header.Get("X-Goog-Upload-URL")
+	if uploadURL == "" {
+		return nil, types.ErrExecutionf("failed to upload %s: no upload URL returned", content.Name)
+	}
+
+	resp, err = c.apiRequest(ctx, http.MethodPost, uploadURL, bytes.NewReader(content.Data), map[string]string{
+		"X-Goog-Upload-Offset":  "0",
+		"X-Goog-Upload-Command": "upload, finalize",
+	})
+	if err != nil {
+		return nil, types.ErrExecutionf("failed to upload %s: %v", content.Name, err)
+	}
+	var result struct {
+		File uploadedFile `json:"file"`
+	}
+	err = decodeResponse(resp, &result)
+	if err != nil {
+		return nil, types.ErrExecutionf("failed to upload %s: %v", content.Name, err)
+	}
+
+	file := &result.File
+	if err := c.waitForFile(ctx, file); err != nil {
+		c.deleteFiles([]*uploadedFile{file})
+		return nil, types.ErrExecutionf("failed to process %s: %v", content.Name, err)
+	}
+	return file, nil
+}
+
+// waitForFile polls an uploaded file until the service has processed it
+func (c *Chat) waitForFile(ctx context.Context, file *uploadedFile) error {
+	ctx, cancel := context.WithTimeout(ctx, fileProcessTimeout)
+	defer cancel()
+
+	for file.State == "PROCESSING" {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("still processing after %s", fileProcessTimeout)
+		case <-time.After(filePollInterval):
+		}
+
+		resp, err := c.apiRequest(ctx, http.MethodGet, apiBaseURL+"/v1beta/"+file.Name, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := decodeResponse(resp, file); err != nil {
+			return err
+		}
+	}
+
+	if file.State == "FAILED" {
+		if file.Error != nil {
+			return fmt.Errorf("%s", file.Error.Message)
+		}
+		return fmt.Errorf("the service could not process the file")
+	}
+	return nil
+}
+
+// deleteFiles removes uploaded files once they are no longer needed, so
+// they are not kept until they expire
+func (c *Chat) deleteFiles(files []*uploadedFile) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, file := range files {
+		// Failures are harmless, files expire on their own
+		if resp, err := c.apiRequest(ctx, http.MethodDelete, apiBaseURL+"/v1beta/"+file.Name, nil, nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+// restPart is a part of a REST request
+type restPart struct {
+	Text       string `json:"text,omitempty"`
+	InlineData *struct {
+		MimeType string `json:"mimeType"`
+		Data     string `json:"data"`
+	} `json:"inlineData,omitempty"`
+	FileData *struct {
+		MimeType string `json:"mimeType"`
+		FileURI  string `json:"fileUri"`
+	} `json:"fileData,omitempty"`
+}
+
+// restContent is a turn of a REST request
+type restContent struct {
+	Role  string     `json:"role"`
+	Parts []restPart `json:"parts"`
+}
+
+// toRestParts converts the text and inline data of parts
+func toRestParts(parts []genai.Part) []restPart {
+	var converted []restPart
+	for _, part := range parts {
+		var p restPart
+		switch v := part.(type) {
+		case genai.Text:
+			p.Text = string(v)
+		case genai.Blob:
+			p.InlineData = &struct {
+				MimeType string `json:"mimeType"`
+				Data     string `json:"data"`
+			}{v.MIMEType, base64.StdEncoding.EncodeToString(v.Data)}
+		default:
+			continue
+		}
+		converted = append(converted, p)
+	}
+	return converted
+}
+
+// generate generates content for parts and uploaded files after the
+// history with the model's settings, returning the tokens the response
+// reports
+func (c *Chat) generate(ctx context.Context, history []*genai.Content, parts []genai.Part, files []*uploadedFile) (*genai.GenerateContentResponse, tokenUsage, error) {
+	request := struct {
+		Contents         []restContent            `json:"contents"`
+		GenerationConfig map[string]interface{}   `json:"generationConfig,omitempty"`
+		SafetySettings   []map[string]interface{} `json:"safetySettings,omitempty"`
+	}{}
+
+	for _, content := range history {
+		request.Contents = append(request.Contents, restContent{content.Role, toRestParts(content.Parts)})
+	}
+
+	restParts := toRestParts(parts)
+	for _, file := range files {
+		restParts = append(restParts, restPart{FileData: &struct {
+			MimeType string `json:"mimeType"`
+			FileURI  string `json:"fileUri"`
+		}{file.MimeType, file.URI}})
+	}
+	request.Contents = append(request.Contents, restContent{"user", restParts})
+
+	config := c.model.GenerationConfig
+	request.GenerationConfig = make(map[string]interface{})
+	if config.Temperature != nil {
+		request.GenerationConfig["temperature"] = *config.Temperature
+	}
+	if config.TopP != nil {
+		request.GenerationConfig["topP"] = *config.TopP
+	}
+	if config.TopK != nil {
+		request.GenerationConfig["topK"] = *config.TopK
+	}
+	if config.MaxOutputTokens != nil {
+		request.GenerationConfig["maxOutputTokens"] = *config.MaxOutputTokens
+	}
+	for _, setting := range c.safetySettings {
+		request.SafetySettings = append(request.SafetySettings, map[string]interface{}{
+			"category":  int(setting.Category),
+			"threshold": int(setting.Threshold),
+		})
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, tokenUsage{}, err
+	}
+	resp, err := c.apiRequest(ctx, http.MethodPost, apiBaseURL+"/v1beta/models/"+c.modelConfig.Name+":generateContent",
+		bytes.NewReader(body), map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return nil, tokenUsage{}, err
+	}
+
+	var result struct {
+		Candidates []struct {
+			Content struct {
+				Role  string `json:"role"`
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"content"`
+		} `json:"candidates"`
+		PromptFeedback struct {
+			BlockReason string `json:"blockReason"`
+		} `json:"promptFeedback"`
+		UsageMetadata struct {
+			PromptTokenCount     int `json:"promptTokenCount"`
+			CandidatesTokenCount int `json:"candidatesTokenCount"`
+		} `json:"usageMetadata"`
+	}
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, tokenUsage{}, err
+	}
+	if result.PromptFeedback.BlockReason != "" {
+		return nil, tokenUsage{}, fmt.Errorf("blocked: %s", result.PromptFeedback.BlockReason)
+	}
+
+	response := &genai.GenerateContentResponse{}
+	for _, candidate := range result.Candidates {
+		content := &genai.Content{Role: candidate.Content.Role}
+		for _, part := range candidate.Content.Parts {
+			content.Parts = append(content.Parts, genai.Text(part.Text))
+		}
+		response.Candidates = append(response.Candidates, &genai.Candidate{Content: content})
+	}
+
+	usage := tokenUsage{
+		Prompt:   result.UsageMetadata.PromptTokenCount,
+		Response: result.UsageMetadata.CandidatesTokenCount,
+	}
+	if usage.total() == 0 {
+		usage = tokenUsage{Prompt: types.EstimateTokens(partsText(parts)) + contentTokens(contentValues(history), types.EstimateTokens), Estimated: true}
+		if len(response.Candidates) > 0 {
+			usage.Response = types.EstimateTokens(partsText(response.Candidates[0].Content.Parts))
+		}
+	}
+	return response, usage, nil
+}
+
+// apiRequest sends an authenticated REST request, returning an error for
+// responses other than 2xx
+func (c *Chat) apiRequest(ctx context.Context, method, target string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	setAPIKey(req, c.apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("%s (HTTP %d)", apiErr.Error.Message, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// decodeResponse reads a JSON response body into v
+func decodeResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// apiKeyTransport authenticates the requests of the generative AI client
+type apiKeyTransport struct {
+	apiKey string
+	base   http.RoundTripper
+}
+
+// RoundTrip sends a request with the API key
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	setAPIKey(req, t.apiKey)
+	return t.base.RoundTrip(req)
+}
+
+// setAPIKey authenticates a request to the API in a header, never the URL,
+// which is included in the errors of failed requests
+func setAPIKey(req *http.Request, apiKey string) {
+	if req.URL.Host == apiHost {
+		req.Header.Set("x-goog-api-key", apiKey)
+	}
+}
@@ -23,6 +23,7 @@ package gemini
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"sync"
 	"time"
 
@@ -50,6 +51,17 @@ type ModelConfig struct {
 	MaxOutputTokens  int     // Maximum tokens in response
 }
 
+// HasFeature reports whether the model supports a feature, such as "pdf"
+// for reading PDF documents itself
+func (m ModelConfig) HasFeature(feature string) bool {
+	for _, f := range m.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
 // Available Gemini models with optimized configurations
 var (
 	ModelGemini15Pro = ModelConfig{
@@ -68,6 +80,7 @@ var (
 			"images",
 			"audio",
 			"video",
+			"pdf",
 			"system_instructions",
 			"function_calling",
 		},
@@ -93,6 +106,7 @@ var (
 			"images",
 			"audio",
 			"video",
+			"pdf",
 			"system_instructions",
 			"function_calling",
 		},
@@ -106,20 +120,35 @@ var (
 // Provider implements the Gemini AI provider
 type Provider struct {
 	client            *genai.Client
+	apiKey            string
 	models            map[string]ModelConfig
 	rateLimiters      map[string]*RateLimiter
 	systemInstruction string
 	defaultModel      string
+	generation        GenerationConfig
+	collector         types.ContextCollector
+	newRedactor       func() types.Redactor
+	newFileReader     func() *types.FileReader
 	mu                sync.RWMutex
 }
 
+// GenerationConfig overrides the sampling settings of the model
+// configurations. Zero values keep the model defaults.
+type GenerationConfig struct {
+	Temperature     float32
+	TopP            float32
+	MaxOutputTokens int
+}
+
 // NewProvider creates a new Gemini provider instance
 func NewProvider(ctx context.Context, apiKey string, systemInstruction string) (*Provider, error) {
 	if apiKey == "" {
 		return nil, types.ErrConfigurationf("Gemini API key is required")
 	}
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	// The key is sent in a header, as the client would put it in the URL
+	httpClient := &http.Client{Transport: &apiKeyTransport{apiKey, http.DefaultTransport}}
+	client, err := genai.NewClient(ctx, option.WithHTTPClient(httpClient))
 	if err != nil {
 		return nil, types.ErrConfigurationf("failed to create Gemini client: %v", err)
 	}
@@ -127,6 +156,7 @@ func NewProvider(ctx context.Context, apiKey string, systemInstruction string) (
 	// Initialize provider with available models
 	provider := &Provider{
 		client: client,
+		apiKey: apiKey,
 		models: map[string]ModelConfig{
 			ModelGemini15Pro.Name:   ModelGemini15Pro,
 			ModelGemini15Flash.Name: ModelGemini15Flash,
@@ -149,6 +179,20 @@ func (p *Provider) Chat(ctx context.Context, model string) (types.Chat, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
+	return p.newChat(ctx, model, p.systemInstruction, false)
+}
+
+// TaskChat creates a chat session for a task such as summarizing, with its
+// own system prompt and without the context sent with requests
+func (p *Provider) TaskChat(ctx context.Context, model, systemPrompt string) (types.Chat, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.newChat(ctx, model, systemPrompt, true)
+}
+
+// newChat creates a chat session; the caller must hold p.mu
+func (p *Provider) newChat(ctx context.Context, model, systemPrompt string, plain bool) (types.Chat, error) {
 	if model == "" {
 		model = p.defaultModel
 	}
@@ -164,6 +208,8 @@ func (p *Provider) Chat(ctx context.Context, model string) (types.Chat, error) {
 		p.rateLimiters[model] = rateLimiter
 	}
 
+	config = p.applyGenerationConfig(config)
+
 	// Create model instance with configuration
 	genModel := p.client.GenerativeModel(model)
 	genModel.SetTemperature(config.Temperature)
@@ -191,17 +237,31 @@ func (p *Provider) Chat(ctx context.Context, model string) (types.Chat, error) {
 		},
 	}
 
+	fileProcessor := types.NewFileReader()
+	if p.newFileReader != nil {
+		fileProcessor = p.newFileReader()
+	}
+
 	// Create chat instance
 	chat := &Chat{
 		model:          genModel,
+		count:          modelCounter(genModel),
 		modelConfig:    config,
 		rateLimiter:    rateLimiter,
-		fileProcessor:  types.NewFileReader(),
+		fileProcessor:  fileProcessor,
 		safetySettings: genModel.SafetySettings,
+		apiKey:         p.apiKey,
+		plain:          plain,
+	}
+	if !plain {
+		chat.collector = p.collector
+	}
+	if p.newRedactor != nil {
+		chat.redactor = p.newRedactor()
 	}
 
 	// Initialize chat with system instruction
-	if err := chat.initializeChat(ctx, p.systemInstruction); err != nil {
+	if err := chat.initializeChat(ctx, systemPrompt); err != nil {
 		return nil, fmt.Errorf("failed to initialize chat: %v", err)
 	}
 
@@ -245,6 +305,9 @@ func (p *Provider) GetModelConfig(model string) (ModelConfig, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
+	if model == "" {
+		model = p.defaultModel
+	}
 	config, ok := p.models[model]
 	if !ok {
 		return ModelConfig{}, types.ErrModelf("unknown model: %s", model)
@@ -282,6 +345,55 @@ func (p *Provider) SetSystemInstruction(instruction string) {
 	p.systemInstruction = instruction
 }
 
+// SetGenerationConfig sets the sampling overrides used by new chats
+func (p *Provider) SetGenerationConfig(generation GenerationConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.generation = generation
+}
+
+// SetContextCollector sets what gathers the environment context sent with
+// each request of new chats
+func (p *Provider) SetContextCollector(collector types.ContextCollector) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.collector = collector
+}
+
+// SetRedactor sets how new chats get the redactor applied to everything
+// they send; each chat gets its own so placeholders are kept per chat
+func (p *Provider) SetRedactor(newRedactor func() types.Redactor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.newRedactor = newRedactor
+}
+
+// SetFileReader sets how new chats get the reader for attached files,
+// which applies the file access policy and size limit
+func (p *Provider) SetFileReader(newFileReader func() *types.FileReader) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.newFileReader = newFileReader
+}
+
+// applyGenerationConfig returns the model configuration with the
+// configured overrides applied, keeping output within the model's limit
+func (p *Provider) applyGenerationConfig(config ModelConfig) ModelConfig {
+	if p.generation.Temperature != 0 {
+		config.Temperature = p.generation.Temperature
+	}
+	if p.generation.TopP != 0 {
+		config.TopP = p.generation.TopP
+	}
+	if p.generation.MaxOutputTokens > 0 {
+		config.MaxOutputTokens = p.generation.MaxOutputTokens
+		if config.OutputTokenLimit > 0 && config.MaxOutputTokens > config.OutputTokenLimit {
+			config.MaxOutputTokens = config.OutputTokenLimit
+		}
+	}
+	return config
+}
+
 // CreateSystemPrompt creates a system prompt for terminal commands
 func createSystemPrompt() string {
 	sysInfo, err := types.GetSystemInfo()
@@ -0,0 +1,94 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gemini
+
+import (
+	"context"
+	"strings"
+
+	"craftcom/pkg/types"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// tokenUsage is the tokens a request and its response used
+type tokenUsage struct {
+	Prompt   int
+	Response int
+	// Estimated is set when a count could not be had from the API and
+	// was estimated from the length of the text
+	Estimated bool
+}
+
+// total returns the tokens of the prompt and response
+func (u tokenUsage) total() int {
+	return u.Prompt + u.Response
+}
+
+// metadata adds the usage to response metadata
+func (u tokenUsage) metadata(metadata map[string]interface{}) map[string]interface{} {
+	metadata["tokens_used"] = u.total()
+	metadata["prompt_tokens"] = u.Prompt
+	metadata["response_tokens"] = u.Response
+	metadata["tokens_estimated"] = u.Estimated
+	return metadata
+}
+
+// contentValues dereferences the turns of a history
+func contentValues(history []*genai.Content) []genai.Content {
+	values := make([]genai.Content, len(history))
+	for i, content := range history {
+		values[i] = *content
+	}
+	return values
+}
+
+// partsText joins the text of parts, for estimating their tokens
+func partsText(parts []genai.Part) string {
+	var b strings.Builder
+	for _, part := range parts {
+		if text, ok := part.(genai.Text); ok {
+			b.WriteString(string(text))
+		}
+	}
+	return b.String()
+}
+
+// CountTokens counts the tokens text is to a model with the API's
+// tokenizer
+func (p *Provider) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	config, err := p.GetModelConfig(model)
+	if err != nil {
+		return 0, err
+	}
+
+	return modelCounter(p.client.GenerativeModel(config.Name))(ctx, text)
+}
+
+// modelCounter counts text with a model's tokenizer through the API
+func modelCounter(model *genai.GenerativeModel) types.TokenCounter {
+	return func(ctx context.Context, text string) (int, error) {
+		resp, err := model.CountTokens(ctx, genai.Text(text))
+		if err != nil {
+			return 0, types.ErrExecutionf("failed to count tokens: %v", err)
+		}
+		return int(resp.TotalTokens), nil
+	}
+}
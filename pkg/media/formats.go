@@ -0,0 +1,199 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// MPEG audio layer III tables, by MPEG version 1 and versions 2 and 2.5
+var (
+	mp3Bitrates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	}
+	mp3SampleRates = [3]int{44100, 48000, 32000}
+)
+
+// mp3Duration uses the frame count of a Xing or VBRI header when there
+// is one, and the bitrate of the first frame otherwise
+func mp3Duration(data []byte) (time.Duration, error) {
+	pos := 0
+	if bytes.HasPrefix(data, []byte("ID3")) && len(data) >= 10 {
+		// The tag size is stored in 7-bit bytes
+		size := int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9])
+		pos = 10 + size
+		if data[5]&0x10 != 0 {
+			pos += 10
+		}
+	}
+
+	// Find the first frame
+	for ; pos+4 <= len(data); pos++ {
+		if data[pos] == 0xFF && data[pos+1]&0xE0 == 0xE0 && data[pos+1]&0x06 == 0x02 {
+			break
+		}
+	}
+	if pos+4 > len(data) {
+		return 0, ErrUnknownFormat
+	}
+
+	header := data[pos : pos+4]
+	version := (header[1] >> 3) & 0x03 // 3 is MPEG 1, 2 is MPEG 2, 0 is MPEG 2.5
+	bitrateIndex := header[2] >> 4
+	rateIndex := (header[2] >> 2) & 0x03
+	mono := header[3]>>6 == 3
+	if version == 1 || rateIndex == 3 || bitrateIndex == 0 || bitrateIndex == 15 {
+		return 0, ErrUnknownFormat
+	}
+
+	table, samples, rate := 0, 1152, mp3SampleRates[rateIndex]
+	if version != 3 {
+		table, samples = 1, 576
+		rate /= 2
+		if version == 0 {
+			rate /= 2
+		}
+	}
+
+	// The Xing header follows the side information
+	sideInfo := 32
+	switch {
+	case version == 3 && mono, version != 3 && !mono:
+		sideInfo = 17
+	case version != 3 && mono:
+		sideInfo = 9
+	}
+	if xing := pos + 4 + sideInfo; xing+12 <= len(data) {
+		tag := string(data[xing : xing+4])
+		if (tag == "Xing" || tag == "Info") && data[xing+7]&0x01 != 0 {
+			frames := binary.BigEndian.Uint32(data[xing+8 : xing+12])
+			return seconds(float64(frames) * float64(samples) / float64(rate)), nil
+		}
+	}
+	if vbri := pos + 4 + 32; vbri+18 <= len(data) && string(data[vbri:vbri+4]) == "VBRI" {
+		frames := binary.BigEndian.Uint32(data[vbri+14 : vbri+18])
+		return seconds(float64(frames) * float64(samples) / float64(rate)), nil
+	}
+
+	bitrate := mp3Bitrates[table][bitrateIndex] * 1000
+	return seconds(float64(len(data)-pos) * 8 / float64(bitrate)), nil
+}
+
+// Matroska element IDs used to find the duration
+const (
+	mkvSegment       = 0x18538067
+	mkvInfo          = 0x1549A966
+	mkvTimecodeScale = 0x2AD7B1
+	mkvDuration      = 0x4489
+)
+
+// matroskaDuration reads the segment information of Matroska and WebM
+// files
+func matroskaDuration(data []byte) (time.Duration, error) {
+	segment := ebmlChild(data, mkvSegment)
+	if segment == nil {
+		return 0, ErrUnknownFormat
+	}
+	info := ebmlChild(segment, mkvInfo)
+	if info == nil {
+		return 0, ErrUnknownFormat
+	}
+
+	scale := uint64(1000000)
+	if value := ebmlChild(info, mkvTimecodeScale); len(value) > 0 && len(value) <= 8 {
+		scale = 0
+		for _, b := range value {
+			scale = scale<<8 | uint64(b)
+		}
+	}
+
+	var duration float64
+	switch value := ebmlChild(info, mkvDuration); len(value) {
+	case 4:
+		duration = float64(math.Float32frombits(binary.BigEndian.Uint32(value)))
+	case 8:
+		duration = math.Float64frombits(binary.BigEndian.Uint64(value))
+	default:
+		return 0, ErrUnknownFormat
+	}
+	return time.Duration(duration * float64(scale)), nil
+}
+
+// ebmlChild returns the body of the first element with the ID among the
+// elements in data. An element of unknown size extends to the end.
+func ebmlChild(data []byte, id uint64) []byte {
+	for pos := 0; pos < len(data); {
+		elementID, n := ebmlVint(data[pos:], true)
+		if n == 0 {
+			return nil
+		}
+		pos += n
+		size, m := ebmlVint(data[pos:], false)
+		if m == 0 {
+			return nil
+		}
+		pos += m
+
+		end := len(data)
+		if size != ebmlUnknownSize(m) && uint64(pos)+size <= uint64(len(data)) {
+			end = pos + int(size)
+		}
+		if elementID == id {
+			return data[pos:end]
+		}
+		pos = end
+	}
+	return nil
+}
+
+// ebmlVint reads a variable-length integer, keeping the length marker for
+// element IDs, and returns its length in bytes, 0 when it is invalid
+func ebmlVint(data []byte, keepMarker bool) (uint64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+	length := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 || length > len(data) {
+		return 0, 0
+	}
+
+	value := uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+	}
+	return value, length
+}
+
+// ebmlUnknownSize is the size value of elements whose size is not known,
+// all ones for a size of n bytes
+func ebmlUnknownSize(n int) uint64 {
+	return 1<<(7*uint(n)) - 1
+}
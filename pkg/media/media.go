@@ -0,0 +1,198 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package media reads the duration of audio and video files from their
+// headers, without decoding them. It understands MP3, WAV, Ogg (Vorbis and
+// Opus), MP4/MOV/M4A and Matroska/WebM.
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrUnknownFormat is returned for data in a format Duration cannot read
+var ErrUnknownFormat = errors.New("unknown media format")
+
+// Duration returns the playing time of an audio or video file
+func Duration(data []byte) (time.Duration, error) {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return wavDuration(data)
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return oggDuration(data)
+	case len(data) >= 8 && isBoxType(data[4:8]):
+		return mp4Duration(data)
+	case bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return matroskaDuration(data)
+	case bytes.HasPrefix(data, []byte("ID3")) || (len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0):
+		return mp3Duration(data)
+	}
+	return 0, ErrUnknownFormat
+}
+
+// seconds converts a number of seconds to a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// wavDuration reads the byte rate and data size of a RIFF WAVE file
+func wavDuration(data []byte) (time.Duration, error) {
+	var byteRate uint32
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		rawSize := binary.LittleEndian.Uint32(data[pos+4 : pos+8])
+		size := int(rawSize)
+		body := pos + 8
+
+		switch id {
+		case "fmt ":
+			if body+12 > len(data) {
+				return 0, ErrUnknownFormat
+			}
+			byteRate = binary.LittleEndian.Uint32(data[body+8 : body+12])
+		case "data":
+			if byteRate == 0 {
+				return 0, ErrUnknownFormat
+			}
+			// Streamed files leave the size unset
+			if rawSize == math.MaxUint32 || body+size > len(data) {
+				size = len(data) - body
+			}
+			return seconds(float64(size) / float64(byteRate)), nil
+		}
+		if body+size > len(data) || size < 0 {
+			break
+		}
+		pos = body + size + size%2
+	}
+	return 0, ErrUnknownFormat
+}
+
+// oggDuration divides the granule position of the last page by the sample
+// rate of the Vorbis or Opus stream
+func oggDuration(data []byte) (time.Duration, error) {
+	var rate float64
+	var preSkip uint64
+	switch {
+	case bytes.Contains(data[:min(len(data), 512)], []byte("\x01vorbis")):
+		i := bytes.Index(data, []byte("\x01vorbis"))
+		if i+16 > len(data) {
+			return 0, ErrUnknownFormat
+		}
+		rate = float64(binary.LittleEndian.Uint32(data[i+12 : i+16]))
+	case bytes.Contains(data[:min(len(data), 512)], []byte("OpusHead")):
+		// Opus positions always count 48 kHz samples
+		i := bytes.Index(data, []byte("OpusHead"))
+		if i+12 > len(data) {
+			return 0, ErrUnknownFormat
+		}
+		rate = 48000
+		preSkip = uint64(binary.LittleEndian.Uint16(data[i+10 : i+12]))
+	default:
+		return 0, ErrUnknownFormat
+	}
+
+	last := bytes.LastIndex(data, []byte("OggS"))
+	if last < 0 || last+14 > len(data) || rate == 0 {
+		return 0, ErrUnknownFormat
+	}
+	granule := binary.LittleEndian.Uint64(data[last+6 : last+14])
+	if granule < preSkip {
+		return 0, ErrUnknownFormat
+	}
+	return seconds(float64(granule-preSkip) / rate), nil
+}
+
+// isBoxType reports whether b is the type of a box that starts ISO media
+// files
+func isBoxType(b []byte) bool {
+	switch string(b) {
+	case "ftyp", "moov", "mdat", "wide", "free", "skip":
+		return true
+	}
+	return false
+}
+
+// mp4Duration reads the movie header of MP4, MOV and M4A files
+func mp4Duration(data []byte) (time.Duration, error) {
+	moov := findBox(data, "moov")
+	if moov == nil {
+		return 0, ErrUnknownFormat
+	}
+	mvhd := findBox(moov, "mvhd")
+	if len(mvhd) < 4 {
+		return 0, ErrUnknownFormat
+	}
+
+	var timescale uint32
+	var duration uint64
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0, ErrUnknownFormat
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[20:24])
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	} else {
+		if len(mvhd) < 20 {
+			return 0, ErrUnknownFormat
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[12:16])
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+	if timescale == 0 {
+		return 0, ErrUnknownFormat
+	}
+	return seconds(float64(duration) / float64(timescale)), nil
+}
+
+// findBox returns the body of the first box of a type among the boxes in
+// data
+func findBox(data []byte, boxType string) []byte {
+	for pos := 0; pos+8 <= len(data); {
+		size := uint64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data) - pos)
+		case 1:
+			if pos+16 > len(data) {
+				return nil
+			}
+			size = binary.BigEndian.Uint64(data[pos+8 : pos+16])
+			header = 16
+		}
+		if size < header || uint64(pos)+size > uint64(len(data)) {
+			// A truncated box still holds what was read of it
+			size = uint64(len(data) - pos)
+			if size < header {
+				return nil
+			}
+		}
+		if string(data[pos+4:pos+8]) == boxType {
+			return data[uint64(pos)+header : uint64(pos)+size]
+		}
+		pos += int(size)
+	}
+	return nil
+}
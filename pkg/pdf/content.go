@@ -0,0 +1,212 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pdf
+
+import (
+	"bytes"
+	"math"
+	"strings"
+)
+
+// maxFormDepth bounds nesting of form XObjects drawn from each other
+const maxFormDepth = 8
+
+// textWriter collects shown text, turning line moves into line breaks and
+// gaps into spaces
+type textWriter struct {
+	b     strings.Builder
+	last  byte
+	lineY float64
+	hasY  bool
+}
+
+// write adds shown text
+func (w *textWriter) write(text string) {
+	if text != "" {
+		w.b.WriteString(text)
+		w.last = text[len(text)-1]
+	}
+}
+
+// space separates words unless they already are
+func (w *textWriter) space() {
+	if w.b.Len() > 0 && w.last != ' ' && w.last != '\n' {
+		w.write(" ")
+	}
+}
+
+// newline starts a new line unless one was just started
+func (w *textWriter) newline() {
+	if w.b.Len() > 0 && w.last != '\n' {
+		w.write("\n")
+	}
+}
+
+// moveTo handles a text position on a new or the same line
+func (w *textWriter) moveTo(y float64) {
+	if w.hasY && math.Abs(y-w.lineY) > 1 {
+		w.newline()
+	} else {
+		w.space()
+	}
+	w.lineY, w.hasY = y, true
+}
+
+// pageText returns the text of a page
+func (d *document) pageText(p page) string {
+	w := &textWriter{}
+	d.showContent(w, d.contents(p), p.resources, 0)
+
+	lines := strings.Split(w.b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// showContent interprets a content stream, writing the text it shows
+func (d *document) showContent(w *textWriter, content []byte, resources dict, depth int) {
+	l := &lexer{data: content}
+	var operands []interface{}
+	var current *font
+
+	number := func(i int) float64 {
+		if i < len(operands) {
+			if n, ok := operands[i].(float64); ok {
+				return n
+			}
+		}
+		return 0
+	}
+	show := func(value interface{}) {
+		if s, ok := value.([]byte); ok && current != nil {
+			w.write(current.decode(s))
+		} else if ok {
+			w.write(string(s))
+		}
+	}
+
+	for {
+		value, err := l.object()
+		if err != nil {
+			return
+		}
+		op, ok := value.(keyword)
+		if !ok {
+			operands = append(operands, value)
+			continue
+		}
+
+		switch op {
+		case "BI":
+			skipInlineImage(l)
+		case "BT":
+			w.hasY = false
+		case "Tf":
+			if len(operands) >= 2 {
+				if fontName, ok := operands[len(operands)-2].(name); ok {
+					current = d.font(d.dictOf(resources["Font"])[fontName])
+				}
+			}
+		case "Td", "TD":
+			if number(1) != 0 {
+				w.newline()
+			} else if number(0) != 0 {
+				w.space()
+			}
+		case "Tm":
+			w.moveTo(number(5))
+		case "T*":
+			w.newline()
+		case "Tj":
+			if len(operands) > 0 {
+				show(operands[len(operands)-1])
+			}
+		case "'", `"`:
+			w.newline()
+			if len(operands) > 0 {
+				show(operands[len(operands)-1])
+			}
+		case "TJ":
+			if len(operands) == 0 {
+				break
+			}
+			items, _ := operands[len(operands)-1].(array)
+			for _, item := range items {
+				// Large negative adjustments, in thousandths of the font
+				// size, separate words
+				if n, ok := item.(float64); ok {
+					if n < -150 {
+						w.space()
+					}
+					continue
+				}
+				show(item)
+			}
+		case "ET":
+			w.space()
+		case "Do":
+			if len(operands) == 0 || depth >= maxFormDepth {
+				break
+			}
+			xobjectName, _ := operands[len(operands)-1].(name)
+			form, ok := d.resolve(d.dictOf(resources["XObject"])[xobjectName]).(*stream)
+			if !ok || form.dict["Subtype"] != name("Form") {
+				break
+			}
+			data, err := d.decode(form)
+			if err != nil {
+				break
+			}
+			formResources := d.dictOf(form.dict["Resources"])
+			if formResources == nil {
+				formResources = resources
+			}
+			w.newline()
+			d.showContent(w, data, formResources, depth+1)
+			w.newline()
+		}
+		operands = operands[:0]
+	}
+}
+
+// skipInlineImage moves past the data of an inline image, which is not
+// made of tokens, up to its EI operator
+func skipInlineImage(l *lexer) {
+	i := bytes.Index(l.data[l.pos:], []byte("ID"))
+	if i < 0 {
+		l.pos = len(l.data)
+		return
+	}
+	l.pos += i + len("ID")
+	for l.pos < len(l.data) {
+		j := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if j < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		end := l.pos + j
+		l.pos = end + len("EI")
+		if end > 0 && isSpace(l.data[end-1]) && (l.pos == len(l.data) || isDelimiter(l.data[l.pos])) {
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pdf
+
+// winAnsiHigh maps codes 128-255 of WinAnsiEncoding; 0 is undefined
+var winAnsiHigh = [128]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+	'\u00a0', '¡', '¢', '£', '¤', '¥', '¦', '§',
+	'¨', '©', 'ª', '«', '¬', '\u00ad', '®', '¯',
+	'°', '±', '²', '³', '´', 'µ', '¶', '·',
+	'¸', '¹', 'º', '»', '¼', '½', '¾', '¿',
+	'À', 'Á', 'Â', 'Ã', 'Ä', 'Å', 'Æ', 'Ç',
+	'È', 'É', 'Ê', 'Ë', 'Ì', 'Í', 'Î', 'Ï',
+	'Ð', 'Ñ', 'Ò', 'Ó', 'Ô', 'Õ', 'Ö', '×',
+	'Ø', 'Ù', 'Ú', 'Û', 'Ü', 'Ý', 'Þ', 'ß',
+	'à', 'á', 'â', 'ã', 'ä', 'å', 'æ', 'ç',
+	'è', 'é', 'ê', 'ë', 'ì', 'í', 'î', 'ï',
+	'ð', 'ñ', 'ò', 'ó', 'ô', 'õ', 'ö', '÷',
+	'ø', 'ù', 'ú', 'û', 'ü', 'ý', 'þ', 'ÿ',
+}
+
+// macRomanHigh maps codes 128-255 of MacRomanEncoding
+var macRomanHigh = [128]rune{
+	'Ä', 'Å', 'Ç', 'É', 'Ñ', 'Ö', 'Ü', 'á',
+	'à', 'â', 'ä', 'ã', 'å', 'ç', 'é', 'è',
+	'ê', 'ë', 'í', 'ì', 'î', 'ï', 'ñ', 'ó',
+	'ò', 'ô', 'ö', 'õ', 'ú', 'ù', 'û', 'ü',
+	'†', '°', '¢', '£', '§', '•', '¶', 'ß',
+	'®', '©', '™', '´', '¨', '≠', 'Æ', 'Ø',
+	'∞', '±', '≤', '≥', '¥', 'µ', '∂', '∑',
+	'∏', 'π', '∫', 'ª', 'º', 'Ω', 'æ', 'ø',
+	'¿', '¡', '¬', '√', 'ƒ', '≈', '∆', '«',
+	'»', '…', '\u00a0', 'À', 'Ã', 'Õ', 'Œ', 'œ',
+	'–', '—', '“', '”', '‘', '’', '÷', '◊',
+	'ÿ', 'Ÿ', '⁄', '€', '‹', '›', 'ﬁ', 'ﬂ',
+	'‡', '·', '‚', '„', '‰', 'Â', 'Ê', 'Á',
+	'Ë', 'È', 'Í', 'Î', 'Ï', 'Ì', 'Ó', 'Ô',
+	'\uf8ff', 'Ò', 'Ú', 'Û', 'Ù', 'ı', 'ˆ', '˜',
+	'¯', '˘', '˙', '˚', '¸', '˝', '˛', 'ˇ',
+}
+
+// standardHigh maps codes 128-255 of StandardEncoding
+var standardHigh = [128]rune{
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0, '¡', '¢', '£', '⁄', '¥', 'ƒ', '§',
+	'¤', '\'', '“', '«', '‹', '›', 'ﬁ', 'ﬂ',
+	0, '–', '†', '‡', '·', 0, '¶', '•',
+	'‚', '„', '”', '»', '…', '‰', 0, '¿',
+	0, '`', '´', 'ˆ', '˜', '¯', '˘', '˙',
+	'¨', 0, '˚', '¸', 0, '˝', '˛', 'ˇ',
+	'—', 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0, 'Æ', 0, 'ª', 0, 0, 0, 0,
+	'Ł', 'Ø', 'Œ', 'º', 0, 0, 0, 0,
+	0, 'æ', 0, 0, 0, 'ı', 0, 0,
+	'ł', 'ø', 'œ', 'ß', 0, 0, 0, 0,
+}
+
+// glyphNames maps the glyph names used in encoding differences to the
+// characters they stand for; single letters and uniXXXX names are
+// handled by glyphRune
+var glyphNames = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#',
+	"dollar": '$', "percent": '%', "ampersand": '&', "quotesingle": '\'',
+	"parenleft": '(', "parenright": ')', "asterisk": '*', "plus": '+',
+	"comma": ',', "hyphen": '-', "period": '.', "slash": '/', "zero": '0',
+	"one": '1', "two": '2', "three": '3', "four": '4', "five": '5', "six": '6',
+	"seven": '7', "eight": '8', "nine": '9', "colon": ':', "semicolon": ';',
+	"less": '<', "equal": '=', "greater": '>', "question": '?', "at": '@',
+	"bracketleft": '[', "backslash": '\\', "bracketright": ']',
+	"asciicircum": '^', "underscore": '_', "grave": '`', "braceleft": '{',
+	"bar": '|', "braceright": '}', "asciitilde": '~', "quoteright": '’',
+	"quoteleft": '‘', "quotedblleft": '“', "quotedblright": '”',
+	"quotesinglbase": '‚', "quotedblbase": '„', "guillemotleft": '«',
+	"guillemotright": '»', "guilsinglleft": '‹', "guilsinglright": '›',
+	"exclamdown": '¡', "questiondown": '¿', "cent": '¢', "sterling": '£',
+	"fraction": '⁄', "yen": '¥', "florin": 'ƒ', "section": '§', "currency": '¤',
+	"paragraph": '¶', "bullet": '•', "periodcentered": '·', "ellipsis": '…',
+	"perthousand": '‰', "endash": '–', "emdash": '—', "dagger": '†',
+	"daggerdbl": '‡', "fi": 'ﬁ', "fl": 'ﬂ', "ff": 'ﬀ', "ffi": 'ﬃ', "ffl": 'ﬄ',
+	"acute": '´', "circumflex": 'ˆ', "tilde": '˜', "macron": '¯', "breve": '˘',
+	"dotaccent": '˙', "dieresis": '¨', "ring": '˚', "cedilla": '¸',
+	"hungarumlaut": '˝', "ogonek": '˛', "caron": 'ˇ', "AE": 'Æ', "ae": 'æ',
+	"OE": 'Œ', "oe": 'œ', "ordfeminine": 'ª', "ordmasculine": 'º',
+	"Lslash": 'Ł', "lslash": 'ł', "Oslash": 'Ø', "oslash": 'ø', "dotlessi": 'ı',
+	"germandbls": 'ß', "Eth": 'Ð', "eth": 'ð', "Thorn": 'Þ', "thorn": 'þ',
+	"Euro": '€', "trademark": '™', "copyright": '©', "registered": '®',
+	"degree": '°', "plusminus": '±', "multiply": '×', "divide": '÷',
+	"minus": '−', "nbspace": '\u00a0', "brokenbar": '¦', "logicalnot": '¬',
+	"mu": 'µ', "onehalf": '½', "onequarter": '¼', "threequarters": '¾',
+	"onesuperior": '¹', "twosuperior": '²', "threesuperior": '³', "Agrave": 'À',
+	"Aacute": 'Á', "Acircumflex": 'Â', "Atilde": 'Ã', "Adieresis": 'Ä',
+	"Aring": 'Å', "Ccedilla": 'Ç', "Egrave": 'È', "Eacute": 'É',
+	"Ecircumflex": 'Ê', "Edieresis": 'Ë', "Igrave": 'Ì', "Iacute": 'Í',
+	"Icircumflex": 'Î', "Idieresis": 'Ï', "Ntilde": 'Ñ', "Ograve": 'Ò',
+	"Oacute": 'Ó', "Ocircumflex": 'Ô', "Otilde": 'Õ', "Odieresis": 'Ö',
+	"Ugrave": 'Ù', "Uacute": 'Ú', "Ucircumflex": 'Û', "Udieresis": 'Ü',
+	"Yacute": 'Ý', "agrave": 'à', "aacute": 'á', "acircumflex": 'â',
+	"atilde": 'ã', "adieresis": 'ä', "aring": 'å', "ccedilla": 'ç',
+	"egrave": 'è', "eacute": 'é', "ecircumflex": 'ê', "edieresis": 'ë',
+	"igrave": 'ì', "iacute": 'í', "icircumflex": 'î', "idieresis": 'ï',
+	"ntilde": 'ñ', "ograve": 'ò', "oacute": 'ó', "ocircumflex": 'ô',
+	"otilde": 'õ', "odieresis": 'ö', "ugrave": 'ù', "uacute": 'ú',
+	"ucircumflex": 'û', "udieresis": 'ü', "yacute": 'ý', "ydieresis": 'ÿ',
+	"Aogonek": 'Ą', "aogonek": 'ą', "Cacute": 'Ć', "cacute": 'ć',
+	"Ccircumflex": 'Ĉ', "ccircumflex": 'ĉ', "Cdotaccent": 'Ċ',
+	"cdotaccent": 'ċ', "Ccaron": 'Č', "ccaron": 'č', "Dcaron": 'Ď',
+	"dcaron": 'ď', "Edotaccent": 'Ė', "edotaccent": 'ė', "Eogonek": 'Ę',
+	"eogonek": 'ę', "Ecaron": 'Ě', "ecaron": 'ě', "Gcircumflex": 'Ĝ',
+	"gcircumflex": 'ĝ', "Gdotaccent": 'Ġ', "gdotaccent": 'ġ', "Gcedilla": 'Ģ',
+	"gcedilla": 'ģ', "Hcircumflex": 'Ĥ', "hcircumflex": 'ĥ', "Itilde": 'Ĩ',
+	"itilde": 'ĩ', "Iogonek": 'Į', "iogonek": 'į', "Idotaccent": 'İ',
+	"Jcircumflex": 'Ĵ', "jcircumflex": 'ĵ', "Kcedilla": 'Ķ', "kcedilla": 'ķ',
+	"Lacute": 'Ĺ', "lacute": 'ĺ', "Lcedilla": 'Ļ', "lcedilla": 'ļ',
+	"Lcaron": 'Ľ', "lcaron": 'ľ', "Nacute": 'Ń', "nacute": 'ń', "Ncedilla": 'Ņ',
+	"ncedilla": 'ņ', "Ncaron": 'Ň', "ncaron": 'ň', "Racute": 'Ŕ', "racute": 'ŕ',
+	"Rcedilla": 'Ŗ', "rcedilla": 'ŗ', "Rcaron": 'Ř', "rcaron": 'ř',
+	"Sacute": 'Ś', "sacute": 'ś', "Scircumflex": 'Ŝ', "scircumflex": 'ŝ',
+	"Scedilla": 'Ş', "scedilla": 'ş', "Scaron": 'Š', "scaron": 'š',
+	"Tcedilla": 'Ţ', "tcedilla": 'ţ', "Tcaron": 'Ť', "tcaron": 'ť',
+	"Utilde": 'Ũ', "utilde": 'ũ', "Uring": 'Ů', "uring": 'ů', "Uogonek": 'Ų',
+	"uogonek": 'ų', "Wcircumflex": 'Ŵ', "wcircumflex": 'ŵ', "Ycircumflex": 'Ŷ',
+	"ycircumflex": 'ŷ', "Ydieresis": 'Ÿ', "Zacute": 'Ź', "zacute": 'ź',
+	"Zdotaccent": 'Ż', "zdotaccent": 'ż', "Zcaron": 'Ž', "zcaron": 'ž',
+}
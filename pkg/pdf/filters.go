@@ -0,0 +1,140 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"fmt"
+	"io"
+)
+
+// maxDecodedSize bounds the size of a decoded stream, against
+// decompression bombs
+const maxDecodedSize = 64 << 20
+
+// decode applies the filters of a stream to its data
+func (d *document) decode(s *stream) ([]byte, error) {
+	var filters []name
+	switch f := d.resolve(s.dict["Filter"]).(type) {
+	case name:
+		filters = []name{f}
+	case array:
+		for _, item := range f {
+			if n, ok := d.resolve(item).(name); ok {
+				filters = append(filters, n)
+			}
+		}
+	}
+
+	var params []dict
+	switch p := d.resolve(s.dict["DecodeParms"]).(type) {
+	case dict:
+		params = []dict{p}
+	case array:
+		for _, item := range p {
+			param, _ := d.resolve(item).(dict)
+			params = append(params, param)
+		}
+	}
+
+	data := s.data
+	for i, filter := range filters {
+		var param dict
+		if i < len(params) {
+			param = params[i]
+		}
+
+		var err error
+		switch filter {
+		case "FlateDecode", "Fl":
+			if predictor, ok := d.resolve(param["Predictor"]).(float64); ok && predictor > 1 {
+				return nil, fmt.Errorf("unsupported predictor %v", predictor)
+			}
+			data, err = inflate(data)
+		case "ASCIIHexDecode", "AHx":
+			data = (&lexer{data: append(data, '>')}).hexString()
+		case "ASCII85Decode", "A85":
+			data, err = decodeASCII85(data)
+		case "RunLengthDecode", "RL":
+			data = decodeRunLength(data)
+		default:
+			// Images and unusual encodings carry no text worth extracting
+			return nil, fmt.Errorf("unsupported filter %s", filter)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// inflate decompresses zlib data, keeping what could be read from
+// truncated streams, which are common
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	out, err := io.ReadAll(io.LimitReader(reader, maxDecodedSize+1))
+	if len(out) > maxDecodedSize {
+		return nil, fmt.Errorf("stream too large")
+	}
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+// decodeASCII85 decodes ASCII base-85 data up to the ~> end marker
+func decodeASCII85(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+	return io.ReadAll(ascii85.NewDecoder(bytes.NewReader(data)))
+}
+
+// decodeRunLength decodes RunLengthDecode data
+func decodeRunLength(data []byte) []byte {
+	var out []byte
+	for i := 0; i < len(data); {
+		length := int(data[i])
+		i++
+		switch {
+		case length == 128:
+			return out
+		case length < 128:
+			end := min(i+length+1, len(data))
+			out = append(out, data[i:end]...)
+			i = end
+		default:
+			if i < len(data) {
+				out = append(out, bytes.Repeat(data[i:i+1], 257-length)...)
+			}
+			i++
+		}
+	}
+	return out
+}
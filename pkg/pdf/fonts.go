@@ -0,0 +1,334 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pdf
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// font turns the codes of shown strings into text
+type font struct {
+	// toUnicode is the font's ToUnicode map, when it has one
+	toUnicode *cmap
+	// encoding maps the codes of simple fonts; 0 is unmapped
+	encoding [256]rune
+	// composite fonts use two-byte codes unless their map says otherwise
+	composite bool
+}
+
+// font returns the font for a font dictionary, parsing it once
+func (d *document) font(value interface{}) *font {
+	key := value
+	if _, ok := value.(ref); !ok {
+		key = nil
+	}
+	if f, ok := d.fonts[key]; ok && key != nil {
+		return f
+	}
+
+	f := &font{}
+	fontDict := d.dictOf(value)
+	f.composite = fontDict["Subtype"] == name("Type0")
+
+	if s, ok := d.resolve(fontDict["ToUnicode"]).(*stream); ok {
+		if data, err := d.decode(s); err == nil {
+			f.toUnicode = parseCMap(data)
+		}
+	}
+
+	// Simple fonts start from their base encoding, changed by Differences
+	f.encoding = standardEncoding()
+	switch encoding := d.resolve(fontDict["Encoding"]).(type) {
+	case name:
+		f.encoding = namedEncoding(encoding)
+	case dict:
+		if base, ok := d.resolve(encoding["BaseEncoding"]).(name); ok {
+			f.encoding = namedEncoding(base)
+		}
+		differences, _ := d.resolve(encoding["Differences"]).(array)
+		code := 0
+		for _, item := range differences {
+			switch v := d.resolve(item).(type) {
+			case float64:
+				code = int(v)
+			case name:
+				if code >= 0 && code < 256 {
+					f.encoding[code] = glyphRune(string(v))
+				}
+				code++
+			}
+		}
+	}
+
+	if key != nil {
+		d.fonts[key] = f
+	}
+	return f
+}
+
+// decode turns the codes of a shown string into text
+func (f *font) decode(s []byte) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if f.toUnicode != nil {
+			if text, n := f.toUnicode.lookup(s[i:], f.composite); n > 0 {
+				b.WriteString(text)
+				i += n
+				continue
+			}
+		}
+		if f.composite {
+			// Without a map, codes of composite fonts mean nothing by
+			// themselves
+			i += 2
+			continue
+		}
+		if r := f.encoding[s[i]]; r != 0 {
+			b.WriteRune(r)
+		}
+		i++
+	}
+	return b.String()
+}
+
+// standardEncoding returns StandardEncoding, the default of simple fonts
+func standardEncoding() [256]rune {
+	var encoding [256]rune
+	for code := 32; code < 127; code++ {
+		encoding[code] = rune(code)
+	}
+	encoding['\''] = '’'
+	encoding['`'] = '‘'
+	copy(encoding[128:], standardHigh[:])
+	return encoding
+}
+
+// namedEncoding returns one of the predefined encodings
+func namedEncoding(encodingName name) [256]rune {
+	var high *[128]rune
+	switch encodingName {
+	case "WinAnsiEncoding":
+		high = &winAnsiHigh
+	case "MacRomanEncoding":
+		high = &macRomanHigh
+	default:
+		return standardEncoding()
+	}
+
+	var encoding [256]rune
+	for code := 32; code < 127; code++ {
+		encoding[code] = rune(code)
+	}
+	copy(encoding[128:], high[:])
+	return encoding
+}
+
+// glyphRune returns the character a glyph name stands for, or 0
+func glyphRune(glyph string) rune {
+	// Suffixes such as ".sc" name variants of the same glyph
+	if i := strings.IndexByte(glyph, '.'); i > 0 {
+		glyph = glyph[:i]
+	}
+	if r, ok := glyphNames[glyph]; ok {
+		return r
+	}
+	if len(glyph) == 1 && ((glyph[0] >= 'a' && glyph[0] <= 'z') || (glyph[0] >= 'A' && glyph[0] <= 'Z')) {
+		return rune(glyph[0])
+	}
+	for _, prefix := range []string{"uni", "u"} {
+		if hex := strings.TrimPrefix(glyph, prefix); hex != glyph && len(hex) >= 4 && len(hex) <= 6 {
+			if value, err := strconv.ParseUint(hex[:4], 16, 32); err == nil && prefix == "uni" {
+				return rune(value)
+			}
+			if value, err := strconv.ParseUint(hex, 16, 32); err == nil && utf8.ValidRune(rune(value)) {
+				return rune(value)
+			}
+		}
+	}
+	return 0
+}
+
+// cmap is a ToUnicode map from character codes to text
+type cmap struct {
+	// codespaces are the code ranges, giving the length of codes
+	codespaces []codespace
+	mappings   map[string]string
+}
+
+// codespace is a range of codes of one length
+type codespace struct {
+	low, high []byte
+}
+
+// lookup returns the text of the code at the start of s and its length in
+// bytes, or 0 when the code is not mapped
+func (c *cmap) lookup(s []byte, composite bool) (string, int) {
+	for _, space := range c.codespaces {
+		n := len(space.low)
+		if n == 0 || n > len(s) || !inRange(s[:n], space) {
+			continue
+		}
+		if text, ok := c.mappings[string(s[:n])]; ok {
+			return text, n
+		}
+		return "", 0
+	}
+
+	// Maps without code space ranges are common; guess the code length
+	n := 1
+	if composite {
+		n = 2
+	}
+	if n <= len(s) {
+		if text, ok := c.mappings[string(s[:n])]; ok {
+			return text, n
+		}
+	}
+	return "", 0
+}
+
+// inRange reports whether a code lies in a code space range
+func inRange(code []byte, space codespace) bool {
+	for i := range code {
+		if code[i] < space.low[i] || code[i] > space.high[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCMap reads the code space ranges and bfchar and bfrange mappings of
+// a ToUnicode map
+func parseCMap(data []byte) *cmap {
+	c := &cmap{mappings: make(map[string]string)}
+	l := &lexer{data: data}
+	var operands []interface{}
+
+	for {
+		value, err := l.object()
+		if err != nil {
+			break
+		}
+		op, ok := value.(keyword)
+		if !ok {
+			operands = append(operands, value)
+			continue
+		}
+
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				low, ok1 := operands[i].([]byte)
+				high, ok2 := operands[i+1].([]byte)
+				if ok1 && ok2 && len(low) == len(high) {
+					c.codespaces = append(c.codespaces, codespace{low: low, high: high})
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				code, ok1 := operands[i].([]byte)
+				text, ok2 := operands[i+1].([]byte)
+				if ok1 && ok2 {
+					c.mappings[string(code)] = utf16Text(text)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, ok1 := operands[i].([]byte)
+				high, ok2 := operands[i+1].([]byte)
+				if !ok1 || !ok2 || len(low) != len(high) || len(low) == 0 {
+					continue
+				}
+				c.addRange(low, high, operands[i+2])
+			}
+		}
+		if strings.HasPrefix(string(op), "end") || strings.HasPrefix(string(op), "begin") {
+			operands = operands[:0]
+		}
+	}
+	return c
+}
+
+// maxRange bounds the codes of one bfrange, against malformed maps
+const maxRange = 1 << 16
+
+// addRange adds the mappings of a bfrange, where the destination is the
+// text of the first code, incremented for the next ones, or an array with
+// the text of each code
+func (c *cmap) addRange(low, high []byte, destination interface{}) {
+	start, end := codeValue(low), codeValue(high)
+	if end < start || end-start > maxRange {
+		return
+	}
+
+	code := append([]byte(nil), low...)
+	for value, i := start, 0; value <= end; value, i = value+1, i+1 {
+		setCode(code, value)
+		switch dst := destination.(type) {
+		case []byte:
+			text := append([]byte(nil), dst...)
+			if len(text) > 0 {
+				// Only the last byte is incremented
+				text[len(text)-1] += byte(i)
+			}
+			c.mappings[string(code)] = utf16Text(text)
+		case array:
+			if i < len(dst) {
+				if text, ok := dst[i].([]byte); ok {
+					c.mappings[string(code)] = utf16Text(text)
+				}
+			}
+		}
+	}
+}
+
+// codeValue returns a big-endian code as a number
+func codeValue(code []byte) uint32 {
+	var value uint32
+	for _, b := range code {
+		value = value<<8 | uint32(b)
+	}
+	return value
+}
+
+// setCode writes a number into a big-endian code of fixed length
+func setCode(code []byte, value uint32) {
+	for i := len(code) - 1; i >= 0; i-- {
+		code[i] = byte(value)
+		value >>= 8
+	}
+}
+
+// utf16Text decodes the UTF-16BE text of a mapping
+func utf16Text(data []byte) string {
+	if len(data)%2 == 1 {
+		return string(bytes.Runes(data))
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
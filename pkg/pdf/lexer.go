@@ -0,0 +1,397 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+// PDF objects are represented as nil, bool, float64, name, []byte for
+// strings, array, dict, ref, *stream and, in content streams, keyword
+
+// name is a PDF name, like /Type, without the slash
+type name string
+
+// keyword is a bare word: an operator in content streams, or a word like
+// obj and endobj in files
+type keyword string
+
+// ref is an indirect reference to an object
+type ref struct {
+	num, gen int
+}
+
+type array []interface{}
+
+type dict map[name]interface{}
+
+// stream is a dictionary with raw, still encoded data
+type stream struct {
+	dict dict
+	data []byte
+}
+
+// lexer reads PDF objects from data
+type lexer struct {
+	data []byte
+	pos  int
+}
+
+// isSpace reports whether c is PDF white space
+func isSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+// isDelimiter reports whether c ends a name, number or keyword
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return isSpace(c)
+}
+
+// skipSpace skips white space and comments
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// endOfDict is returned for ">>", endOfArray for "]"
+type delimiter byte
+
+const (
+	endOfDict  delimiter = '>'
+	endOfArray delimiter = ']'
+)
+
+// errEOF is returned when the data ends inside an object
+var errEOF = errors.New("unexpected end of data")
+
+// object reads the next object, joining "num gen R" into a ref
+func (l *lexer) object() (interface{}, error) {
+	value, err := l.token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		// A whole number may start a reference
+		if v != float64(int(v)) || v < 0 {
+			return v, nil
+		}
+		save := l.pos
+		gen, err := l.token()
+		if g, ok := gen.(float64); err == nil && ok && g == float64(int(g)) {
+			if r, err := l.token(); err == nil && r == keyword("R") {
+				return ref{num: int(v), gen: int(g)}, nil
+			}
+		}
+		l.pos = save
+		return v, nil
+	case delimiter:
+		return v, nil
+	case keyword:
+		switch v {
+		case "<<":
+			return l.dictOrStream()
+		case "[":
+			return l.array()
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+// array reads the items of an array after "["
+func (l *lexer) array() (array, error) {
+	var items array
+	for {
+		item, err := l.object()
+		if err != nil {
+			return items, err
+		}
+		if item == endOfArray {
+			return items, nil
+		}
+		if _, ok := item.(delimiter); ok {
+			continue
+		}
+		items = append(items, item)
+	}
+}
+
+// dictOrStream reads a dictionary after "<<", and the stream data when
+// the stream keyword follows it
+func (l *lexer) dictOrStream() (interface{}, error) {
+	d := make(dict)
+	for {
+		key, err := l.object()
+		if err != nil {
+			return d, err
+		}
+		if key == endOfDict {
+			break
+		}
+		k, ok := key.(name)
+		if !ok {
+			continue
+		}
+		value, err := l.object()
+		if err != nil {
+			return d, err
+		}
+		if value == endOfDict {
+			break
+		}
+		d[k] = value
+	}
+
+	save := l.pos
+	l.skipSpace()
+	if !bytes.HasPrefix(l.data[l.pos:], []byte("stream")) {
+		l.pos = save
+		return d, nil
+	}
+	l.pos += len("stream")
+	if bytes.HasPrefix(l.data[l.pos:], []byte("\r\n")) {
+		l.pos += 2
+	} else if l.pos < len(l.data) && (l.data[l.pos] == '\n' || l.data[l.pos] == '\r') {
+		l.pos++
+	}
+
+	start := l.pos
+	end := -1
+	// Trust a direct length when endstream follows it, and look for
+	// endstream otherwise
+	if length, ok := d["Length"].(float64); ok && length >= 0 && start+int(length) <= len(l.data) {
+		after := &lexer{data: l.data, pos: start + int(length)}
+		after.skipSpace()
+		if bytes.HasPrefix(l.data[after.pos:], []byte("endstream")) {
+			end = start + int(length)
+			l.pos = after.pos + len("endstream")
+		}
+	}
+	if end < 0 {
+		i := bytes.Index(l.data[start:], []byte("endstream"))
+		if i < 0 {
+			return nil, errEOF
+		}
+		end = start + i
+		l.pos = end + len("endstream")
+		// The end of line before endstream is not part of the data
+		if end > start && l.data[end-1] == '\n' {
+			end--
+		}
+		if end > start && l.data[end-1] == '\r' {
+			end--
+		}
+	}
+	return &stream{dict: d, data: l.data[start:end]}, nil
+}
+
+// token reads a number, string, name, keyword or delimiter. "<<" and "["
+// are returned as keywords for object to expand.
+func (l *lexer) token() (interface{}, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, errEOF
+	}
+
+	c := l.data[l.pos]
+	switch {
+	case c == '(':
+		l.pos++
+		return l.literalString(), nil
+	case c == '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return keyword("<<"), nil
+		}
+		l.pos++
+		return l.hexString(), nil
+	case c == '>':
+		l.pos++
+		if l.pos < len(l.data) && l.data[l.pos] == '>' {
+			l.pos++
+		}
+		return endOfDict, nil
+	case c == '[':
+		l.pos++
+		return keyword("["), nil
+	case c == ']':
+		l.pos++
+		return endOfArray, nil
+	case c == '{' || c == '}' || c == ')':
+		l.pos++
+		return keyword(string(c)), nil
+	case c == '/':
+		l.pos++
+		return l.name(), nil
+	}
+
+	start := l.pos
+	for l.pos < len(l.data) && !isDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	word := string(l.data[start:l.pos])
+	if c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9') {
+		if number, err := strconv.ParseFloat(word, 64); err == nil {
+			return number, nil
+		}
+	}
+	return keyword(word), nil
+}
+
+// name reads a name after "/", decoding #xx escapes
+func (l *lexer) name() name {
+	var b []byte
+	for l.pos < len(l.data) && !isDelimiter(l.data[l.pos]) {
+		c := l.data[l.pos]
+		if c == '#' && l.pos+2 < len(l.data) {
+			if value, err := strconv.ParseUint(string(l.data[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(value))
+				l.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		l.pos++
+	}
+	return name(b)
+}
+
+// literalString reads a string after "(", handling escapes and balanced
+// parentheses
+func (l *lexer) literalString() []byte {
+	var b []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return b
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return b
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// A line continuation
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					value := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						value = value*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(value)
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// hexString reads a string after "<"
+func (l *lexer) hexString() []byte {
+	var b []byte
+	var digits []byte
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '>' {
+			break
+		}
+		if value, ok := hexValue(c); ok {
+			digits = append(digits, value)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, 0)
+	}
+	for i := 0; i < len(digits); i += 2 {
+		b = append(b, digits[i]<<4|digits[i+1])
+	}
+	return b
+}
+
+// hexValue returns the value of a hex digit
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,332 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package pdf extracts the text of PDF documents. It reads text objects
+// with their fonts' encodings and ToUnicode maps, which covers documents
+// produced by word processors and typesetting tools; scanned documents
+// have no text to extract.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"craftcom/pkg/types"
+)
+
+// document is a parsed PDF file
+type document struct {
+	objects map[int]interface{}
+	trailer dict
+	fonts   map[interface{}]*font
+}
+
+var (
+	// objectPattern finds the start of indirect objects
+	objectPattern = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	// trailerPattern finds trailer dictionaries
+	trailerPattern = regexp.MustCompile(`trailer\s*<<`)
+)
+
+// parse reads all objects of a PDF. Objects are found by scanning rather
+// than through the cross-reference table, which is often damaged; later
+// definitions replace earlier ones, as with incremental updates.
+func parse(data []byte) (*document, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, types.ErrInputf("not a PDF file")
+	}
+
+	d := &document{
+		objects: make(map[int]interface{}),
+		trailer: make(dict),
+		fonts:   make(map[interface{}]*font),
+	}
+
+	for pos := 0; pos < len(data); {
+		match := objectPattern.FindSubmatchIndex(data[pos:])
+		if match == nil {
+			break
+		}
+		num, _ := strconv.Atoi(string(data[pos+match[2] : pos+match[3]]))
+		l := &lexer{data: data, pos: pos + match[1]}
+		value, err := l.object()
+		if err != nil {
+			pos += match[1]
+			continue
+		}
+		d.objects[num] = value
+		pos = l.pos
+	}
+
+	// Trailers and cross-reference streams name the catalog and say
+	// whether the document is encrypted
+	for _, index := range trailerPattern.FindAllIndex(data, -1) {
+		l := &lexer{data: data, pos: index[0] + len("trailer")}
+		if value, err := l.object(); err == nil {
+			if trailer, ok := value.(dict); ok {
+				for key, item := range trailer {
+					d.trailer[key] = item
+				}
+			}
+		}
+	}
+	for _, value := range d.objects {
+		if s, ok := value.(*stream); ok && s.dict["Type"] == name("XRef") {
+			for _, key := range []name{"Root", "Encrypt"} {
+				if item, ok := s.dict[key]; ok {
+					d.trailer[key] = item
+				}
+			}
+		}
+	}
+	if _, ok := d.trailer["Encrypt"]; ok {
+		return nil, types.ErrInputf("encrypted PDFs are not supported")
+	}
+
+	d.expandObjectStreams()
+	return d, nil
+}
+
+// expandObjectStreams adds the objects stored in object streams, which
+// PDF 1.5 and later use to compress most objects
+func (d *document) expandObjectStreams() {
+	for _, value := range d.objects {
+		s, ok := value.(*stream)
+		if !ok || s.dict["Type"] != name("ObjStm") {
+			continue
+		}
+		data, err := d.decode(s)
+		if err != nil {
+			continue
+		}
+		count, _ := d.resolve(s.dict["N"]).(float64)
+		first, _ := d.resolve(s.dict["First"]).(float64)
+
+		header := &lexer{data: data}
+		for i := 0; i < int(count); i++ {
+			num, err1 := header.token()
+			offset, err2 := header.token()
+			n, ok1 := num.(float64)
+			o, ok2 := offset.(float64)
+			if err1 != nil || err2 != nil || !ok1 || !ok2 {
+				break
+			}
+			// Objects defined directly in the file take precedence
+			if _, exists := d.objects[int(n)]; exists {
+				continue
+			}
+			l := &lexer{data: data, pos: int(first) + int(o)}
+			if l.pos >= len(data) {
+				continue
+			}
+			if object, err := l.object(); err == nil {
+				d.objects[int(n)] = object
+			}
+		}
+	}
+}
+
+// resolve follows references to the object they refer to
+func (d *document) resolve(value interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		r, ok := value.(ref)
+		if !ok {
+			return value
+		}
+		value = d.objects[r.num]
+	}
+	return nil
+}
+
+// dictOf returns a dictionary, or the dictionary of a stream
+func (d *document) dictOf(value interface{}) dict {
+	switch v := d.resolve(value).(type) {
+	case dict:
+		return v
+	case *stream:
+		return v.dict
+	}
+	return nil
+}
+
+// page is a page with the resources it inherits
+type page struct {
+	dict      dict
+	resources dict
+}
+
+// pages returns the pages of the document in order
+func (d *document) pages() []page {
+	root := d.dictOf(d.trailer["Root"])
+	if root == nil {
+		// Without a usable trailer, find the catalog itself
+		for _, value := range d.objects {
+			if catalog, ok := value.(dict); ok && catalog["Type"] == name("Catalog") {
+				root = catalog
+				break
+			}
+		}
+	}
+
+	var pages []page
+	visited := make(map[interface{}]bool)
+	var walk func(node interface{}, resources dict, depth int)
+	walk = func(node interface{}, resources dict, depth int) {
+		if r, ok := node.(ref); ok {
+			if visited[r] {
+				return
+			}
+			visited[r] = true
+		}
+		n := d.dictOf(node)
+		if n == nil || depth > 64 {
+			return
+		}
+		if own := d.dictOf(n["Resources"]); own != nil {
+			resources = own
+		}
+		kids, isTree := d.resolve(n["Kids"]).(array)
+		if !isTree || n["Type"] == name("Page") {
+			pages = append(pages, page{dict: n, resources: resources})
+			return
+		}
+		for _, kid := range kids {
+			walk(kid, resources, depth+1)
+		}
+	}
+	if root != nil {
+		walk(root["Pages"], nil, 0)
+	}
+	return pages
+}
+
+// contents returns the decoded content streams of a page, joined
+func (d *document) contents(p page) []byte {
+	var parts []interface{}
+	switch c := d.resolve(p.dict["Contents"]).(type) {
+	case array:
+		parts = c
+	case *stream:
+		parts = []interface{}{c}
+	}
+
+	var b bytes.Buffer
+	for _, part := range parts {
+		s, ok := d.resolve(part).(*stream)
+		if !ok {
+			continue
+		}
+		if data, err := d.decode(s); err == nil {
+			b.Write(data)
+			// Operators may not be split between streams, but tokens need
+			// separating
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
+
+// PageCount returns the number of pages of a PDF
+func PageCount(data []byte) (int, error) {
+	d, err := parse(data)
+	if err != nil {
+		return 0, err
+	}
+	return len(d.pages()), nil
+}
+
+// ExtractText returns the text of the pages of a PDF selected by a page
+// range such as "1-3,7" ("" for all), each page headed by its number
+func ExtractText(data []byte, pageRange string) (string, error) {
+	d, err := parse(data)
+	if err != nil {
+		return "", err
+	}
+
+	pages := d.pages()
+	if len(pages) == 0 {
+		return "", types.ErrInputf("no pages found in PDF")
+	}
+	selected, err := ParsePageRange(pageRange, len(pages))
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	found := false
+	for _, number := range selected {
+		text := d.pageText(pages[number-1])
+		if text != "" {
+			found = true
+		}
+		fmt.Fprintf(&b, "--- Page %d ---\n%s\n\n", number, text)
+	}
+	if !found {
+		return "", types.ErrInputf("no text found in PDF, it may be a scanned document")
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// ParsePageRange turns a page range such as "1-3,5,8-" into page numbers
+// of a document with the given number of pages; "" selects all pages
+func ParsePageRange(spec string, total int) ([]int, error) {
+	var pages []int
+	if strings.TrimSpace(spec) == "" {
+		for i := 1; i <= total; i++ {
+			pages = append(pages, i)
+		}
+		return pages, nil
+	}
+
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		if first == "" && isRange {
+			from, err = 1, nil
+		}
+		if err != nil {
+			return nil, types.ErrInputf("invalid page range %q", spec)
+		}
+		to := from
+		if isRange {
+			to = total
+			if strings.TrimSpace(last) != "" {
+				if to, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+					return nil, types.ErrInputf("invalid page range %q", spec)
+				}
+			}
+		}
+		if from < 1 || to < from || from > total {
+			return nil, types.ErrInputf("page range %q does not fit the document's %d pages", spec, total)
+		}
+		for page := from; page <= min(to, total); page++ {
+			if !seen[page] {
+				seen[page] = true
+				pages = append(pages, page)
+			}
+		}
+	}
+	return pages, nil
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildPDF generates a minimal PDF with a page per text, with a correct
+// cross-reference table. Content streams are compressed when compress is
+// set.
+func buildPDF(texts []string, compress bool) []byte {
+	var objects []string
+	kids := make([]string, len(texts))
+	for i := range texts {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(texts)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	)
+	for i, text := range texts {
+		content := []byte(fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text))
+		filter := ""
+		if compress {
+			var b bytes.Buffer
+			w := zlib.NewWriter(&b)
+			w.Write(content)
+			w.Close()
+			content, filter = b.Bytes(), " /Filter /FlateDecode"
+		}
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*i),
+			fmt.Sprintf("<< /Length %d%s >>\nstream\n%s\nendstream", len(content), filter, content),
+		)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
+
+func TestParsePageRange(t *testing.T) {
+	tests := []struct {
+		spec    string
+		total   int
+		want    []int
+		wantErr bool
+	}{
+		{"", 3, []int{1, 2, 3}, false},
+		{"2", 5, []int{2}, false},
+		{"2-4", 5, []int{2, 3, 4}, false},
+		{" 1 , 3-4 ", 5, []int{1, 3, 4}, false},
+		{"4-", 5, []int{4, 5}, false},
+		{"-2", 5, []int{1, 2}, false},
+		{"1-3,2-4", 5, []int{1, 2, 3, 4}, false},
+		{"3-9", 5, []int{3, 4, 5}, false},
+		{"0", 5, nil, true},
+		{"6", 5, nil, true},
+		{"4-2", 5, nil, true},
+		{"a-b", 5, nil, true},
+		{"1,,2", 5, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePageRange(tt.spec, tt.total)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePageRange(%q, %d) error = %v, want error %v", tt.spec, tt.total, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePageRange(%q, %d) = %v, want %v", tt.spec, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestPageCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    int
+		wantErr bool
+	}{
+		{"one page", buildPDF([]string{"a"}, false), 1, false},
+		{"three pages", buildPDF([]string{"a", "b", "c"}, true), 3, false},
+		{"not a pdf", []byte("hello"), 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := PageCount(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: PageCount() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: PageCount() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	texts := []string{"First page", "Second page", "Third page"}
+
+	tests := []struct {
+		name      string
+		compress  bool
+		pageRange string
+		want      []string
+		notWant   []string
+		wantErr   bool
+	}{
+		{"all pages", false, "", texts, nil, false},
+		{"compressed", true, "", texts, nil, false},
+		{"one page", false, "2", []string{"Second page"}, []string{"First page", "Third page"}, false},
+		{"range", true, "2-3", []string{"Second page", "Third page"}, []string{"First page"}, false},
+		{"out of range", false, "4", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ExtractText(buildPDF(texts, tt.compress), tt.pageRange)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ExtractText() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: ExtractText() = %q, want it to contain %q", tt.name, got, want)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(got, notWant) {
+				t.Errorf("%s: ExtractText() = %q, want it not to contain %q", tt.name, got, notWant)
+			}
+		}
+	}
+}
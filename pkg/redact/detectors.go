@@ -0,0 +1,220 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redact
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+)
+
+// detector finds one kind of sensitive value
+type detector struct {
+	kind    string
+	pattern *regexp.Regexp
+	// group is the submatch redacted, 0 for the whole match. When the
+	// pattern has alternatives in later groups, the first that matched is
+	// redacted.
+	group int
+	// keep reports whether a match should be left as it is
+	keep func(value string) bool
+}
+
+// replace replaces the values the detector finds with placeholders
+func (d detector) replace(text string, placeholder func(kind, value string) string) string {
+	matches := d.pattern.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return text
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := d.value(match)
+		if start < 0 {
+			continue
+		}
+		value := text[start:end]
+		// Text redacted by an earlier detector is not redacted again
+		if placeholderPattern.MatchString(value) || (d.keep != nil && d.keep(value)) {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(placeholder(d.kind, value))
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// value returns the bounds of the redacted submatch of a match, or -1
+// when none matched
+func (d detector) value(match []int) (int, int) {
+	if d.group == 0 {
+		return match[0], match[1]
+	}
+	for group := d.group; 2*group < len(match); group++ {
+		if match[2*group] >= 0 {
+			return match[2*group], match[2*group+1]
+		}
+	}
+	return -1, -1
+}
+
+// assignmentPattern matches a value assigned to a key named after one of
+// keywords, as in .env files, shell scripts, JSON, YAML and code. The key
+// must be an identifier with a keyword as one of its parts, like
+// DB_PASSWORD, db.password or dbPassword, so words such as "tokenizer" do
+// not count. Quoted values are matched up to their closing quote.
+func assignmentPattern(keywords ...string) *regexp.Regexp {
+	camel := make([]string, 0, len(keywords))
+	for _, keyword := range keywords {
+		camel = append(camel, strings.ToUpper(keyword[:1])+"(?i:"+keyword[1:]+")")
+	}
+
+	key := `\b(?:[A-Za-z0-9]+[_.-])*` +
+		`(?:(?i:` + strings.Join(keywords, "|") + `)|[A-Za-z0-9]*[a-z0-9](?:` + strings.Join(camel, "|") + `))` +
+		`(?:[_.-][A-Za-z0-9]+)*`
+	value := `"([^"\n]+)"|'([^'\n]+)'|` + "`([^`\\n]+)`" + `|([^\s"'` + "`" + `,;(){}=][^\s"'` + "`" + `,;(){}]{3,}\(?)`
+	return regexp.MustCompile(key + `["']?\s*(?::=|[:=])\s*(?:` + value + `)`)
+}
+
+// secretDetectors find credentials, most specific first
+var secretDetectors = []detector{
+	{kind: "PRIVATE_KEY", pattern: regexp.MustCompile(`(?s)-----BEGIN[A-Z0-9 ]*PRIVATE KEY[A-Z ]*-----.*?-----END[A-Z0-9 ]*PRIVATE KEY[A-Z ]*-----`)},
+	{kind: "API_KEY", pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{kind: "API_KEY", pattern: regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`)},
+	{kind: "API_KEY", pattern: regexp.MustCompile(`\b[rs]k_(?:live|test)_[0-9A-Za-z]{16,}\b`)},
+	{kind: "ACCESS_KEY", pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{kind: "TOKEN", pattern: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{kind: "TOKEN", pattern: regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,}\b`)},
+	{kind: "TOKEN", pattern: regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{kind: "TOKEN", pattern: regexp.MustCompile(`\bxox[abeprs]-[A-Za-z0-9-]{10,}`)},
+	{kind: "TOKEN", pattern: regexp.MustCompile(`\bnpm_[A-Za-z0-9]{36}\b`)},
+	{kind: "TOKEN", pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+	{kind: "TOKEN", pattern: regexp.MustCompile(`(?i)\b(?:bearer|basic)\s+([A-Za-z0-9._~+/-]{16,}=*)`), group: 1},
+	{kind: "PASSWORD", pattern: regexp.MustCompile(`\b[A-Za-z][A-Za-z0-9+.-]*://[^/\s:@]*:([^/\s@]+)@`), group: 1, keep: isReference},
+	{kind: "PASSWORD", pattern: assignmentPattern("password", "passwd", "passphrase", "pass", "pwd"), group: 1, keep: isPlainValue},
+	{kind: "SECRET", pattern: assignmentPattern("secret", "token", "api[_-]?key", "access[_-]?key", "private[_-]?key", "credentials?", "auth[_-]?key"), group: 1, keep: isPlainValue},
+}
+
+// isReference reports whether a value refers to a secret rather than
+// holding it, like $PASSWORD or ${TOKEN}
+func isReference(value string) bool {
+	return strings.HasPrefix(value, "$") || strings.HasPrefix(value, "%")
+}
+
+// callPattern matches an unquoted value that is a function call
+var callPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_.$]*\($`)
+
+// isPlainValue reports whether an assigned value cannot be a secret, such
+// as a number, a boolean, a reference or a function call
+func isPlainValue(value string) bool {
+	if isReference(value) {
+		return true
+	}
+	// A call such as getToken() computes the value
+	if callPattern.MatchString(value) {
+		return true
+	}
+	switch strings.ToLower(value) {
+	case "true", "false", "null", "none", "nil", "yes", "no":
+		return true
+	}
+	return strings.Trim(value, "0123456789.") == ""
+}
+
+// emailDetector finds email addresses, except for example domains and
+// git@ remotes
+var emailDetector = detector{
+	kind:    "EMAIL",
+	pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`),
+	keep: func(value string) bool {
+		local, domain, _ := strings.Cut(value, "@")
+		domain = strings.ToLower(domain)
+		return local == "git" || domain == "example.com" || domain == "example.org" || domain == "example.net"
+	},
+}
+
+// ipDetectors find IP addresses other than loopback and unspecified ones
+var ipDetectors = []detector{
+	{
+		kind:    "IP",
+		pattern: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\b`),
+		keep:    isLocalIP,
+	},
+	{
+		kind:    "IP",
+		pattern: regexp.MustCompile(`(?i)\b(?:[0-9a-f]{1,4}:){7}[0-9a-f]{1,4}\b|\b(?:[0-9a-f]{1,4}:){1,6}:(?:[0-9a-f]{1,4}:){0,5}[0-9a-f]{1,4}\b`),
+		keep: func(value string) bool {
+			// Fewer groups are more likely to be something like a C++ scope
+			groups := 0
+			for _, group := range strings.Split(value, ":") {
+				if group != "" {
+					groups++
+				}
+			}
+			return groups < 3 || isLocalIP(value)
+		},
+	},
+}
+
+// isLocalIP reports whether a match is not an address worth redacting
+func isLocalIP(value string) bool {
+	ip := net.ParseIP(value)
+	return ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.Equal(net.IPv4bcast)
+}
+
+// commonNames are user and host names too generic to reveal anything,
+// which would otherwise garble ordinary words in requests
+var commonNames = map[string]bool{
+	"root": true, "admin": true, "user": true, "ubuntu": true,
+	"localhost": true, "debian": true,
+}
+
+// identityDetectors find the current user and host name
+func identityDetectors() []detector {
+	var detectors []detector
+	add := func(kind, name string) {
+		if len(name) < 3 || commonNames[strings.ToLower(name)] {
+			return
+		}
+		detectors = append(detectors, detector{kind: kind, pattern: regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\b`)})
+	}
+
+	if current, err := user.Current(); err == nil {
+		// Windows user names include the domain
+		name := current.Username
+		if i := strings.LastIndex(name, `\`); i >= 0 {
+			name = name[i+1:]
+		}
+		add("USER", name)
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		add("HOST", hostname)
+		if short, _, ok := strings.Cut(hostname, "."); ok {
+			add("HOST", short)
+		}
+	}
+	return detectors
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package redact replaces secrets and personal data in text sent to
+// providers with placeholders, and puts the values back in responses so
+// suggested commands still work locally.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Options selects what is redacted besides secrets, which always are
+type Options struct {
+	// Emails redacts email addresses
+	Emails bool
+	// IPAddresses redacts IPv4 and IPv6 addresses other than loopback
+	IPAddresses bool
+	// Identity redacts the user and host name
+	Identity bool
+	// Patterns are extra regular expressions to redact; the first group is
+	// redacted when there is one, the whole match otherwise
+	Patterns []string
+}
+
+// Redactor replaces sensitive values with placeholders such as
+// REDACTED_API_KEY_1. The same value always gets the same placeholder, so
+// one Redactor is used for a whole chat.
+type Redactor struct {
+	detectors []detector
+
+	mu           sync.Mutex
+	placeholders map[string]string // value to placeholder
+	values       map[string]string // placeholder to value
+	counts       map[string]int    // placeholders made per kind
+}
+
+// New creates a redactor with the given options. It fails when one of the
+// extra patterns is not a valid regular expression.
+func New(options Options) (*Redactor, error) {
+	detectors := append([]detector(nil), secretDetectors...)
+	if options.Emails {
+		detectors = append(detectors, emailDetector)
+	}
+	if options.IPAddresses {
+		detectors = append(detectors, ipDetectors...)
+	}
+	if options.Identity {
+		detectors = append(detectors, identityDetectors()...)
+	}
+	for _, pattern := range options.Patterns {
+		re, err := CompilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		detectors = append(detectors, detector{kind: "SECRET", pattern: re, group: min(re.NumSubexp(), 1)})
+	}
+
+	return &Redactor{
+		detectors:    detectors,
+		placeholders: make(map[string]string),
+		values:       make(map[string]string),
+		counts:       make(map[string]int),
+	}, nil
+}
+
+// CompilePattern compiles an extra pattern, describing the problem when it
+// is invalid
+func CompilePattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	return re, nil
+}
+
+// Redact returns text with sensitive values replaced by placeholders
+func (r *Redactor) Redact(text string) string {
+	if text == "" {
+		return text
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Values seen before, such as a restored command sent back as context,
+	// get their placeholder even when no detector would match them alone
+	text = r.replaceKnown(text)
+
+	for _, d := range r.detectors {
+		text = d.replace(text, r.placeholder)
+	}
+	return text
+}
+
+// Restore returns text with placeholders replaced by the values they stand
+// for
+func (r *Redactor) Restore(text string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.values) == 0 || !strings.Contains(text, placeholderPrefix) {
+		return text
+	}
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if value, ok := r.values[placeholder]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// Count returns how many distinct values were redacted
+func (r *Redactor) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.values)
+}
+
+const placeholderPrefix = "REDACTED_"
+
+// placeholderPattern matches the placeholders made by placeholder
+var placeholderPattern = regexp.MustCompile(`\bREDACTED_[A-Z_]+_[0-9]+\b`)
+
+// placeholder returns the placeholder for a value, making a new one the
+// first time the value is seen; the caller must hold r.mu
+func (r *Redactor) placeholder(kind, value string) string {
+	if placeholder, ok := r.placeholders[value]; ok {
+		return placeholder
+	}
+	r.counts[kind]++
+	placeholder := fmt.Sprintf("%s%s_%d", placeholderPrefix, kind, r.counts[kind])
+	r.placeholders[value] = placeholder
+	r.values[placeholder] = value
+	return placeholder
+}
+
+// replaceKnown replaces values redacted before, longest first so a value
+// containing another is replaced whole; the caller must hold r.mu
+func (r *Redactor) replaceKnown(text string) string {
+	if len(r.placeholders) == 0 {
+		return text
+	}
+
+	known := make([]string, 0, len(r.placeholders))
+	for value := range r.placeholders {
+		known = append(known, value)
+	}
+	sort.Slice(known, func(i, j int) bool { return len(known[i]) > len(known[j]) })
+
+	pairs := make([]string, 0, 2*len(known))
+	for _, value := range known {
+		pairs = append(pairs, value, r.placeholders[value])
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+
+	"craftcom/pkg/types"
+)
+
+// PassphraseEnv holds the passphrase of encrypted secret files, for
+// scripts that cannot answer a prompt
+const PassphraseEnv = "CRAFTCOM_SECRETS_PASSPHRASE"
+
+// PassphrasePrompt asks for the passphrase of an encrypted file when
+// PassphraseEnv is not set. Without it such files cannot be read.
+var PassphrasePrompt func(path string) (string, error)
+
+var (
+	// Decrypted files, so the passphrase is asked for once per process
+	fileCache   = make(map[string]string)
+	fileCacheMu sync.Mutex
+)
+
+// FromFile decrypts a secret file written by WriteFile or by
+// "age --passphrase"; both binary and armored files are accepted
+func FromFile(path string) (string, error) {
+	path = expandHome(path)
+
+	fileCacheMu.Lock()
+	defer fileCacheMu.Unlock()
+
+	if secret, ok := fileCache[path]; ok {
+		return secret, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", types.ErrConfigurationf("failed to read secret file: %v", err)
+	}
+
+	passphrase, err := filePassphrase(path)
+	if err != nil {
+		return "", err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return "", types.ErrConfigurationf("invalid passphrase: %v", err)
+	}
+
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	}
+
+	reader, err := age.Decrypt(src, identity)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return "", types.ErrPermissionf("wrong passphrase for %s", path)
+		}
+		return "", types.ErrConfigurationf("failed to decrypt %s: %v", path, err)
+	}
+	plain, err := io.ReadAll(reader)
+	if err != nil {
+		return "", types.ErrConfigurationf("failed to decrypt %s: %v", path, err)
+	}
+
+	secret := strings.TrimSpace(string(plain))
+	if secret == "" {
+		return "", types.ErrConfigurationf("secret file %s is empty", path)
+	}
+	fileCache[path] = secret
+	return secret, nil
+}
+
+// WriteFile encrypts a secret with a passphrase into an armored age file
+// that only the owner can read
+func WriteFile(path, secret, passphrase string) error {
+	path = expandHome(path)
+
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return types.ErrConfigurationf("invalid passphrase: %v", err)
+	}
+
+	var buf bytes.Buffer
+	armored := armor.NewWriter(&buf)
+	writer, err := age.Encrypt(armored, recipient)
+	if err != nil {
+		return types.ErrConfigurationf("failed to encrypt secret: %v", err)
+	}
+	if _, err := io.WriteString(writer, secret+"\n"); err != nil {
+		return types.ErrConfigurationf("failed to encrypt secret: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return types.ErrConfigurationf("failed to encrypt secret: %v", err)
+	}
+	if err := armored.Close(); err != nil {
+		return types.ErrConfigurationf("failed to encrypt secret: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return types.ErrConfigurationf("failed to create secret directory: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return types.ErrConfigurationf("failed to write secret file: %v", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		return types.ErrConfigurationf("failed to set secret file permissions: %v", err)
+	}
+
+	fileCacheMu.Lock()
+	fileCache[path] = secret
+	fileCacheMu.Unlock()
+	return nil
+}
+
+// filePassphrase returns the passphrase from PassphraseEnv or the prompt
+func filePassphrase(path string) (string, error) {
+	if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+		return passphrase, nil
+	}
+	if PassphrasePrompt == nil {
+		return "", types.ErrConfigurationf("%s is encrypted; set %s to unlock it", path, PassphraseEnv)
+	}
+
+	passphrase, err := PassphrasePrompt(path)
+	if err != nil {
+		return "", types.ErrInputf("failed to read passphrase: %v", err)
+	}
+	return strings.TrimRight(passphrase, "\r\n"), nil
+}
+
+// expandHome resolves a leading ~ to the home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(homeDir, path[1:])
+}
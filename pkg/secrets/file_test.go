@@ -0,0 +1,172 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package secrets
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"craftcom/pkg/types"
+)
+
+// forget drops decrypted files so they are read from disk again
+func forget(t *testing.T) {
+	t.Helper()
+	fileCacheMu.Lock()
+	defer fileCacheMu.Unlock()
+	for path := range fileCache {
+		delete(fileCache, path)
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets", "gemini.age")
+	if err := WriteFile(path, "AIza-secret", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode&0o077 != 0 && os.PathSeparator == '/' {
+		t.Errorf("secret file mode = %v, want only the owner to read it", mode)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "AIza-secret") {
+		t.Fatal("secret file holds the secret in plain text")
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		want       string
+		wantErr    types.ErrorType
+	}{
+		{"right passphrase", "correct horse", "AIza-secret", ""},
+		{"wrong passphrase", "battery staple", "", types.ErrPermission},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forget(t)
+			t.Setenv(PassphraseEnv, tt.passphrase)
+
+			got, err := FromFile(path)
+			if tt.wantErr != "" {
+				if !types.IsErrorType(err, tt.wantErr) {
+					t.Errorf("FromFile error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("FromFile = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeBinaryFile encrypts secret as "age --passphrase" does, without
+// armor, with a low work factor to keep tests fast
+func writeBinaryFile(t *testing.T, path, secret, passphrase string) {
+	t.Helper()
+
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient.SetWorkFactor(10)
+
+	var buf bytes.Buffer
+	writer, err := age.Encrypt(&buf, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(writer, secret+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFromFileBinary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.age")
+	writeBinaryFile(t, path, "binary-secret", "correct horse")
+
+	forget(t)
+	t.Setenv(PassphraseEnv, "correct horse")
+	got, err := FromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "binary-secret" {
+		t.Errorf("FromFile = %q, want binary-secret", got)
+	}
+}
+
+func TestFromFilePassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.age")
+	writeBinaryFile(t, path, "prompted-secret", "correct horse")
+
+	// Setenv restores the variable after the test
+	t.Setenv(PassphraseEnv, "")
+	os.Unsetenv(PassphraseEnv)
+	defer func(prompt func(string) (string, error)) { PassphrasePrompt = prompt }(PassphrasePrompt)
+
+	forget(t)
+	PassphrasePrompt = nil
+	if _, err := FromFile(path); err == nil || !strings.Contains(err.Error(), PassphraseEnv) {
+		t.Errorf("FromFile without a passphrase: err = %v, want a hint to set %s", err, PassphraseEnv)
+	}
+
+	prompts := 0
+	PassphrasePrompt = func(string) (string, error) {
+		prompts++
+		return "correct horse\n", nil
+	}
+	for i := 0; i < 2; i++ {
+		got, err := FromFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "prompted-secret" {
+			t.Errorf("FromFile = %q, want prompted-secret", got)
+		}
+	}
+	if prompts != 1 {
+		t.Errorf("passphrase asked for %d times, want once", prompts)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package secrets
+
+import (
+	"context"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"craftcom/pkg/types"
+)
+
+// Secret Service names, see
+// https://specifications.freedesktop.org/secret-service/latest/
+const (
+	secretServiceName   = "org.freedesktop.secrets"
+	secretServicePath   = "/org/freedesktop/secrets"
+	secretServiceIface  = "org.freedesktop.Secret.Service"
+	secretItemIface     = "org.freedesktop.Secret.Item"
+	secretPromptIface   = "org.freedesktop.Secret.Prompt"
+	secretSessionIface  = "org.freedesktop.Secret.Session"
+	defaultCollection   = "/org/freedesktop/secrets/aliases/default"
+	keyringTimeout      = 10 * time.Second
+	keyringPromptWindow = 2 * time.Minute
+)
+
+// dbusSecret is the Secret struct of the Secret Service API
+type dbusSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// keyring is an open Secret Service session
+type keyring struct {
+	conn    *dbus.Conn
+	service dbus.BusObject
+	session dbus.ObjectPath
+}
+
+// openKeyring connects to the Secret Service on the session bus. The
+// "plain" algorithm is used; the session bus is private to the user.
+func openKeyring(ctx context.Context) (*keyring, error) {
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return nil, types.ErrSystemf("Secret Service is not available: %v", err)
+	}
+
+	service := conn.Object(secretServiceName, secretServicePath)
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := service.CallWithContext(ctx, secretServiceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		conn.Close()
+		return nil, types.ErrSystemf("Secret Service is not available: %v", err)
+	}
+
+	return &keyring{conn: conn, service: service, session: session}, nil
+}
+
+// Close ends the session and the connection
+func (k *keyring) Close() {
+	k.conn.Object(secretServiceName, k.session).Call(secretSessionIface+".Close", 0)
+	k.conn.Close()
+}
+
+// KeyringAvailable reports whether a Secret Service answers on the
+// session bus
+func KeyringAvailable(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, keyringTimeout)
+	defer cancel()
+
+	k, err := openKeyring(ctx)
+	if err != nil {
+		return false
+	}
+	k.Close()
+	return true
+}
+
+// FromKeyring looks up the secret stored for service and account,
+// unlocking the item if needed, which may show a desktop prompt
+func FromKeyring(ctx context.Context, service, account string) (string, error) {
+	k, err := openKeyring(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer k.Close()
+
+	attributes := map[string]string{"service": service, "account": account}
+	var unlocked, locked []dbus.ObjectPath
+	if err := k.service.CallWithContext(ctx, secretServiceIface+".SearchItems", 0, attributes).Store(&unlocked, &locked); err != nil {
+		return "", types.ErrSystemf("failed to search Secret Service: %v", err)
+	}
+
+	if len(unlocked) == 0 && len(locked) > 0 {
+		var prompt dbus.ObjectPath
+		if err := k.service.CallWithContext(ctx, secretServiceIface+".Unlock", 0, locked[:1]).Store(&unlocked, &prompt); err != nil {
+			return "", types.ErrSystemf("failed to unlock Secret Service item: %v", err)
+		}
+		if len(unlocked) == 0 {
+			if err := k.prompt(ctx, prompt); err != nil {
+				return "", err
+			}
+			unlocked = locked[:1]
+		}
+	}
+	if len(unlocked) == 0 {
+		return "", types.ErrConfigurationf("no Secret Service item for service %q and account %q", service, account)
+	}
+
+	var secret dbusSecret
+	item := k.conn.Object(secretServiceName, unlocked[0])
+	if err := item.CallWithContext(ctx, secretItemIface+".GetSecret", 0, k.session).Store(&secret); err != nil {
+		return "", types.ErrSystemf("failed to read Secret Service item: %v", err)
+	}
+	if len(secret.Value) == 0 {
+		return "", types.ErrConfigurationf("Secret Service item for service %q and account %q is empty", service, account)
+	}
+	return string(secret.Value), nil
+}
+
+// StoreKeyring saves a secret in the default collection under service and
+// account, replacing an existing item
+func StoreKeyring(ctx context.Context, service, account, label, secret string) error {
+	k, err := openKeyring(ctx)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	properties := map[string]dbus.Variant{
+		secretItemIface + ".Label":      dbus.MakeVariant(label),
+		secretItemIface + ".Attributes": dbus.MakeVariant(map[string]string{"service": service, "account": account}),
+	}
+	value := dbusSecret{
+		Session:     k.session,
+		Value:       []byte(secret),
+		ContentType: "text/plain",
+	}
+
+	var item, prompt dbus.ObjectPath
+	collection := k.conn.Object(secretServiceName, defaultCollection)
+	if err := collection.CallWithContext(ctx, "org.freedesktop.Secret.Collection.CreateItem", 0, properties, value, true).Store(&item, &prompt); err != nil {
+		return types.ErrSystemf("failed to store secret: %v", err)
+	}
+	if item == "/" {
+		return k.prompt(ctx, prompt)
+	}
+	return nil
+}
+
+// prompt shows a Secret Service prompt, such as an unlock dialog, and
+// waits for the user to answer it
+func (k *keyring) prompt(ctx context.Context, path dbus.ObjectPath) error {
+	if path == "" || path == "/" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, keyringPromptWindow)
+	defer cancel()
+
+	if err := k.conn.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface(secretPromptIface),
+		dbus.WithMatchMember("Completed"),
+	); err != nil {
+		return types.ErrSystemf("failed to watch Secret Service prompt: %v", err)
+	}
+
+	signals := make(chan *dbus.Signal, 1)
+	k.conn.Signal(signals)
+	defer k.conn.RemoveSignal(signals)
+
+	if err := k.conn.Object(secretServiceName, path).CallWithContext(ctx, secretPromptIface+".Prompt", 0, "").Err; err != nil {
+		return types.ErrSystemf("failed to show Secret Service prompt: %v", err)
+	}
+
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Path != path || signal.Name != secretPromptIface+".Completed" {
+				continue
+			}
+			if len(signal.Body) > 0 && signal.Body[0] == true {
+				return types.ErrPermissionf("Secret Service prompt was dismissed")
+			}
+			return nil
+		case <-ctx.Done():
+			return types.ErrTimeoutf("Secret Service prompt was not answered")
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package secrets reads API keys from places other than the config file:
+// environment variables, commands such as password managers, files
+// encrypted with age and the freedesktop Secret Service.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"craftcom/pkg/types"
+)
+
+// commandTimeout bounds how long a secret command may run, leaving time
+// for password managers that ask to be unlocked
+const commandTimeout = 2 * time.Minute
+
+// commandWaitDelay bounds how long output is waited for once the command
+// is killed, as processes it started may still hold it open
+const commandWaitDelay = time.Second
+
+// Source describes where a secret is read from. The first field that is
+// set is used.
+type Source struct {
+	Env     string // Environment variable holding the secret
+	Command string // Command printing the secret, run by the shell
+	File    string // age file encrypted with a passphrase
+	Keyring bool   // Secret Service item matching Service and Account
+
+	Service string // Secret Service "service" attribute
+	Account string // Secret Service "account" attribute
+}
+
+// IsZero reports whether no source is configured
+func (s Source) IsZero() bool {
+	return s.Env == "" && s.Command == "" && s.File == "" && !s.Keyring
+}
+
+// String names the source for messages, without revealing the secret
+func (s Source) String() string {
+	switch {
+	case s.Env != "":
+		return "environment variable " + s.Env
+	case s.Command != "":
+		return "command " + s.Command
+	case s.File != "":
+		return "file " + s.File
+	case s.Keyring:
+		return "Secret Service"
+	default:
+		return "no source"
+	}
+}
+
+// Resolve reads the secret from the source
+func Resolve(ctx context.Context, source Source) (string, error) {
+	switch {
+	case source.Env != "":
+		return FromEnv(source.Env)
+	case source.Command != "":
+		return FromCommand(ctx, source.Command)
+	case source.File != "":
+		return FromFile(source.File)
+	case source.Keyring:
+		return FromKeyring(ctx, source.Service, source.Account)
+	default:
+		return "", types.ErrConfigurationf("no secret source configured")
+	}
+}
+
+// FromEnv reads a secret from an environment variable
+func FromEnv(name string) (string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return "", types.ErrConfigurationf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// FromCommand runs a command and uses the first line it prints, so
+// "pass show gemini" works with entries that hold more than the key
+func FromCommand(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+
+	// Password managers may prompt on the terminal
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = commandWaitDelay
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", types.ErrTimeoutf("secret command %q timed out", command)
+		}
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return "", types.ErrExecutionf("secret command %q failed: %s", command, message)
+	}
+
+	line, _, _ := strings.Cut(stdout.String(), "\n")
+	if line = strings.TrimSpace(line); line == "" {
+		return "", types.ErrExecutionf("secret command %q printed nothing", command)
+	}
+	return line, nil
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package secrets
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"craftcom/pkg/types"
+)
+
+func TestFromCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run by sh")
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		want    string
+		wantErr string
+	}{
+		{name: "first line", command: `printf 'key-123\nuser: me\nurl: x\n'`, want: "key-123"},
+		{name: "surrounding space", command: `printf '  key-123  \n'`, want: "key-123"},
+		{name: "no newline", command: `printf key-123`, want: "key-123"},
+		{name: "failing command", command: `echo locked >&2; exit 1`, wantErr: `secret command "echo locked >&2; exit 1" failed: locked`},
+		{name: "failing without output", command: `exit 3`, wantErr: "exit status 3"},
+		{name: "empty output", command: `printf '\nkey\n'`, wantErr: "printed nothing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromCommand(context.Background(), tt.command)
+			if tt.wantErr != "" {
+				if err == nil || !types.IsErrorType(err, types.ErrExecution) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("FromCommand(%q) error = %v, want %q", tt.command, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("FromCommand(%q) = %q, want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromCommandTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run by sh")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := FromCommand(ctx, "sleep 10; echo key")
+	if !types.IsErrorType(err, types.ErrTimeout) {
+		t.Errorf("error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command ran for %v after the timeout", elapsed)
+	}
+}
+
+func TestResolveSourceOrder(t *testing.T) {
+	t.Setenv("CRAFTCOM_TEST_KEY", "from-env")
+	dir := t.TempDir()
+	t.Setenv(PassphraseEnv, "correct horse")
+	file := filepath.Join(dir, "key.age")
+	writeBinaryFile(t, file, "from-file", "correct horse")
+	forget(t)
+
+	tests := []struct {
+		name   string
+		source Source
+		want   string
+	}{
+		{"environment first", Source{Env: "CRAFTCOM_TEST_KEY", Command: "echo from-command", File: file}, "from-env"},
+		{"command before file", Source{Command: "echo from-command", File: file}, "from-command"},
+		{"file", Source{File: file}, "from-file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && tt.source.Env == "" && tt.source.Command != "" {
+				t.Skip("commands are run by sh")
+			}
+			got, err := Resolve(context.Background(), tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve(%v) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Resolve(context.Background(), Source{}); err == nil {
+		t.Error("Resolve without a source returned no error")
+	}
+	if _, err := Resolve(context.Background(), Source{Env: "CRAFTCOM_TEST_UNSET"}); err == nil {
+		t.Error("Resolve of an unset variable returned no error")
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package types
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultDeniedFiles are files never sent to a provider unless the policy
+// is changed: keys, credentials and environment files
+var DefaultDeniedFiles = []string{
+	"**/.ssh/**",
+	"**/.gnupg/**",
+	"**/.aws/**",
+	"**/.kube/**",
+	"**/.docker/config.json",
+	"**/*.pem",
+	"**/*.key",
+	"**/*.p12",
+	"**/*.pfx",
+	"id_rsa*",
+	"id_ecdsa*",
+	"id_ed25519*",
+	".env*",
+	".netrc",
+	".git-credentials",
+	"/etc/shadow",
+}
+
+// FileAccessPolicy decides which files may be read and sent to a provider
+type FileAccessPolicy struct {
+	// AllowedRoots are the directories files must be in; empty allows any.
+	// Relative roots are relative to the current directory.
+	AllowedRoots []string
+
+	// Denied are glob patterns of files that are never read. Patterns
+	// without a slash match the file name, like ".env*"; "**" matches any
+	// number of directories, like "**/.ssh/**".
+	Denied []string
+}
+
+// DefaultFileAccessPolicy allows any file except the DefaultDeniedFiles
+func DefaultFileAccessPolicy() FileAccessPolicy {
+	return FileAccessPolicy{Denied: append([]string(nil), DefaultDeniedFiles...)}
+}
+
+// Check returns the file path refers to, with symlinks resolved, or an
+// error when the policy does not allow reading it. Both the path as given
+// and the resolved file are checked, so a link cannot hide a denied file.
+func (p FileAccessPolicy) Check(file string) (string, error) {
+	absolute, err := filepath.Abs(ExpandHome(file))
+	if err != nil {
+		return "", ErrInputf("invalid path %s: %v", file, err)
+	}
+	resolved, err := filepath.EvalSymlinks(absolute)
+	if err != nil {
+		return "", ErrInputf("file not found: %s", file)
+	}
+
+	for _, pattern := range p.Denied {
+		if matchGlob(pattern, absolute) || matchGlob(pattern, resolved) {
+			return "", ErrPermissionf("%s is denied by the file access policy (%s)", file, pattern)
+		}
+	}
+
+	if len(p.AllowedRoots) == 0 {
+		return resolved, nil
+	}
+	for _, root := range p.AllowedRoots {
+		if within(resolved, resolveRoot(root)) {
+			return resolved, nil
+		}
+	}
+	return "", ErrPermissionf("%s is outside the allowed directories (%s)", file, strings.Join(p.AllowedRoots, ", "))
+}
+
+// resolveRoot returns an allowed root as an absolute path with symlinks
+// resolved, so it compares with resolved files
+func resolveRoot(root string) string {
+	absolute, err := filepath.Abs(ExpandHome(root))
+	if err != nil {
+		return root
+	}
+	if resolved, err := filepath.EvalSymlinks(absolute); err == nil {
+		return resolved
+	}
+	return absolute
+}
+
+// within reports whether file is dir or inside it
+func within(file, dir string) bool {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// matchGlob reports whether an absolute file path matches a policy pattern
+func matchGlob(pattern, file string) bool {
+	pattern = filepath.ToSlash(ExpandHome(pattern))
+	file = filepath.ToSlash(file)
+
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(file))
+		return matched
+	}
+	if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "**") && filepath.VolumeName(pattern) == "" {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(file, "/"))
+}
+
+// matchSegments matches path segments, with "**" standing for any number
+// of them
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(name); i >= 0; i-- {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ExpandHome replaces a leading ~ with the home directory
+func ExpandHome(file string) string {
+	if file != "~" && !strings.HasPrefix(file, "~/") && !strings.HasPrefix(file, `~\`) {
+		return file
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return file
+	}
+	return filepath.Join(home, file[1:])
+}
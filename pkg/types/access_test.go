@@ -0,0 +1,159 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		file    string
+		want    bool
+	}{
+		{".env*", "/srv/app/.env", true},
+		{".env*", "/srv/app/.env.local", true},
+		{".env*", "/srv/app/env.txt", false},
+		{"**/*.pem", "/etc/ssl/server.pem", true},
+		{"**/*.pem", "/etc/ssl/server.pem.txt", false},
+		{"**/.ssh/**", "/home/me/.ssh/id_rsa", true},
+		{"**/.ssh/**", "/home/me/.ssh/keys/deploy", true},
+		{"**/.ssh/**", "/home/me/ssh/id_rsa", false},
+		{"**/.docker/config.json", "/home/me/.docker/config.json", true},
+		{"**/.docker/config.json", "/home/me/.docker/other.json", false},
+		{"/etc/shadow", "/etc/shadow", true},
+		{"/etc/shadow", "/backup/etc/shadow", false},
+		{"secrets/*.yaml", "/srv/app/secrets/db.yaml", true},
+		{"secrets/*.yaml", "/srv/app/secrets/nested/db.yaml", false},
+		{"id_rsa*", "/home/me/id_rsa.pub", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.file, func(t *testing.T) {
+			if got := matchGlob(tt.pattern, tt.file); got != tt.want {
+				t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileAccessPolicyCheck(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", dir)
+
+	project := filepath.Join(dir, "project")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{project, outside, filepath.Join(dir, ".ssh")} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{
+		filepath.Join(project, "notes.txt"),
+		filepath.Join(project, ".env"),
+		filepath.Join(outside, "data.txt"),
+		filepath.Join(dir, ".ssh", "id_ed25519"),
+	} {
+		if err := os.WriteFile(file, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Links must not hide a denied file or one outside the allowed roots
+	links := map[string]string{
+		filepath.Join(project, "config.txt"): filepath.Join(project, ".env"),
+		filepath.Join(project, "data.txt"):   filepath.Join(outside, "data.txt"),
+		filepath.Join(project, "key.txt"):    filepath.Join(dir, ".ssh", "id_ed25519"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+
+	defaultPolicy := DefaultFileAccessPolicy()
+	rooted := FileAccessPolicy{AllowedRoots: []string{project}, Denied: DefaultDeniedFiles}
+
+	tests := []struct {
+		name    string
+		policy  FileAccessPolicy
+		file    string
+		want    string
+		wantErr ErrorType
+	}{
+		{"allowed", defaultPolicy, filepath.Join(project, "notes.txt"), filepath.Join(project, "notes.txt"), ""},
+		{"denied name", defaultPolicy, filepath.Join(project, ".env"), "", ErrPermission},
+		{"denied through a link", defaultPolicy, filepath.Join(project, "config.txt"), "", ErrPermission},
+		{"denied directory", defaultPolicy, "~/.ssh/id_ed25519", "", ErrPermission},
+		{"denied directory through a link", defaultPolicy, filepath.Join(project, "key.txt"), "", ErrPermission},
+		{"link resolved", defaultPolicy, filepath.Join(project, "data.txt"), filepath.Join(outside, "data.txt"), ""},
+		{"inside root", rooted, filepath.Join(project, "notes.txt"), filepath.Join(project, "notes.txt"), ""},
+		{"outside root", rooted, filepath.Join(outside, "data.txt"), "", ErrPermission},
+		{"link out of root", rooted, filepath.Join(project, "data.txt"), "", ErrPermission},
+		{"root itself", rooted, project, project, ""},
+		{"home root", FileAccessPolicy{AllowedRoots: []string{"~/project"}}, filepath.Join(project, "notes.txt"), filepath.Join(project, "notes.txt"), ""},
+		{"missing file", defaultPolicy, filepath.Join(project, "missing.txt"), "", ErrInput},
+		{"nothing denied", FileAccessPolicy{}, filepath.Join(project, ".env"), filepath.Join(project, ".env"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Check(tt.file)
+			if tt.wantErr != "" {
+				if !IsErrorType(err, tt.wantErr) {
+					t.Errorf("Check(%q) error = %v, want %v", tt.file, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Check(%q) error = %v", tt.file, err)
+			}
+			if got != tt.want {
+				t.Errorf("Check(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithin(t *testing.T) {
+	root := filepath.FromSlash("/srv/app")
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"/srv/app", true},
+		{"/srv/app/a/b.txt", true},
+		{"/srv/application/b.txt", false},
+		{"/srv/app/../other/b.txt", false},
+		{"/srv/app/..data/b.txt", true},
+		{"/srv", false},
+	}
+	for _, tt := range tests {
+		if got := within(filepath.Clean(filepath.FromSlash(tt.file)), root); got != tt.want {
+			t.Errorf("within(%q, %q) = %v, want %v", tt.file, root, got, tt.want)
+		}
+	}
+}
@@ -45,6 +45,10 @@ type Chat interface {
 	// SendWithFiles sends a message with file attachments
 	SendWithFiles(ctx context.Context, message string, files []string) (Response, error)
 
+	// SendWithAttachments sends a message with file attachments and
+	// in-memory content such as data piped on stdin
+	SendWithAttachments(ctx context.Context, message string, files []string, contents []*FileContent) (Response, error)
+
 	// Close cleans up resources
 	Close() error
 }
@@ -60,6 +64,10 @@ type Provider interface {
 	// GetModelInfo returns model configuration
 	GetModelInfo(model string) (ModelInfo, error)
 
+	// CountTokens counts the tokens text is to a model with the
+	// provider's tokenizer
+	CountTokens(ctx context.Context, model string, text string) (int, error)
+
 	// ValidateConfig checks configuration
 	ValidateConfig() error
 
@@ -67,17 +75,34 @@ type Provider interface {
 	Close() error
 }
 
+// ContextCollector gathers information about the environment a request
+// is made in, such as the project type and installed tools
+type ContextCollector interface {
+	// Collect returns the context to send with a request, or ""
+	Collect(ctx context.Context, request string) string
+}
+
+// Redactor replaces secrets and personal data in text sent to a provider
+// with placeholders, and puts the values back in what it returns
+type Redactor interface {
+	// Redact returns text with sensitive values replaced by placeholders
+	Redact(text string) string
+
+	// Restore returns text with placeholders replaced by their values
+	Restore(text string) string
+}
+
 // ModelInfo contains model configuration and capabilities
 type ModelInfo struct {
-	Name             string        `json:"name"`
-	InputTokenLimit  int           `json:"input_token_limit"`
-	OutputTokenLimit int           `json:"output_token_limit"`
-	RPM              int           `json:"rpm"`      // Requests per minute
-	TPM              int           `json:"tpm"`      // Tokens per minute
-	RPD              int           `json:"rpd"`      // Requests per day
-	Features         []string      `json:"features"` // Supported features
-	Timeout          time.Duration `json:"timeout"`  // Default timeout
-	IsPaid           bool          `json:"is_paid"`
+	Name             string        `json:"name" yaml:"name"`
+	InputTokenLimit  int           `json:"input_token_limit" yaml:"input_token_limit"`
+	OutputTokenLimit int           `json:"output_token_limit" yaml:"output_token_limit"`
+	RPM              int           `json:"rpm" yaml:"rpm"`           // Requests per minute
+	TPM              int           `json:"tpm" yaml:"tpm"`           // Tokens per minute
+	RPD              int           `json:"rpd" yaml:"rpd"`           // Requests per day
+	Features         []string      `json:"features" yaml:"features"` // Supported features
+	Timeout          time.Duration `json:"timeout" yaml:"timeout"`   // Default timeout
+	IsPaid           bool          `json:"is_paid" yaml:"is_paid"`
 }
 
 // FileProcessor handles different file types
@@ -162,19 +187,8 @@ func (e *CommandExecutor) ValidateCommand(command string) error {
 	}
 
 	// Check for dangerous commands
-	dangerousCommands := []string{
-		"rm -rf", "rmdir /s", "del /f",
-		"format", "mkfs",
-		":(){:|:&};:", // Fork bomb
-		"dd",
-		"> /dev/sda",
-		"chmod -R 777",
-	}
-
-	for _, dangerous := range dangerousCommands {
-		if strings.Contains(strings.ToLower(command), strings.ToLower(dangerous)) {
-			return ErrPermissionf("potentially dangerous command detected: %s", dangerous)
-		}
+	if dangerous := findDangerousCommand(command); dangerous != "" {
+		return ErrPermissionf("potentially dangerous command detected: %s", dangerous)
 	}
 
 	// Check if command requires privileges
@@ -187,6 +201,51 @@ func (e *CommandExecutor) ValidateCommand(command string) error {
 	return nil
 }
 
+// dangerousCommands lists command fragments that are refused outright
+var dangerousCommands = []string{
+	"rm -rf", "rmdir /s", "del /f",
+	"format", "mkfs",
+	":(){:|:&};:", // Fork bomb
+	"dd",
+	"> /dev/sda",
+	"chmod -R 777",
+}
+
+// findDangerousCommand returns the dangerous fragment contained in command
+func findDangerousCommand(command string) string {
+	for _, dangerous := range dangerousCommands {
+		if strings.Contains(strings.ToLower(command), strings.ToLower(dangerous)) {
+			return dangerous
+		}
+	}
+	return ""
+}
+
+// RiskLevel describes how risky a command is to run
+type RiskLevel string
+
+const (
+	RiskNone   RiskLevel = "none"
+	RiskLow    RiskLevel = "low"
+	RiskMedium RiskLevel = "medium"
+	RiskHigh   RiskLevel = "high"
+)
+
+// AssessRisk rates a command using the same checks as ValidateCommand
+func AssessRisk(command string) RiskLevel {
+	command = strings.TrimSpace(command)
+	switch {
+	case command == "":
+		return RiskNone
+	case findDangerousCommand(command) != "":
+		return RiskHigh
+	case IsPrivilegedOperation(command):
+		return RiskMedium
+	default:
+		return RiskLow
+	}
+}
+
 // GetHistory returns command execution history
 func (e *CommandExecutor) GetHistory() []CommandHistory {
 	return e.history
@@ -199,14 +258,21 @@ func (e *CommandExecutor) ClearHistory() {
 
 // CommandHistory tracks command execution
 type CommandHistory struct {
-	Command   string    `json:"command"`
-	Output    string    `json:"output"`
-	ExitCode  int       `json:"exit_code"`
-	StartTime time.Time `json:"start_time"`
-	EndTime   time.Time `json:"end_time"`
-	Error     string    `json:"error,omitempty"`
+	Command   string    `json:"command" yaml:"command"`
+	Output    string    `json:"output" yaml:"output"`
+	ExitCode  int       `json:"exit_code" yaml:"exit_code"`
+	StartTime time.Time `json:"start_time" yaml:"start_time"`
+	EndTime   time.Time `json:"end_time" yaml:"end_time"`
+	Error     string    `json:"error,omitempty" yaml:"error,omitempty"`
+	Source    string    `json:"source,omitempty" yaml:"source,omitempty"` // Where the command was run
 }
 
+// Command history sources
+const (
+	HistorySourceCraftCom = "craftcom"
+	HistorySourceShell    = "shell"
+)
+
 // RateLimiter handles API rate limiting
 type RateLimiter interface {
 	// CheckLimit checks if operation is within limits
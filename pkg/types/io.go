@@ -25,7 +25,6 @@ import (
 	"context"
 	"encoding/base64"
 	"io"
-	"mime"
 	"os"
 	"path/filepath"
 	"strings"
@@ -52,35 +51,41 @@ type FileContent struct {
 	Metadata map[string]interface{}
 }
 
+// DefaultAllowedFileTypes allows every file type that can be attached
+var DefaultAllowedFileTypes = []string{
+	string(FileTypeText),
+	string(FileTypeImage),
+	string(FileTypeAudio),
+	string(FileTypeVideo),
+	string(FileTypePDF),
+}
+
 // FileReader reads and processes different file types
 type FileReader struct {
-	allowedBasePath string
-	MaxSize         int64
-	AllowedTypes    map[FileType][]string // map of file type to allowed extensions
+	Policy       FileAccessPolicy
+	MaxSize      int64
+	AllowedTypes []string // file types such as "text", or extensions such as ".go"
 }
 
 // NewFileReader creates a new FileReader with default settings
 func NewFileReader() *FileReader {
 	return &FileReader{
-		MaxSize: 100 * 1024 * 1024, // 100MB default
-		AllowedTypes: map[FileType][]string{
-			FileTypeText:  {".txt", ".md", ".json", ".yaml", ".yml"},
-			FileTypeImage: {".png", ".jpg", ".jpeg", ".gif", ".webp"},
-			FileTypeAudio: {".mp3", ".wav", ".ogg", ".m4a"},
-			FileTypePDF:   {".pdf"},
-			FileTypeVideo: {".mp4", ".webm", ".mov"},
-		},
+		Policy:       DefaultFileAccessPolicy(),
+		MaxSize:      100 * 1024 * 1024, // 100MB default
+		AllowedTypes: append([]string(nil), DefaultAllowedFileTypes...),
 	}
 }
 
 // ReadFile reads and processes a file
 func (fr *FileReader) ReadFile(ctx context.Context, path string) (*FileContent, error) {
-	// Sanitize and validate path
-	cleanPath := filepath.Clean(path)
-	if !strings.HasPrefix(cleanPath, fr.allowedBasePath) {
-		return nil, ErrInputf("path is outside allowed directory: %s", path)
+	path, pages := SplitPageRange(path)
+
+	// Resolve symlinks and check the access policy
+	resolved, err := fr.Policy.Check(path)
+	if err != nil {
+		return nil, err
 	}
-	info, err := os.Stat(path)
+	info, err := os.Stat(resolved)
 	if err != nil {
 		return nil, ErrInputf("failed to stat file: %v", err)
 	}
@@ -89,26 +94,27 @@ func (fr *FileReader) ReadFile(ctx context.Context, path string) (*FileContent,
 		return nil, ErrInputf("file too large: %d bytes (max %d)", info.Size(), fr.MaxSize)
 	}
 
-	// Determine file type
-	ext := filepath.Ext(path)
-	fileType, err := fr.determineFileType(ext)
+	// Read file
+	data, err := os.ReadFile(resolved)
 	if err != nil {
-		return nil, err
+		return nil, ErrInputf("failed to read file: %v", err)
 	}
 
-	// Read file
-	data, err := os.ReadFile(path)
+	// Determine file type from the content, extensions can't be trusted
+	ext := strings.ToLower(filepath.Ext(path))
+	fileType, mimeType, err := DetectFileType(data)
 	if err != nil {
-		return nil, ErrInputf("failed to read file: %v", err)
+		return nil, err
+	}
+	if !fr.isAllowed(fileType, ext) {
+		return nil, ErrInputf("%s files are not allowed: %s (allowed: %s)", fileType, path, strings.Join(fr.AllowedTypes, ", "))
 	}
 
-	// Get MIME type
-	mimeType := mime.TypeByExtension(ext)
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	if pages != "" && fileType != FileTypePDF {
+		return nil, ErrInputf("page ranges only apply to PDF files: %s", path)
 	}
 
-	return &FileContent{
+	content := &FileContent{
 		Type:     fileType,
 		Data:     data,
 		MimeType: mimeType,
@@ -118,20 +124,42 @@ func (fr *FileReader) ReadFile(ctx context.Context, path string) (*FileContent,
 			"extension": ext,
 			"modified":  info.ModTime(),
 		},
-	}, nil
+	}
+	if pages != "" {
+		content.Metadata["pages"] = pages
+	}
+	return content, nil
+}
+
+// SplitPageRange splits the pages selected from a PDF off a path, as in
+// "report.pdf#pages=2-5" or "report.pdf#page=3". A path naming an existing
+// file is returned whole.
+func SplitPageRange(path string) (string, string) {
+	i := strings.LastIndex(path, "#page")
+	if i < 0 {
+		return path, ""
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, ""
+	}
+	for _, prefix := range []string{"#pages=", "#page="} {
+		if strings.HasPrefix(path[i:], prefix) {
+			return path[:i], path[i+len(prefix):]
+		}
+	}
+	return path, ""
 }
 
-// determineFileType identifies the file type based on extension
-func (fr *FileReader) determineFileType(ext string) (FileType, error) {
-	ext = filepath.Ext(ext)
-	for fileType, extensions := range fr.AllowedTypes {
-		for _, allowedExt := range extensions {
-			if ext == allowedExt {
-				return fileType, nil
-			}
+// isAllowed reports whether a file of a type and extension may be read,
+// either because its type is allowed or its extension is and the content
+// is of the type the extension names
+func (fr *FileReader) isAllowed(fileType FileType, ext string) bool {
+	for _, allowed := range fr.AllowedTypes {
+		if allowed == string(fileType) || (ext != "" && strings.EqualFold(allowed, ext) && extensionType(ext) == fileType) {
+			return true
 		}
 	}
-	return "", ErrInputf("unsupported file extension: %s", ext)
+	return false
 }
 
 // ToBase64 converts file content to base64
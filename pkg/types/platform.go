@@ -26,6 +26,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -93,7 +94,7 @@ func detectShell() string {
 	default: // Unix-like systems
 		// Try to detect from process
 		if pid := os.Getppid(); pid != 0 {
-			if bytes, err := os.ReadFile(filepath.Join("/proc", string(pid), "cmdline")); err == nil {
+			if bytes, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline")); err == nil {
 				cmdline := string(bytes)
 				for _, shell := range []string{"bash", "zsh", "fish", "sh"} {
 					if strings.Contains(cmdline, shell) {
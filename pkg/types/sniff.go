@@ -0,0 +1,193 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package types
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLength is how much of a file is inspected to detect its type
+const sniffLength = 8192
+
+// signature identifies a file format by the bytes at an offset
+type signature struct {
+	offset   int
+	magic    string
+	fileType FileType
+	mimeType string
+}
+
+// signatures of the formats that can be attached. More specific entries
+// come first.
+var signatures = []signature{
+	{0, "\x89PNG\r\n\x1a\n", FileTypeImage, "image/png"},
+	{0, "\xff\xd8\xff", FileTypeImage, "image/jpeg"},
+	{0, "GIF87a", FileTypeImage, "image/gif"},
+	{0, "GIF89a", FileTypeImage, "image/gif"},
+	{8, "WEBP", FileTypeImage, "image/webp"},
+	{0, "%PDF-", FileTypePDF, "application/pdf"},
+	{8, "WAVE", FileTypeAudio, "audio/wav"},
+	{0, "OggS", FileTypeAudio, "audio/ogg"},
+	{0, "ID3", FileTypeAudio, "audio/mpeg"},
+	{0, "fLaC", FileTypeAudio, "audio/flac"},
+	{4, "ftypM4A", FileTypeAudio, "audio/mp4"},
+	{4, "ftypqt", FileTypeVideo, "video/quicktime"},
+	{4, "ftyp", FileTypeVideo, "video/mp4"},
+	{0, "\x1a\x45\xdf\xa3", FileTypeVideo, "video/webm"},
+}
+
+// extensionTypes are the types files with these extensions hold. Other
+// extensions are taken to name text files.
+var extensionTypes = map[string]FileType{
+	".png": FileTypeImage, ".jpg": FileTypeImage, ".jpeg": FileTypeImage,
+	".gif": FileTypeImage, ".webp": FileTypeImage,
+	".pdf": FileTypePDF,
+	".mp3": FileTypeAudio, ".wav": FileTypeAudio, ".ogg": FileTypeAudio,
+	".oga": FileTypeAudio, ".opus": FileTypeAudio, ".flac": FileTypeAudio,
+	".m4a": FileTypeAudio, ".aac": FileTypeAudio,
+	".mp4": FileTypeVideo, ".m4v": FileTypeVideo, ".mov": FileTypeVideo,
+	".webm": FileTypeVideo, ".mkv": FileTypeVideo,
+}
+
+// extensionType returns the type of file an extension names
+func extensionType(ext string) FileType {
+	if fileType, ok := extensionTypes[strings.ToLower(ext)]; ok {
+		return fileType
+	}
+	return FileTypeText
+}
+
+// binarySignatures are common formats that cannot be attached, named in
+// the error
+var binarySignatures = []struct {
+	magic string
+	name  string
+}{
+	{"\x7fELF", "ELF executable"},
+	{"MZ", "Windows executable"},
+	{"\xcf\xfa\xed\xfe", "Mach-O executable"},
+	{"\xca\xfe\xba\xbe", "Mach-O or Java class file"},
+	{"PK\x03\x04", "zip archive"},
+	{"\x1f\x8b", "gzip archive"},
+	{"BZh", "bzip2 archive"},
+	{"\xfd7zXZ\x00", "xz archive"},
+	{"\x28\xb5\x2f\xfd", "zstd archive"},
+	{"7z\xbc\xaf\x27\x1c", "7z archive"},
+	{"Rar!", "rar archive"},
+	{"SQLite format 3\x00", "SQLite database"},
+	{"\x00asm", "WebAssembly module"},
+}
+
+// DetectFileType identifies the type and MIME type of data from its
+// leading bytes. Valid UTF-8 without control characters is text, other
+// data that is none of the supported types is refused.
+func DetectFileType(data []byte) (FileType, string, error) {
+	head := data
+	if len(head) > sniffLength {
+		head = head[:sniffLength]
+	}
+
+	for _, sig := range signatures {
+		if len(head) >= sig.offset+len(sig.magic) && string(head[sig.offset:sig.offset+len(sig.magic)]) == sig.magic {
+			// RIFF containers hold both WAV and WebP
+			if sig.offset == 8 && !bytes.HasPrefix(head, []byte("RIFF")) {
+				continue
+			}
+			return sig.fileType, sig.mimeType, nil
+		}
+	}
+	if isMPEGAudio(head) {
+		return FileTypeAudio, "audio/mpeg", nil
+	}
+
+	for _, sig := range binarySignatures {
+		if bytes.HasPrefix(head, []byte(sig.magic)) {
+			return "", "", ErrInputf("binary file (%s) cannot be attached", sig.name)
+		}
+	}
+
+	if isText(head, len(data) > len(head)) {
+		return FileTypeText, "text/plain; charset=utf-8", nil
+	}
+	return "", "", ErrInputf("binary file cannot be attached")
+}
+
+// isMPEGAudio reports whether data starts with an MPEG audio frame header
+// without ID3 tags
+func isMPEGAudio(data []byte) bool {
+	if len(data) < 4 || data[0] != 0xff || data[1]&0xe0 != 0xe0 {
+		return false
+	}
+	version, layer := (data[1]>>3)&3, (data[1]>>1)&3
+	bitrate, rate := data[2]>>4, (data[2]>>2)&3
+	return version != 1 && layer != 0 && bitrate != 0 && bitrate != 15 && rate != 3
+}
+
+// isText reports whether data looks like text: valid UTF-8, allowing a
+// rune cut off at the end of a truncated sample, with no NUL bytes and few
+// control characters other than whitespace
+func isText(data []byte, truncated bool) bool {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	control := 0
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size <= 1 {
+			if truncated && !utf8.FullRune(data[i:]) {
+				break
+			}
+			return false
+		}
+		switch {
+		case r == 0:
+			return false
+		case r < 0x20 && r != '\n' && r != '\r' && r != '\t' && r != '\f' && r != '\b' && r != 0x1b:
+			control++
+		case r == 0x7f:
+			control++
+		}
+		i += size
+	}
+	// Escape sequences in logs are fine, a file of control bytes is not
+	return control*100 <= len(data)
+}
+
+// DetectFileTypeOf identifies the type of a file from its leading bytes
+// without reading all of it
+func DetectFileTypeOf(path string) (FileType, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", ErrInputf("failed to read file: %v", err)
+	}
+	defer f.Close()
+
+	// One byte more tells DetectFileType the sample is truncated
+	head := make([]byte, sniffLength+1)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", ErrInputf("failed to read file: %v", err)
+	}
+	fileType, _, err := DetectFileType(head[:n])
+	return fileType, err
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2024 TruthOS
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package types
+
+import (
+	"context"
+	"strings"
+)
+
+// CharsPerToken is about how many characters of text a token holds, for
+// sizing text before it can be counted
+const CharsPerToken = 4
+
+// TokenCounter counts the tokens of text with a model's tokenizer
+type TokenCounter func(ctx context.Context, text string) (int, error)
+
+// EstimateTokens estimates the tokens of text from its words and length
+func EstimateTokens(text string) int {
+	words := len(strings.Fields(text))
+	characters := len(text)
+
+	wordBasedEstimate := float64(words) * 1.3
+	charBasedEstimate := float64(characters) / CharsPerToken
+
+	return int((wordBasedEstimate + charBasedEstimate) / 2)
+}
+
+// CountTokens counts the tokens of text with count, or estimates them when
+// there is no counter or counting fails, reporting whether it estimated
+func CountTokens(ctx context.Context, count TokenCounter, text string) (int, bool) {
+	if count != nil {
+		if tokens, err := count(ctx, text); err == nil {
+			return tokens, false
+		}
+	}
+	return EstimateTokens(text), true
+}
+
+// CharsPerTokenOf measures how many characters a token of text holds,
+// counting its tokens with count
+func CharsPerTokenOf(ctx context.Context, count TokenCounter, text string) float64 {
+	tokens, _ := CountTokens(ctx, count, text)
+	if tokens <= 0 {
+		return CharsPerToken
+	}
+	return float64(len(text)) / float64(tokens)
+}